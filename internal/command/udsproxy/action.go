@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/lwmacct/251124-uds-proxy/internal/filewatch"
 	"github.com/lwmacct/251124-uds-proxy/internal/proxy"
 	"github.com/lwmacct/251207-go-pkg-cfgm/pkg/cfgm"
 	"github.com/lwmacct/251207-go-pkg-version/pkg/version"
@@ -24,7 +25,8 @@ import (
 func action(ctx context.Context, cmd *cli.Command) error {
 	logm.MustInit(logm.PresetAuto()...)
 
-	cfg := cfgm.MustLoadCmd(cmd, config.DefaultConfig(), version.GetAppRawName())
+	appName := version.GetAppRawName()
+	cfg := cfgm.MustLoadCmd(cmd, config.DefaultConfig(), appName)
 
 	// 启动服务器
 	server, err := proxy.NewServer(cfg)
@@ -32,16 +34,59 @@ func action(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	// 优雅关闭
+	// 重新加载配置：按启动时相同的优先级重新读取，无效时保留当前配置
+	reload := func() {
+		newCfg, err := cfgm.LoadCmd(cmd, config.DefaultConfig(), appName)
+		if err != nil {
+			slog.Error("读取配置失败，继续使用当前配置", "error", err)
+
+			return
+		}
+
+		if _, err := server.Reload(newCfg); err != nil {
+			slog.Error("配置无效，继续使用当前配置", "error", err)
+		}
+	}
+
+	if cfg.WatchConfig {
+		if file := configFile(appName); file == "" {
+			slog.Warn("未找到配置文件，无法监听配置变更")
+		} else if watcher, err := filewatch.Watch(file, configReloadDelay, reload); err != nil {
+			slog.Warn("监听配置文件失败，可发送 SIGHUP 重新加载", "file", file, "error", err)
+		} else {
+			slog.Info("监听配置文件变更", "file", file)
+
+			defer func() { _ = watcher.Close() }()
+		}
+	}
+
+	// SIGHUP 重新加载配置，SIGINT/SIGTERM 优雅关闭，关闭过程中再次收到则立即退出
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	defer signal.Stop(sigChan)
 
 	go func() {
-		sig := <-sigChan
-		slog.Info("收到关闭信号", "signal", sig.String())
-		server.Shutdown()
-		os.Exit(0)
+		shuttingDown := false
+
+		for sig := range sigChan {
+			switch {
+			case sig == syscall.SIGHUP:
+				slog.Info("收到重新加载信号", "signal", sig.String())
+				reload()
+			case shuttingDown:
+				slog.Warn("再次收到关闭信号，立即退出", "signal", sig.String())
+				os.Exit(1)
+			default:
+				slog.Info("收到关闭信号，开始优雅关闭", "signal", sig.String())
+
+				shuttingDown = true
+
+				go server.Shutdown()
+			}
+		}
 	}()
 
+	// Run returns nil once Shutdown has finished draining
 	return server.Run()
 }
@@ -0,0 +1,38 @@
+package udsproxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/proxy"
+	"github.com/urfave/cli/v3"
+)
+
+// verifyAuditCommand 校验审计日志的哈希链。
+var verifyAuditCommand = &cli.Command{
+	Name:      "verify-audit",
+	Usage:     "verify the hash chain of an audit log file",
+	ArgsUsage: "<file>",
+	Action: func(_ context.Context, cmd *cli.Command) error {
+		if cmd.NArg() != 1 {
+			return fmt.Errorf("expected exactly one audit log file, got %d arguments", cmd.NArg())
+		}
+
+		f, err := os.Open(cmd.Args().First())
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = f.Close() }()
+
+		n, err := proxy.VerifyAuditLog(f)
+		if err != nil {
+			return fmt.Errorf("audit log verification failed after %d valid entries: %w", n, err)
+		}
+
+		_, _ = fmt.Fprintf(cmd.Root().Writer, "%d entries verified\n", n)
+
+		return nil
+	},
+}
@@ -14,7 +14,7 @@ var Command = &cli.Command{
 	Name:     "uds-proxy",
 	Usage:    "HTTP server that proxies requests to Unix domain sockets",
 	Action:   action,
-	Commands: []*cli.Command{version.Command},
+	Commands: []*cli.Command{version.Command, verifyAuditCommand},
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:    "host",
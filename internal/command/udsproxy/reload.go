@@ -0,0 +1,38 @@
+package udsproxy
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lwmacct/251207-go-pkg-cfgm/pkg/cfgm"
+)
+
+// configReloadDelay 是配置文件最后一次变更到重新加载之间的等待时间，
+// 避免编辑器保存时的多次写入触发多次加载或读到不完整的文件。
+const configReloadDelay = 200 * time.Millisecond
+
+// configFile 返回 cfgm 加载的配置文件路径，未找到时返回空字符串。
+//
+// cfgm 不返回实际加载的文件，这里按与 cfgm 相同的规则搜索：
+// 依次检查 [cfgm.DefaultPaths]，相对路径以项目根目录为基准（找不到时为当前目录），
+// 返回第一个存在的文件。
+func configFile(appName string) string {
+	baseDir, _ := cfgm.FindProjectRoot(0)
+
+	for _, path := range cfgm.DefaultPaths(appName) {
+		if baseDir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			if abs, err := filepath.Abs(path); err == nil {
+				return abs
+			}
+
+			return path
+		}
+	}
+
+	return ""
+}
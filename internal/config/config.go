@@ -11,10 +11,169 @@ type Config struct {
 	Host         string `koanf:"host" comment:"监听地址，如 '127.0.0.1' 仅本地，'0.0.0.0' 所有接口"`
 	Port         int    `koanf:"port" comment:"监听端口，0 表示自动分配"`
 	PortFile     string `koanf:"port_file" comment:"写入实际端口号的文件路径"`
-	Timeout      int    `koanf:"timeout" comment:"请求超时时间 (毫秒)"`
+	Timeout      int    `koanf:"timeout" comment:"连接套接字、等待响应头和传输非流式响应体各自的超时时间 (毫秒)，0 表示不限制"`
 	MaxConns     int    `koanf:"max_conns" comment:"每个 Unix 套接字的最大连接数"`
 	MaxIdleConns int    `koanf:"max_idle_conns" comment:"每个 Unix 套接字的最大空闲连接数"`
 	NoAccessLog  bool   `koanf:"no_access_log" comment:"禁用访问日志"`
+	WatchConfig  bool   `koanf:"watch_config" comment:"监听配置文件变更并自动重新加载，也可发送 SIGHUP 手动重新加载"`
+
+	ProblemDetails bool `koanf:"problem_details" comment:"网关错误返回 RFC 9457 problem details 响应体，关闭时仅请求 Accept: application/problem+json 的客户端获得响应体"`
+
+	MaxClients        int  `koanf:"max_clients" comment:"连接池最多保留的客户端（套接字或上游）数量，超出时移除最久未使用的，0 表示不限制"`
+	ClientIdleTimeout int  `koanf:"client_idle_timeout" comment:"客户端空闲多久后移出连接池 (毫秒)，0 表示不清理"`
+	WatchSockets      bool `koanf:"watch_sockets" comment:"通过 inotify 监听套接字所在目录，后端重建套接字时立即回收空闲连接，关闭时每次请求检查 inode"`
+
+	ShutdownDelay   int `koanf:"shutdown_delay" comment:"收到关闭信号后 /health 返回 503 并继续服务的时间 (毫秒)，便于负载均衡器摘除流量"`
+	ShutdownTimeout int `koanf:"shutdown_timeout" comment:"关闭时等待正在处理的请求完成的最长时间 (毫秒)，超时后强制关闭连接"`
+
+	Listeners []ListenerConfig `koanf:"listeners" comment:"监听器列表，为空时监听 host:port"`
+
+	AllowedSockets []string `koanf:"allowed_sockets" comment:"允许代理的套接字路径，支持 glob 模式，为空表示不限制"`
+	DeniedSockets  []string `koanf:"denied_sockets" comment:"禁止代理的套接字路径，支持 glob 模式，优先于 allowed_sockets"`
+
+	Upstreams map[string]string `koanf:"upstreams" comment:"命名上游，别名到套接字路径的映射，通过 /u/{别名}/{路径} 访问"`
+
+	Profiles ProfilesConfig `koanf:"profiles" comment:"Docker API 内置策略：docker-readonly、docker-no-exec、docker-no-privileged"`
+
+	Retry RetryConfig `koanf:"retry" comment:"后端请求失败重试，只重试幂等方法且在收到响应前失败的请求"`
+
+	Breaker BreakerConfig `koanf:"breaker" comment:"按套接字熔断，后端持续失败时直接返回 503"`
+
+	HealthCheck HealthCheckConfig `koanf:"health_check" comment:"定期主动探测上游套接字，结果通过 /health 和 /readyz 报告"`
+
+	AccessLog AccessLogConfig `koanf:"access_log" comment:"访问日志的格式、输出文件和过滤条件，no_access_log 为 true 时不记录"`
+
+	Concurrency ConcurrencyConfig `koanf:"concurrency" comment:"每个套接字同时转发的请求数限制和等待队列"`
+
+	RateLimit RateLimitConfig `koanf:"rate_limit" comment:"令牌桶限流，按客户端或目标套接字限制代理请求的速率"`
+
+	Audit AuditConfig `koanf:"audit" comment:"审计日志，记录经代理发往后端的非 GET/HEAD/OPTIONS 请求，配置 file 后启用"`
+
+	Metrics MetricsConfig `koanf:"metrics" comment:"Prometheus 指标"`
+
+	Tracing TracingConfig `koanf:"tracing" comment:"OpenTelemetry 追踪，为入站请求和后端请求生成 span"`
+
+	Auth AuthConfig `koanf:"auth" comment:"Bearer Token / API Key 认证，配置任一令牌来源后启用"`
+
+	TLSCert     string `koanf:"tls_cert" comment:"TLS 证书文件路径，与 tls_key 同时设置时启用 HTTPS，轮换后自动重新加载"`
+	TLSKey      string `koanf:"tls_key" comment:"TLS 私钥文件路径"`
+	TLSClientCA string `koanf:"tls_client_ca" comment:"客户端证书 CA 文件路径，设置后启用 mTLS，证书主题作为身份标识"`
+}
+
+// ListenerConfig 监听器配置
+type ListenerConfig struct {
+	Network string `koanf:"network" comment:"网络类型，tcp 或 unix"`
+	Address string `koanf:"address" comment:"监听地址，tcp 为 'host:port'，unix 为套接字路径，以 '@' 开头表示抽象命名空间"`
+	Mode    string `koanf:"mode" comment:"unix 套接字文件权限，八进制字符串，如 '0660'"`
+	Owner   string `koanf:"owner" comment:"unix 套接字文件属主，用户名或 UID"`
+	Group   string `koanf:"group" comment:"unix 套接字文件属组，组名或 GID"`
+}
+
+// ProfilesConfig Docker API 策略配置
+// 同时适用多条策略时，请求必须通过所有策略
+type ProfilesConfig struct {
+	Upstreams  map[string][]string `koanf:"upstreams" comment:"上游别名到策略列表的映射，通过 /proxy 访问相同套接字时同样生效"`
+	Identities map[string][]string `koanf:"identities" comment:"认证身份标识到策略列表的映射"`
+}
+
+// RetryConfig 重试配置
+type RetryConfig struct {
+	MaxAttempts      int  `koanf:"max_attempts" comment:"最多尝试次数（含首次），1 表示不重试"`
+	Backoff          int  `koanf:"backoff" comment:"首次重试前的等待时间 (毫秒)，之后每次翻倍"`
+	MaxBackoff       int  `koanf:"max_backoff" comment:"重试等待时间上限 (毫秒)，0 表示不限制"`
+	IdempotentWrites bool `koanf:"idempotent_writes" comment:"同时重试 PUT 和 DELETE 请求，后端需保证其幂等"`
+}
+
+// BreakerConfig 熔断器配置
+type BreakerConfig struct {
+	Enabled             bool `koanf:"enabled" comment:"是否启用熔断器"`
+	ConsecutiveTimeouts int  `koanf:"consecutive_timeouts" comment:"连续超时达到该次数时打开，0 表示不按超时判断"`
+	ErrorRate           int  `koanf:"error_rate" comment:"统计窗口内失败请求的百分比达到该值时打开，0 表示不按错误率判断"`
+	MinRequests         int  `koanf:"min_requests" comment:"按错误率判断所需的最少请求数"`
+	Window              int  `koanf:"window" comment:"错误率统计窗口 (毫秒)"`
+	OpenTimeout         int  `koanf:"open_timeout" comment:"打开后进入半开状态前的等待时间 (毫秒)"`
+	HalfOpenRequests    int  `koanf:"half_open_requests" comment:"半开状态下同时放行的探测请求数"`
+}
+
+// HealthCheckConfig 上游健康检查配置
+type HealthCheckConfig struct {
+	Interval  int                      `koanf:"interval" comment:"探测间隔 (毫秒)，0 表示不探测"`
+	Timeout   int                      `koanf:"timeout" comment:"单次探测超时时间 (毫秒)"`
+	Upstreams map[string]UpstreamCheck `koanf:"upstreams" comment:"按上游别名设置探测方式，未设置的上游只检查能否连接"`
+}
+
+// UpstreamCheck 单个上游的健康检查配置
+type UpstreamCheck struct {
+	Path     string `koanf:"path" comment:"HTTP 探测路径，如 Docker 的 /_ping，2xx/3xx 视为正常；为空时只检查能否连接"`
+	Required bool   `koanf:"required" comment:"探测失败时 /readyz 返回 503，/health 仍返回 200 和 degraded"`
+}
+
+// AccessLogConfig 访问日志配置
+type AccessLogConfig struct {
+	Format        string   `koanf:"format" comment:"日志格式：slog（随应用日志输出）、json、combined（Apache combined）或 template"`
+	Template      string   `koanf:"template" comment:"format 为 template 时使用的 Go text/template 模板，如 '{{.Method}} {{.Path}} {{.Status}} {{.SocketPath}}'"`
+	File          string   `koanf:"file" comment:"访问日志文件路径，为空时 slog 格式随应用日志输出、其他格式输出到标准输出"`
+	MaxSize       int      `koanf:"max_size" comment:"日志文件超过该大小 (MB) 时轮转"`
+	MaxBackups    int      `koanf:"max_backups" comment:"保留的轮转文件数量，0 表示全部保留"`
+	MaxAge        int      `koanf:"max_age" comment:"轮转文件的保留天数，0 表示不按时间删除"`
+	Compress      bool     `koanf:"compress" comment:"使用 gzip 压缩轮转文件"`
+	ExcludePaths  []string `koanf:"exclude_paths" comment:"不记录的请求路径，以 '/' 结尾时按前缀匹配，如 '/health'"`
+	ExcludeStatus []string `koanf:"exclude_status" comment:"不记录的响应状态码，如 '200' 或 '2xx'"`
+}
+
+// ConcurrencyConfig 每个套接字的并发限制配置
+type ConcurrencyConfig struct {
+	MaxInFlight        int      `koanf:"max_in_flight" comment:"每个套接字同时转发的最大请求数，0 表示不限制；名额用尽时请求进入等待队列。流式响应和协议升级在收到响应头后即释放名额，不计入"`
+	MaxQueue           int      `koanf:"max_queue" comment:"每个套接字等待队列的最大长度，队列已满时直接返回 503"`
+	QueueTimeout       int      `koanf:"queue_timeout" comment:"请求在等待队列中的最长时间 (ms)，超时返回 503，0 表示一直等待到客户端断开"`
+	PriorityIdentities []string `koanf:"priority_identities" comment:"优先出队的身份标识，同一优先级内按到达顺序出队"`
+}
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	Rules []RateLimitRule `koanf:"rules" comment:"限流规则，请求必须同时满足所有适用的规则，超出时返回 429"`
+}
+
+// RateLimitRule 一条令牌桶限流规则
+type RateLimitRule struct {
+	By      string   `koanf:"by" comment:"限流维度：client（认证身份，未认证时为客户端 IP）、ip（客户端 IP）或 socket（目标套接字）"`
+	Rate    float64  `koanf:"rate" comment:"每秒补充的令牌数，即长期允许的每秒请求数"`
+	Burst   int      `koanf:"burst" comment:"令牌桶容量，即允许的突发请求数，0 表示 rate 向上取整"`
+	Sockets []string `koanf:"sockets" comment:"只对这些上游别名或套接字路径（支持 glob 模式）生效，为空时对所有套接字生效"`
+	Paths   []string `koanf:"paths" comment:"只对这些目标路径前缀生效，忽略 Docker API 版本前缀，如 '/containers/json'，为空时对所有路径生效"`
+}
+
+// AuditConfig 审计日志配置
+type AuditConfig struct {
+	File         string   `koanf:"file" comment:"审计日志文件路径，JSON Lines 格式，只追加写入，为空时不启用"`
+	MaxBodySize  int      `koanf:"max_body_size" comment:"记录的请求体最大字节数，0 表示不记录请求体"`
+	RedactFields []string `koanf:"redact_fields" comment:"请求体中需要脱敏的字段名，不区分大小写，在任意层级匹配"`
+	Sync         string   `koanf:"sync" comment:"同步到磁盘的时机：always（每条记录）、interval（定期）或 none（由操作系统决定）"`
+	SyncInterval int      `koanf:"sync_interval" comment:"sync 为 interval 时的同步间隔 (ms)，必须为正数"`
+}
+
+// MetricsConfig Prometheus 指标配置
+type MetricsConfig struct {
+	Enabled bool   `koanf:"enabled" comment:"启用指标端点"`
+	Listen  string `koanf:"listen" comment:"指标服务的独立监听地址，同时提供 /admin 端点；为空时与代理共用监听地址，此时这些端点要求认证"`
+	Path    string `koanf:"path" comment:"指标端点路径"`
+}
+
+// TracingConfig OpenTelemetry 追踪配置
+type TracingConfig struct {
+	Exporter    string  `koanf:"exporter" comment:"导出方式：otlp-http、otlp-grpc、stdout 或 file，为空时不启用追踪"`
+	Endpoint    string  `koanf:"endpoint" comment:"OTLP 接收端地址，如 'localhost:4318' 或 'https://collector:4318'，为空时使用 OTEL_EXPORTER_OTLP_ENDPOINT 或默认地址"`
+	Insecure    bool    `koanf:"insecure" comment:"OTLP 导出使用明文连接，不使用 TLS"`
+	File        string  `koanf:"file" comment:"exporter 为 file 时写入的文件路径，每行一个 JSON 格式的 span"`
+	SampleRatio float64 `koanf:"sample_ratio" comment:"新 trace 的采样比例 (0-1)，客户端请求带有 traceparent 时沿用其采样决定"`
+	ServiceName string  `koanf:"service_name" comment:"上报的服务名称"`
+}
+
+// AuthConfig 认证配置
+type AuthConfig struct {
+	Tokens      map[string]string `koanf:"tokens" comment:"静态令牌，身份标识到令牌的映射"`
+	TokenFile   string            `koanf:"token_file" comment:"令牌文件路径，每行一条 '身份标识:令牌'，修改后自动重新加载"`
+	PublicPaths []string          `koanf:"public_paths" comment:"无需认证的路径，以 '/' 结尾时按前缀匹配"`
 }
 
 // DefaultConfig 返回默认配置
@@ -29,5 +188,106 @@ func DefaultConfig() Config {
 		MaxConns:     10,
 		MaxIdleConns: 5,
 		NoAccessLog:  false,
+		WatchConfig:  false,
+
+		ProblemDetails: false,
+
+		MaxClients:        256,
+		ClientIdleTimeout: 300000,
+		WatchSockets:      false,
+
+		ShutdownDelay:   0,
+		ShutdownTimeout: 5000,
+
+		Listeners: []ListenerConfig{},
+
+		AllowedSockets: []string{},
+		DeniedSockets:  []string{},
+
+		Upstreams: map[string]string{},
+
+		Profiles: ProfilesConfig{
+			Upstreams:  map[string][]string{},
+			Identities: map[string][]string{},
+		},
+
+		Retry: RetryConfig{
+			MaxAttempts:      1,
+			Backoff:          50,
+			MaxBackoff:       1000,
+			IdempotentWrites: false,
+		},
+
+		Breaker: BreakerConfig{
+			Enabled:             false,
+			ConsecutiveTimeouts: 3,
+			ErrorRate:           50,
+			MinRequests:         20,
+			Window:              10000,
+			OpenTimeout:         10000,
+			HalfOpenRequests:    1,
+		},
+
+		HealthCheck: HealthCheckConfig{
+			Interval:  10000,
+			Timeout:   2000,
+			Upstreams: map[string]UpstreamCheck{},
+		},
+
+		AccessLog: AccessLogConfig{
+			Format:        "slog",
+			Template:      "",
+			File:          "",
+			MaxSize:       100,
+			MaxBackups:    10,
+			MaxAge:        30,
+			Compress:      false,
+			ExcludePaths:  []string{},
+			ExcludeStatus: []string{},
+		},
+
+		Concurrency: ConcurrencyConfig{
+			MaxInFlight:        0,
+			MaxQueue:           100,
+			QueueTimeout:       5000,
+			PriorityIdentities: []string{},
+		},
+
+		RateLimit: RateLimitConfig{
+			Rules: []RateLimitRule{},
+		},
+
+		Audit: AuditConfig{
+			File:         "",
+			MaxBodySize:  4096,
+			RedactFields: []string{"password", "secret", "token", "Env"},
+			Sync:         "always",
+			SyncInterval: 1000,
+		},
+
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Listen:  "127.0.0.1:9090",
+			Path:    "/metrics",
+		},
+
+		Tracing: TracingConfig{
+			Exporter:    "",
+			Endpoint:    "",
+			Insecure:    false,
+			File:        "",
+			SampleRatio: 1,
+			ServiceName: "uds-proxy",
+		},
+
+		Auth: AuthConfig{
+			Tokens:      map[string]string{},
+			TokenFile:   "",
+			PublicPaths: []string{"/health", "/livez", "/readyz"},
+		},
+
+		TLSCert:     "",
+		TLSKey:      "",
+		TLSClientCA: "",
 	}
 }
@@ -0,0 +1,89 @@
+// Package filewatch 监听单个文件的变更，用于配置文件和令牌文件的自动重新加载。
+//
+// 监听的是文件所在目录而不是文件本身，以便支持原子替换（写入临时文件后重命名）
+// 和 Kubernetes ConfigMap、Secret 通过替换 "..data" 符号链接进行的更新。
+package filewatch
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher 监听一个文件，文件变更后稍作等待再调用回调。
+type Watcher struct {
+	file    string
+	watcher *fsnotify.Watcher
+	timer   *time.Timer
+	wg      sync.WaitGroup
+}
+
+// Watch 开始监听 file，最后一次变更 delay 之后调用 onChange。
+//
+// 等待期间的多次变更只触发一次调用，避免编辑器保存时的多次写入触发多次加载或读到不完整的文件。
+// 无法监听文件所在目录时返回错误。
+func Watch(file string, delay time.Duration, onChange func()) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		_ = watcher.Close()
+
+		return nil, err
+	}
+
+	w := &Watcher{
+		file:    filepath.Clean(file),
+		watcher: watcher,
+		timer:   time.AfterFunc(time.Hour, onChange),
+	}
+	w.timer.Stop()
+
+	w.wg.Go(func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if w.affects(event) {
+					w.timer.Reset(delay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				slog.Warn("文件监听出错", "file", file, "error", err)
+			}
+		}
+	})
+
+	return w, nil
+}
+
+// affects 报告目录事件是否可能改变文件内容。
+// Kubernetes 通过替换 "..data" 符号链接更新挂载的文件，因此以 ".." 开头的条目也视为相关。
+func (w *Watcher) affects(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+
+	return filepath.Clean(event.Name) == w.file || strings.HasPrefix(filepath.Base(event.Name), "..")
+}
+
+// Close 停止监听，尚未触发的回调不再调用。
+func (w *Watcher) Close() error {
+	err := w.watcher.Close()
+	w.wg.Wait()
+	w.timer.Stop()
+
+	return err
+}
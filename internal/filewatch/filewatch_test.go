@@ -0,0 +1,73 @@
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWatch 测试原子替换、Kubernetes 符号链接切换和无关文件的变更
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("a"), 0600))
+
+	var calls atomic.Int32
+
+	w, err := Watch(file, 20*time.Millisecond, func() { calls.Add(1) })
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = w.Close() })
+
+	t.Run("无关文件", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0600))
+		time.Sleep(100 * time.Millisecond)
+		assert.Zero(t, calls.Load())
+	})
+
+	t.Run("多次写入只触发一次", func(t *testing.T) {
+		tmp := file + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte("b"), 0600))
+		require.NoError(t, os.Rename(tmp, file))
+		require.NoError(t, os.WriteFile(file, []byte("c"), 0600))
+
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Kubernetes 符号链接切换", func(t *testing.T) {
+		require.NoError(t, os.Symlink(dir, filepath.Join(dir, "..data_tmp")))
+
+		require.Eventually(t, func() bool { return calls.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+// TestWatcher_affects 测试目录事件的过滤
+func TestWatcher_affects(t *testing.T) {
+	w := &Watcher{file: "/etc/app/config.yaml"}
+
+	tests := []struct {
+		name  string
+		event fsnotify.Event
+		want  bool
+	}{
+		{"写入文件", fsnotify.Event{Name: "/etc/app/config.yaml", Op: fsnotify.Write}, true},
+		{"重命名到文件", fsnotify.Event{Name: "/etc/app/config.yaml", Op: fsnotify.Create}, true},
+		{"只修改权限", fsnotify.Event{Name: "/etc/app/config.yaml", Op: fsnotify.Chmod}, false},
+		{"符号链接切换", fsnotify.Event{Name: "/etc/app/..data", Op: fsnotify.Create}, true},
+		{"其他文件", fsnotify.Event{Name: "/etc/app/other.yaml", Op: fsnotify.Write}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, w.affects(tt.event))
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// 访问日志格式。
+const (
+	accessFormatSlog     = "slog"
+	accessFormatJSON     = "json"
+	accessFormatCombined = "combined"
+	accessFormatTemplate = "template"
+)
+
+// combinedTimeFormat 是 Apache combined 日志格式的时间格式。
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessEntry 是一条访问日志。template 格式的模板可以引用它的全部字段。
+type accessEntry struct {
+	Time          time.Time     // 请求开始时间
+	RequestID     string        // 请求 ID
+	RemoteAddr    string        // 客户端地址
+	Method        string        // 客户端请求的 HTTP 方法
+	Path          string        // 请求路径
+	Query         string        // 原始查询字符串，不含 '?'
+	Proto         string        // 协议版本，如 HTTP/1.1
+	Status        int           // 响应状态码
+	Duration      time.Duration // 处理时间
+	BytesIn       int64         // 从客户端接收的字节数
+	BytesOut      int64         // 发送给客户端的字节数
+	Socket        string        // 上游别名或套接字路径，非代理请求为空
+	SocketPath    string        // 实际连接的套接字路径
+	URL           string        // 发往后端的路径和查询参数
+	Error         string        // 网关错误码
+	UpstreamError string        // 后端请求失败的原因
+	Identity      string        // 认证身份标识
+	Attempts      int           // 后端请求的尝试次数
+	Referer       string
+	UserAgent     string
+}
+
+// stdoutMu 串行化写入标准输出的访问日志行。
+var stdoutMu sync.Mutex
+
+// accessLogger 按配置的格式输出访问日志。
+//
+// slog 格式未配置文件时通过应用日志输出，格式随应用日志设置；其他情况写入文件或标准输出，
+// 文件按大小轮转。创建后不再修改，可以在多个请求间并发使用。
+type accessLogger struct {
+	cfg    config.AccessLogConfig
+	tmpl   *template.Template
+	logger *slog.Logger // slog 和 json 格式，为 nil 时使用应用日志
+	file   *accessLogFile
+	out    io.Writer   // combined 和 template 格式的输出
+	mu     *sync.Mutex // 保护 out，共用同一文件的实例共用同一把锁
+}
+
+// newAccessLogger 按 cfg 创建访问日志。
+// 日志文件和轮转设置与 prev 相同时复用 prev 的文件，避免重新加载时重复打开。
+// 格式为空时视为 slog。格式未知、模板无法解析或状态码过滤条件无效时返回错误。
+func newAccessLogger(cfg config.AccessLogConfig, prev *accessLogger) (*accessLogger, error) {
+	if cfg.Format == "" {
+		cfg.Format = accessFormatSlog
+	}
+
+	for _, pattern := range cfg.ExcludeStatus {
+		if !validStatusPattern(pattern) {
+			return nil, fmt.Errorf("invalid access log status filter %q, want a status code or a class like '5xx'", pattern)
+		}
+	}
+
+	l := &accessLogger{cfg: cfg}
+
+	switch cfg.Format {
+	case accessFormatSlog, accessFormatJSON, accessFormatCombined:
+	case accessFormatTemplate:
+		tmpl, err := template.New("access_log").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log template: %w", err)
+		}
+
+		l.tmpl = tmpl
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", cfg.Format)
+	}
+
+	var out io.Writer = os.Stdout
+
+	l.mu = &stdoutMu
+
+	if cfg.File != "" {
+		if prev != nil && prev.file != nil && sameAccessLogFile(cfg, prev.cfg) {
+			l.file, l.mu = prev.file, prev.mu
+		} else {
+			l.file = &accessLogFile{Logger: &lumberjack.Logger{
+				Filename:   cfg.File,
+				MaxSize:    cfg.MaxSize,
+				MaxBackups: cfg.MaxBackups,
+				MaxAge:     cfg.MaxAge,
+				Compress:   cfg.Compress,
+			}}
+			l.mu = &sync.Mutex{}
+		}
+
+		out = l.file
+	}
+
+	switch {
+	case cfg.Format == accessFormatJSON || (cfg.Format == accessFormatSlog && cfg.File != ""):
+		l.logger = slog.New(slog.NewJSONHandler(out, nil))
+	case cfg.Format != accessFormatSlog:
+		l.out = out
+	}
+
+	return l, nil
+}
+
+// sameAccessLogFile 报告两份配置的日志文件和轮转设置是否相同。
+func sameAccessLogFile(a, b config.AccessLogConfig) bool {
+	return a.File == b.File && a.MaxSize == b.MaxSize && a.MaxBackups == b.MaxBackups &&
+		a.MaxAge == b.MaxAge && a.Compress == b.Compress
+}
+
+// validStatusPattern 报告状态码过滤条件是否为三位状态码或 "5xx" 形式的状态码类别。
+func validStatusPattern(pattern string) bool {
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+
+	if pattern[1:] == "xx" {
+		return true
+	}
+
+	return isDigit(pattern[1]) && isDigit(pattern[2])
+}
+
+// isDigit 报告 c 是否为十进制数字。
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// skip 报告是否不记录该请求。
+func (l *accessLogger) skip(path string, status int) bool {
+	if matchPath(l.cfg.ExcludePaths, path) {
+		return true
+	}
+
+	code := strconv.Itoa(status)
+
+	for _, pattern := range l.cfg.ExcludeStatus {
+		if pattern == code || (pattern[1:] == "xx" && pattern[0] == code[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// log 输出一条访问日志。
+func (l *accessLogger) log(e *accessEntry) {
+	switch l.cfg.Format {
+	case accessFormatSlog, accessFormatJSON:
+		logger := l.logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+
+		logger.Info("access", e.attrs()...)
+	case accessFormatCombined:
+		l.write(e.combined())
+	case accessFormatTemplate:
+		var buf bytes.Buffer
+		if err := l.tmpl.Execute(&buf, e); err != nil {
+			slog.Warn("访问日志模板执行失败", "error", err)
+
+			return
+		}
+
+		buf.WriteByte('\n')
+		l.write(buf.Bytes())
+	}
+}
+
+// write 写入一行日志，并发请求的日志行不会交错。
+func (l *accessLogger) write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, _ = l.out.Write(line)
+}
+
+// acquire 登记一个使用该日志的请求，日志文件已关闭时返回 false。
+// 返回 true 时请求结束后必须调用 [accessLogger.release]。
+func (l *accessLogger) acquire() bool {
+	if l.file == nil {
+		return true
+	}
+
+	return l.file.acquire()
+}
+
+// release 结束一个请求对日志的使用。
+func (l *accessLogger) release() {
+	if l.file != nil {
+		l.file.release()
+	}
+}
+
+// Close 关闭日志文件。仍有请求使用该文件时，等最后一个请求结束后再关闭。
+func (l *accessLogger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
+
+// accessLogFile 是访问日志文件，复用同一文件的 accessLogger 共用同一实例。
+//
+// 它统计正在使用该文件的请求数：重新加载切换到其他文件后，
+// 仍在处理的旧请求可以继续写入，最后一个请求结束时才关闭文件，
+// 避免关闭后的写入重新打开旧文件而泄漏。
+type accessLogFile struct {
+	*lumberjack.Logger
+
+	mu     sync.Mutex
+	refs   int
+	closed bool
+}
+
+// acquire 登记一个使用该文件的请求，文件已关闭时返回 false。
+func (f *accessLogFile) acquire() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+
+	f.refs++
+
+	return true
+}
+
+// release 结束一个请求对文件的使用，文件已关闭且没有请求使用时关闭底层文件。
+func (f *accessLogFile) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.refs--
+	if f.closed && f.refs == 0 {
+		_ = f.Logger.Close()
+	}
+}
+
+// Close 关闭文件，之后 acquire 返回 false。仍有请求使用时延迟到最后一个请求结束。
+func (f *accessLogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+
+	f.closed = true
+	if f.refs > 0 {
+		return nil
+	}
+
+	return f.Logger.Close()
+}
+
+// attrs 返回 slog 和 json 格式的日志属性，空值省略。
+func (e *accessEntry) attrs() []any {
+	attrs := []any{
+		"method", e.Method,
+		"path", e.Path,
+		"status", e.Status,
+		"duration", e.Duration,
+		"request_id", e.RequestID,
+		"remote", e.RemoteAddr,
+		"bytes_in", e.BytesIn,
+		"bytes_out", e.BytesOut,
+	}
+
+	optional := []struct {
+		key   string
+		value string
+	}{
+		{"query", e.Query},
+		{"socket", e.Socket},
+		{"socket_path", e.SocketPath},
+		{"url", e.URL},
+		{"error", e.Error},
+		{"upstream_error", e.UpstreamError},
+		{"identity", e.Identity},
+	}
+
+	for _, o := range optional {
+		if o.value != "" {
+			attrs = append(attrs, o.key, o.value)
+		}
+	}
+
+	if e.Attempts > 1 {
+		attrs = append(attrs, "attempts", e.Attempts)
+	}
+
+	return attrs
+}
+
+// combined 返回 Apache combined 格式的日志行。
+// 认证身份记录在 %u 字段，按 URL 路径段的规则转义（如客户端证书主题中的空格记为 %20），
+// 使该字段不含空格和引号，日志分析工具可以按空格切分；响应体为空时字节数记为 "-"。
+func (e *accessEntry) combined() []byte {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+
+	target := e.Path
+	if e.Query != "" {
+		target += "?" + e.Query
+	}
+
+	size := "-"
+	if e.BytesOut > 0 {
+		size = strconv.FormatInt(e.BytesOut, 10)
+	}
+
+	return fmt.Appendf(nil, "%s - %s [%s] %q %d %s %q %q\n",
+		dash(host),
+		dash(url.PathEscape(e.Identity)),
+		e.Time.Format(combinedTimeFormat),
+		e.Method+" "+target+" "+e.Proto,
+		e.Status,
+		size,
+		dash(e.Referer),
+		dash(e.UserAgent),
+	)
+}
+
+// dash 在 s 为空时返回 "-"，用于 combined 格式的空字段。
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
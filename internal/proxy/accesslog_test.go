@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewAccessLogger 测试访问日志配置的校验
+func TestNewAccessLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.AccessLogConfig
+		wantErr bool
+	}{
+		{"默认格式", config.AccessLogConfig{}, false},
+		{"combined", config.AccessLogConfig{Format: accessFormatCombined}, false},
+		{"状态码和类别", config.AccessLogConfig{Format: accessFormatJSON, ExcludeStatus: []string{"204", "3xx"}}, false},
+		{"未知格式", config.AccessLogConfig{Format: "xml"}, true},
+		{"模板无法解析", config.AccessLogConfig{Format: accessFormatTemplate, Template: "{{.Method"}, true},
+		{"状态码不是三位", config.AccessLogConfig{ExcludeStatus: []string{"20"}}, true},
+		{"状态码类别无效", config.AccessLogConfig{ExcludeStatus: []string{"6xx"}}, true},
+		{"状态码含非数字", config.AccessLogConfig{ExcludeStatus: []string{"2x0"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newAccessLogger(tt.cfg, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestAccessLogger_skip 测试路径和状态码过滤
+func TestAccessLogger_skip(t *testing.T) {
+	l, err := newAccessLogger(config.AccessLogConfig{
+		ExcludePaths:  []string{"/health", "/static/"},
+		ExcludeStatus: []string{"304", "2xx"},
+	}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		path   string
+		status int
+		want   bool
+	}{
+		{"/health", http.StatusServiceUnavailable, true},
+		{"/static/app.js", http.StatusNotFound, true},
+		{"/healthz", http.StatusNotFound, false},
+		{"/proxy", http.StatusOK, true},
+		{"/proxy", http.StatusNoContent, true},
+		{"/proxy", http.StatusNotModified, true},
+		{"/proxy", http.StatusMovedPermanently, false},
+		{"/proxy", http.StatusBadGateway, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, l.skip(tt.path, tt.status), "%s %d", tt.path, tt.status)
+	}
+}
+
+// TestAccessEntry_combined 测试 Apache combined 格式
+func TestAccessEntry_combined(t *testing.T) {
+	e := &accessEntry{
+		Time:       time.Date(2024, 1, 15, 10, 30, 45, 0, time.FixedZone("", 8*3600)),
+		RemoteAddr: "192.168.1.10:51234",
+		Method:     http.MethodGet,
+		Path:       "/proxy",
+		Query:      "path=/var/run/docker.sock&url=/info",
+		Proto:      "HTTP/1.1",
+		Status:     http.StatusOK,
+		BytesOut:   1234,
+		Identity:   "ci",
+		UserAgent:  "curl/8.5.0",
+	}
+
+	assert.Equal(t,
+		`192.168.1.10 - ci [15/Jan/2024:10:30:45 +0800] "GET /proxy?path=/var/run/docker.sock&url=/info HTTP/1.1" 200 1234 "-" "curl/8.5.0"`+"\n",
+		string(e.combined()))
+
+	e.Identity = "CN=ci bot,O=Example \"Inc\""
+	assert.Contains(t, string(e.combined()), ` - CN=ci%20bot%2CO=Example%20%22Inc%22 [`)
+
+	e.RemoteAddr, e.Identity, e.BytesOut = "@", "", 0
+	assert.Contains(t, string(e.combined()), `@ - - [`)
+	assert.Contains(t, string(e.combined()), ` 200 - "-"`)
+}
+
+// TestServer_AccessLog 测试访问日志的字段、格式、输出文件和重新加载
+func TestServer_AccessLog(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+	refused := newDeadSocket(t)
+	file := filepath.Join(t.TempDir(), "access.log")
+
+	cfg := &config.Config{
+		Timeout:   1000,
+		Upstreams: map[string]string{"app": socketPath, "refused": refused},
+		AccessLog: config.AccessLogConfig{
+			Format:       accessFormatJSON,
+			File:         file,
+			MaxSize:      1,
+			ExcludePaths: []string{"/health"},
+		},
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = server.settings.Load().access.Close() })
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	serve := func(target string) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = "192.168.1.10:51234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	readLines := func() []string {
+		content, err := os.ReadFile(file) //nolint:gosec // test file with controlled path
+		require.NoError(t, err)
+
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	t.Run("json 格式记录目标和字节数", func(t *testing.T) {
+		serve("/u/app/containers/json?all=1")
+		serve("/u/refused/info")
+		serve("/health")
+
+		lines := readLines()
+		require.Len(t, lines, 2)
+
+		var ok, failed map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &ok))
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &failed))
+
+		assert.Equal(t, "access", ok["msg"])
+		assert.Equal(t, "/u/app/containers/json", ok["path"])
+		assert.Equal(t, "all=1", ok["query"])
+		assert.Equal(t, "app", ok["socket"])
+		assert.Equal(t, socketPath, ok["socket_path"])
+		assert.Equal(t, "/containers/json?all=1", ok["url"])
+		assert.Equal(t, "192.168.1.10:51234", ok["remote"])
+		assert.Greater(t, ok["bytes_out"], float64(0))
+		assert.NotContains(t, ok, "upstream_error")
+
+		assert.InDelta(t, http.StatusBadGateway, failed["status"], 0)
+		assert.Equal(t, refused, failed["socket_path"])
+		assert.Equal(t, codeConnectionRefused, failed["error"])
+		assert.Contains(t, failed["upstream_error"], "connection refused")
+	})
+
+	t.Run("重新加载后切换为模板格式并复用文件", func(t *testing.T) {
+		next := *cfg
+		next.AccessLog.Format = accessFormatTemplate
+		next.AccessLog.Template = "{{.Method}} {{.SocketPath}} {{.URL}} {{.Status}}"
+		next.AccessLog.ExcludeStatus = []string{"5xx"}
+
+		prev := server.settings.Load().access
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+		assert.Same(t, prev.file, server.settings.Load().access.file)
+
+		serve("/u/app/info")
+		serve("/u/refused/info")
+
+		lines := readLines()
+		require.Len(t, lines, 3)
+		assert.Equal(t, "GET "+socketPath+" /info 200", lines[2])
+	})
+
+	t.Run("combined 格式", func(t *testing.T) {
+		next := *cfg
+		next.AccessLog.Format = accessFormatCombined
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+
+		serve("/u/app/info?x=1")
+
+		lines := readLines()
+		require.Len(t, lines, 4)
+		assert.Regexp(t, `^192\.168\.1\.10 - - \[.+\] "GET /u/app/info\?x=1 HTTP/1\.1" 200 \d+ "-" "-"$`, lines[3])
+	})
+
+	t.Run("无效配置不生效", func(t *testing.T) {
+		next := *cfg
+		next.AccessLog.Format = "xml"
+
+		_, err := server.Reload(&next)
+		require.Error(t, err)
+		assert.Equal(t, accessFormatCombined, server.settings.Load().access.cfg.Format)
+	})
+
+	t.Run("切换文件后正在处理的请求写入旧文件", func(t *testing.T) {
+		st := server.pinSettings()
+
+		next := *cfg
+		next.AccessLog.File = filepath.Join(t.TempDir(), "next.log")
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+		assert.False(t, st.access.acquire())
+
+		st.access.log(&accessEntry{Method: http.MethodGet, Path: "/late", Status: http.StatusOK})
+		st.access.release()
+
+		lines := readLines()
+		require.Len(t, lines, 5)
+		assert.Contains(t, lines[4], `"GET /late `)
+		assert.Zero(t, st.access.file.refs)
+	})
+}
@@ -0,0 +1,532 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// 审计日志的同步策略。
+const (
+	auditSyncNone     = "none"
+	auditSyncAlways   = "always"
+	auditSyncInterval = "interval"
+)
+
+// redactedValue 替换被脱敏字段的值。
+const redactedValue = "[REDACTED]"
+
+// auditEntry 是审计日志的一条记录。字段顺序固定，哈希按序列化结果计算。
+type auditEntry struct {
+	Seq           uint64          `json:"seq"`
+	Time          time.Time       `json:"time"`
+	RequestID     string          `json:"request_id"`
+	Identity      string          `json:"identity,omitempty"`
+	Remote        string          `json:"remote"`
+	Method        string          `json:"method"`
+	RequestURI    string          `json:"request_uri"`
+	Socket        string          `json:"socket,omitempty"`
+	SocketPath    string          `json:"socket_path,omitempty"`
+	URL           string          `json:"url,omitempty"`
+	Status        int             `json:"status"`
+	Error         string          `json:"error,omitempty"`
+	DurationMS    float64         `json:"duration_ms"`
+	BodySize      int64           `json:"body_size"`
+	Body          json.RawMessage `json:"body,omitempty"`
+	BodyTruncated bool            `json:"body_truncated,omitempty"`
+	PrevHash      string          `json:"prev_hash"`
+}
+
+// auditLog 将代理的非安全方法请求追加到 JSON Lines 格式的审计日志。
+//
+// 每条记录包含前一条记录的哈希（prev_hash），自身的哈希（hash）是
+// SHA-256(prev_hash + 不含 hash 字段的记录)，形成哈希链：第一条记录的序号为 1、prev_hash 为空，
+// 修改、删除开头或中间的任意记录都会被 [VerifyAuditLog] 发现。重启后从文件最后一条记录继续哈希链和序号。
+//
+// 请求体最多记录 maxBody 字节，JSON 请求体中名称匹配 redact 的字段值在任意层级被替换为 [REDACTED]。
+type auditLog struct {
+	maxBody  int
+	redact   []string
+	redactRe *regexp.Regexp // 截断或无法解析的请求体按文本脱敏
+	syncMode string
+
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	prevHash string
+	dirty    bool // 有尚未同步到磁盘的写入
+	stop     chan struct{}
+}
+
+// newAuditLog 打开 cfg 指定的审计日志文件并恢复哈希链状态。
+// 同步策略未知、按间隔同步但间隔不是正数或文件末尾的记录无法恢复（见 [auditLog.restore]）时返回错误。
+func newAuditLog(cfg config.AuditConfig) (*auditLog, error) {
+	switch cfg.Sync {
+	case auditSyncNone, auditSyncAlways, auditSyncInterval:
+	default:
+		return nil, fmt.Errorf("unknown audit sync mode %q", cfg.Sync)
+	}
+
+	if cfg.Sync == auditSyncInterval && cfg.SyncInterval <= 0 {
+		return nil, fmt.Errorf("audit sync interval must be positive, got %d", cfg.SyncInterval)
+	}
+
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	a := &auditLog{
+		maxBody:  cfg.MaxBodySize,
+		redact:   cfg.RedactFields,
+		redactRe: redactPattern(cfg.RedactFields),
+		syncMode: cfg.Sync,
+		file:     f,
+	}
+
+	if err := a.restore(); err != nil {
+		_ = f.Close()
+
+		return nil, err
+	}
+
+	if cfg.Sync == auditSyncInterval {
+		a.stop = make(chan struct{})
+		go a.syncLoop(time.Duration(cfg.SyncInterval)*time.Millisecond, a.stop)
+	}
+
+	return a, nil
+}
+
+// restore 从文件最后一条记录读取序号和哈希。
+//
+// 写入过程中崩溃会留下不完整的最后一行：它无法解析时被截断并记录警告，哈希链从前一条记录继续。
+// 前一条记录同样无法解析说明文件已损坏，返回错误，需要人工检查。
+func (a *auditLog) restore() error {
+	line, offset, err := lastLine(a.file)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if line == nil {
+		return nil
+	}
+
+	seq, hash, ok := parseChainTail(line)
+	if !ok {
+		if err := a.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate audit log: %w", err)
+		}
+
+		slog.Warn("审计日志最后一条记录不完整，已截断", "file", a.file.Name(), "offset", offset, "bytes", len(line))
+
+		if line, _, err = lastLine(a.file); err != nil {
+			return fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		if line == nil {
+			return nil
+		}
+
+		if seq, hash, ok = parseChainTail(line); !ok {
+			return fmt.Errorf("audit log %s ends with malformed entries, inspect it before restarting", a.file.Name())
+		}
+	}
+
+	a.seq, a.prevHash = seq, hash
+
+	return nil
+}
+
+// parseChainTail 解析日志行中的序号和哈希，日志行不是完整的记录时返回 false。
+func parseChainTail(line []byte) (uint64, string, bool) {
+	var e struct {
+		Seq  uint64 `json:"seq"`
+		Hash string `json:"hash"`
+	}
+
+	if err := json.Unmarshal(line, &e); err != nil || e.Hash == "" {
+		return 0, "", false
+	}
+
+	return e.Seq, e.Hash, true
+}
+
+// lastLine 返回文件最后一个非空行及其在文件中的起始位置，文件为空时返回 nil。
+func lastLine(f *os.File) ([]byte, int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	const chunk = 4096
+
+	var tail []byte
+
+	for end := fi.Size(); end > 0; {
+		start := max(end-chunk, 0)
+		buf := make([]byte, end-start)
+
+		if _, err := f.ReadAt(buf, start); err != nil {
+			return nil, 0, err
+		}
+
+		tail = append(buf, tail...)
+		end = start
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], end + int64(i) + 1, nil
+		}
+
+		if end == 0 && len(trimmed) > 0 {
+			return trimmed, 0, nil
+		}
+	}
+
+	return nil, 0, nil
+}
+
+// syncLoop 每隔 interval 将写入同步到磁盘，直到 stop 关闭。
+func (a *auditLog) syncLoop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			a.syncLocked()
+			a.mu.Unlock()
+		}
+	}
+}
+
+// syncLocked 同步尚未落盘的写入。调用者必须持有 a.mu。
+func (a *auditLog) syncLocked() {
+	if !a.dirty {
+		return
+	}
+
+	if err := a.file.Sync(); err != nil {
+		slog.Error("审计日志同步失败", "error", err)
+
+		return
+	}
+
+	a.dirty = false
+}
+
+// record 为 e 分配序号、链接哈希并追加到文件。
+func (a *auditLog) record(e *auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e.Seq, e.PrevHash = a.seq+1, a.prevHash
+
+	line, hash, err := chainEntry(e)
+	if err != nil {
+		slog.Error("审计日志编码失败", "request_id", e.RequestID, "error", err)
+
+		return
+	}
+
+	if _, err := a.file.Write(line); err != nil {
+		slog.Error("审计日志写入失败", "request_id", e.RequestID, "error", err)
+
+		return
+	}
+
+	a.seq, a.prevHash, a.dirty = e.Seq, hash, true
+
+	if a.syncMode == auditSyncAlways {
+		a.syncLocked()
+	}
+}
+
+// chainEntry 返回 e 的日志行（含 hash 字段和换行符）和哈希。
+// 日志行是 e 的 JSON 序列化结果在末尾追加 hash 字段，校验时去掉该字段即可还原被哈希的内容。
+func chainEntry(e *auditEntry) ([]byte, string, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(e.PrevHash), data...))
+	hash := hex.EncodeToString(sum[:])
+
+	line := append(data[:len(data)-1:len(data)-1], `,"hash":"`+hash+`"}`+"\n"...)
+
+	return line, hash, nil
+}
+
+// Close 停止定期同步，同步并关闭文件。
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+
+	a.syncLocked()
+
+	return a.file.Close()
+}
+
+// hashSuffix 匹配日志行末尾的 hash 字段。
+var hashSuffix = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// VerifyAuditLog 校验审计日志的哈希链，返回校验通过的记录数。
+// 任意记录被修改、删除或插入时返回错误，指出第一条不一致的行；
+// 第一行必须是序号为 1、prev_hash 为空的记录，因此删除开头的记录同样会被发现。
+// 哈希链无法发现从文件末尾截断的记录，需要结合外部保存的最新哈希或序号判断。
+func VerifyAuditLog(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+
+	var (
+		prevHash string
+		prevSeq  uint64
+		n        int
+	)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		n++
+
+		m := hashSuffix.FindSubmatchIndex(line)
+		if m == nil {
+			return n - 1, fmt.Errorf("line %d: missing hash", n)
+		}
+
+		data := append(line[:m[0]:m[0]], '}')
+
+		var e auditEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return n - 1, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		// The first entry starts the chain: seq 1 with an empty prev_hash
+		if e.PrevHash != prevHash || e.Seq != prevSeq+1 {
+			return n - 1, fmt.Errorf("line %d: chain broken, expected seq %d after hash %s", n, prevSeq+1, prevHash)
+		}
+
+		sum := sha256.Sum256(append([]byte(e.PrevHash), data...))
+		hash := hex.EncodeToString(sum[:])
+
+		if hash != string(line[m[2]:m[3]]) {
+			return n - 1, fmt.Errorf("line %d: hash mismatch, entry was modified", n)
+		}
+
+		prevHash, prevSeq = hash, e.Seq
+	}
+
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// auditMiddleware 为代理请求（/proxy 和 /u/ 下的路径）记录审计日志，未配置审计日志时直接返回 next。
+//
+// 它位于认证中间件之外，只记录实际发往或将要发往后端的方法不是 GET、HEAD 或 OPTIONS 的请求，
+// 包括认证失败和其他被拒绝的请求；/proxy 的 method 参数覆盖请求方法时按覆盖后的方法判断。
+// 请求体在转发过程中被读取时记录前 max_body_size 字节，未被读取的请求体（如请求被拒绝）不记录。
+func (s *Server) auditMiddleware(next http.Handler) http.Handler {
+	if s.audit == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy" && !strings.HasPrefix(r.URL.Path, "/u/") {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		start := time.Now()
+
+		body := &bodyCapture{ReadCloser: r.Body, limit: s.audit.maxBody}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		info := requestInfoFrom(r.Context())
+
+		method := auditMethod(r, info)
+		if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+			return
+		}
+
+		e := &auditEntry{
+			Time:       start,
+			RequestID:  info.id,
+			Identity:   info.identity,
+			Remote:     r.RemoteAddr,
+			Method:     method,
+			RequestURI: r.URL.RequestURI(),
+			Socket:     info.socket,
+			SocketPath: info.socketPath,
+			URL:        info.url,
+			Status:     wrapped.statusCode,
+			Error:      info.errorCode,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			BodySize:   body.n,
+		}
+
+		e.Body, e.BodyTruncated = s.audit.redactBody(body.buf.Bytes(), body.n)
+
+		s.audit.record(e)
+	})
+}
+
+// auditMethod 返回请求实际发往或将要发往后端的方法。
+func auditMethod(r *http.Request, info *requestInfo) string {
+	if info.method != "" {
+		return info.method
+	}
+
+	if r.URL.Path == "/proxy" {
+		if method := r.URL.Query().Get("method"); method != "" {
+			return strings.ToUpper(method)
+		}
+	}
+
+	return r.Method
+}
+
+// bodyCapture 在请求体被读取时保留前 limit 字节，并统计读取的总字节数。
+type bodyCapture struct {
+	io.ReadCloser
+
+	limit int
+	buf   bytes.Buffer
+	n     int64
+}
+
+func (c *bodyCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+
+	if room := c.limit - c.buf.Len(); room > 0 && n > 0 {
+		c.buf.Write(p[:min(n, room)])
+	}
+
+	return n, err
+}
+
+// redactBody 返回可写入审计日志的请求体摘录和是否被截断。
+//
+// 完整的 JSON 请求体解析后按字段名脱敏，以 JSON 值记录；截断或不是 JSON 的文本按正则脱敏，
+// 以字符串记录；二进制内容不记录。
+func (a *auditLog) redactBody(excerpt []byte, size int64) (json.RawMessage, bool) {
+	if len(excerpt) == 0 {
+		return nil, false
+	}
+
+	truncated := size > int64(len(excerpt))
+
+	if !truncated {
+		dec := json.NewDecoder(bytes.NewReader(excerpt))
+		dec.UseNumber()
+
+		var v any
+		if dec.Decode(&v) == nil && !dec.More() {
+			if data, err := json.Marshal(redactValue(v, a.redact)); err == nil {
+				return data, false
+			}
+		}
+	}
+
+	if !utf8.Valid(excerpt) {
+		// Cutting a multi-byte character is not binary content
+		if !truncated || !utf8.Valid(excerpt[:max(len(excerpt)-utf8.UTFMax, 0)]) {
+			return nil, truncated
+		}
+	}
+
+	text := string(excerpt)
+	if a.redactRe != nil {
+		text = a.redactRe.ReplaceAllString(text, `${1}"`+redactedValue+`"`)
+	}
+
+	data, _ := json.Marshal(strings.ToValidUTF8(text, ""))
+
+	return data, truncated
+}
+
+// redactValue 将 v 中名称与 fields 匹配（不区分大小写）的字段值替换为 [redactedValue]。
+func redactValue(v any, fields []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if containsFold(fields, key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactValue(value, fields)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value, fields)
+		}
+	}
+
+	return v
+}
+
+// containsFold 报告 list 中是否有与 s 不区分大小写相等的项。
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactPattern 返回按文本脱敏 fields 的正则表达式，fields 为空时返回 nil。
+// 它匹配 "字段名": 之后的字符串、数组、单层对象或标量值，截断在值中间时匹配到文本末尾。
+func redactPattern(fields []string) *regexp.Regexp {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+
+	const (
+		str    = `"(?:[^"\\]|\\.)*"?`
+		array  = `\[(?:[^\]"]|` + str + `)*\]?`
+		object = `\{(?:[^}"]|` + str + `)*\}?`
+		scalar = `[^,}\]\s]+`
+	)
+
+	return regexp.MustCompile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)(?:` +
+		str + `|` + array + `|` + object + `|` + scalar + `)`)
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAuditLog 在临时目录创建审计日志，测试结束时关闭。
+func newTestAuditLog(t *testing.T, file string, maxBody int) *auditLog {
+	t.Helper()
+
+	a, err := newAuditLog(config.AuditConfig{
+		File:         file,
+		MaxBodySize:  maxBody,
+		RedactFields: []string{"password", "Env"},
+		Sync:         auditSyncAlways,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = a.Close() })
+
+	return a
+}
+
+// readAuditEntries 读取审计日志的全部记录。
+func readAuditEntries(t *testing.T, file string) []map[string]any {
+	t.Helper()
+
+	content, err := os.ReadFile(file) //nolint:gosec // test file with controlled path
+	require.NoError(t, err)
+
+	var entries []map[string]any
+
+	for line := range strings.SplitSeq(strings.TrimSpace(string(content)), "\n") {
+		var e map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// TestNewAuditLog 测试审计日志配置的校验和哈希链的恢复
+func TestNewAuditLog(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("未知同步策略", func(t *testing.T) {
+		_, err := newAuditLog(config.AuditConfig{File: filepath.Join(dir, "a.log"), Sync: "weekly"})
+		assert.Error(t, err)
+	})
+
+	t.Run("同步间隔不是正数", func(t *testing.T) {
+		for _, interval := range []int{0, -1} {
+			_, err := newAuditLog(config.AuditConfig{File: filepath.Join(dir, "a.log"), Sync: auditSyncInterval, SyncInterval: interval})
+			assert.ErrorContains(t, err, "sync interval must be positive")
+		}
+	})
+
+	t.Run("截断不完整的最后一条记录", func(t *testing.T) {
+		file := filepath.Join(dir, "torn.log")
+
+		first, _, err := chainEntry(&auditEntry{Seq: 1, Method: http.MethodPost})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(file, append(slices.Clone(first), `{"seq":2,"method":"DEL`...), 0600))
+
+		a := newTestAuditLog(t, file, 0)
+		assert.Equal(t, uint64(1), a.seq)
+
+		content, err := os.ReadFile(file) //nolint:gosec // test file with controlled path
+		require.NoError(t, err)
+		assert.Equal(t, first, content)
+
+		a.record(&auditEntry{Method: http.MethodDelete})
+
+		f, err := os.Open(file) //nolint:gosec // test file with controlled path
+		require.NoError(t, err)
+
+		defer func() { _ = f.Close() }()
+
+		n, err := VerifyAuditLog(f)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("多条记录损坏", func(t *testing.T) {
+		file := filepath.Join(dir, "broken.log")
+		require.NoError(t, os.WriteFile(file, []byte("garbage\n"+`{"seq":2,`), 0600))
+
+		_, err := newAuditLog(config.AuditConfig{File: file, Sync: auditSyncNone})
+		assert.ErrorContains(t, err, "malformed entries")
+	})
+
+	t.Run("重新打开后继续哈希链", func(t *testing.T) {
+		file := filepath.Join(dir, "chain.log")
+
+		a := newTestAuditLog(t, file, 0)
+		a.record(&auditEntry{Method: http.MethodPost})
+		a.record(&auditEntry{Method: http.MethodDelete})
+		require.NoError(t, a.Close())
+
+		b := newTestAuditLog(t, file, 0)
+		assert.Equal(t, uint64(2), b.seq)
+		b.record(&auditEntry{Method: http.MethodPut})
+
+		f, err := os.Open(file) //nolint:gosec // test file with controlled path
+		require.NoError(t, err)
+
+		defer func() { _ = f.Close() }()
+
+		n, err := VerifyAuditLog(f)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+}
+
+// TestVerifyAuditLog 测试哈希链能发现被修改、删除和插入的记录
+func TestVerifyAuditLog(t *testing.T) {
+	var lines [][]byte
+
+	prev := ""
+
+	for i, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		line, hash, err := chainEntry(&auditEntry{Seq: uint64(i + 1), Method: method, Status: 200, PrevHash: prev})
+		require.NoError(t, err)
+
+		lines = append(lines, line)
+		prev = hash
+	}
+
+	tests := []struct {
+		name    string
+		lines   [][]byte
+		want    int
+		wantErr string
+	}{
+		{"完整", lines, 3, ""},
+		{"修改记录", [][]byte{lines[0], bytes.Replace(lines[1], []byte(`"status":200`), []byte(`"status":201`), 1), lines[2]}, 1, "hash mismatch"},
+		{"删除记录", [][]byte{lines[0], lines[2]}, 1, "chain broken"},
+		{"删除开头的记录", [][]byte{lines[2]}, 0, "chain broken"},
+		{"删除开头的多条记录", [][]byte{lines[1], lines[2]}, 0, "chain broken"},
+		{"插入记录", [][]byte{lines[0], lines[0], lines[1]}, 1, "chain broken"},
+		{"缺少哈希", [][]byte{[]byte(`{"seq":1}` + "\n")}, 0, "missing hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := VerifyAuditLog(bytes.NewReader(bytes.Join(tt.lines, nil)))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.want, n)
+		})
+	}
+}
+
+// TestAuditLog_redactBody 测试请求体摘录的脱敏和截断
+func TestAuditLog_redactBody(t *testing.T) {
+	a := &auditLog{
+		redact:   []string{"password", "Env"},
+		redactRe: redactPattern([]string{"password", "Env"}),
+	}
+
+	tests := []struct {
+		name          string
+		body          string
+		size          int64 // 为 0 时与 body 长度相同
+		want          string
+		wantTruncated bool
+	}{
+		{"空请求体", "", 0, "", false},
+		{
+			"嵌套字段",
+			`{"Image":"nginx","Env":["A=1"],"Auth":{"Password":"p","User":"u"},"List":[{"password":1}]}`, 0,
+			`{"Auth":{"Password":"[REDACTED]","User":"u"},"Env":"[REDACTED]","Image":"nginx","List":[{"password":"[REDACTED]"}]}`,
+			false,
+		},
+		{"保留数字精度", `{"n":12345678901234567890}`, 0, `{"n":12345678901234567890}`, false},
+		{"截断的 JSON", `{"Image":"nginx","Env":["A=1","B`, 100, `"{\"Image\":\"nginx\",\"Env\":\"[REDACTED]\""`, true},
+		{"非 JSON 文本", `password=hunter2`, 0, `"password=hunter2"`, false},
+		{"文本中的 JSON 字段", `x {"password": "p", "ok": 1}`, 0, `"x {\"password\": \"[REDACTED]\", \"ok\": 1}"`, false},
+		{"二进制", "\x00\xff\xfe", 0, "", false},
+		{"截断在多字节字符中间", "{\"msg\":\"\xe4\xbd", 100, `"{\"msg\":\""`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := tt.size
+			if size == 0 {
+				size = int64(len(tt.body))
+			}
+
+			got, truncated := a.redactBody([]byte(tt.body), size)
+			assert.Equal(t, tt.want, string(got))
+			assert.Equal(t, tt.wantTruncated, truncated)
+		})
+	}
+}
+
+// TestServer_Audit 测试代理请求写入审计日志
+func TestServer_Audit(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+	file := filepath.Join(t.TempDir(), "audit.log")
+
+	server, err := NewServer(&config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+		Auth:        config.AuthConfig{Tokens: map[string]string{"ci": "secret"}},
+		Audit: config.AuditConfig{
+			File:         file,
+			MaxBodySize:  64,
+			RedactFields: []string{"Env"},
+			Sync:         auditSyncInterval,
+			SyncInterval: 10,
+		},
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = server.audit.Close() })
+
+	handler := server.accessLogMiddleware(server.auditMiddleware(server.authMiddleware(server.routes())))
+
+	serve := func(method, target, body, token string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(requestIDHeader, "req-"+method)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/u/app/containers/json", "", "secret"))
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/u/app/containers/create?name=web", `{"Image":"nginx","Env":["TOKEN=abc"]}`, "secret"))
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/proxy?path="+socketPath+"&url=/containers/web&method=delete", "", "secret"))
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/u/app/archive", strings.Repeat("x", 100), "secret"))
+	require.Equal(t, http.StatusUnauthorized, serve(http.MethodDelete, "/u/app/containers/web", "", "wrong"))
+	require.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/admin/breakers", "", "wrong"))
+
+	entries := readAuditEntries(t, file)
+	require.Len(t, entries, 4)
+
+	create := entries[0]
+	assert.InDelta(t, 1, create["seq"], 0)
+	assert.Equal(t, "req-POST", create["request_id"])
+	assert.Equal(t, "ci", create["identity"])
+	assert.Equal(t, http.MethodPost, create["method"])
+	assert.Equal(t, "app", create["socket"])
+	assert.Equal(t, socketPath, create["socket_path"])
+	assert.Equal(t, "/containers/create?name=web", create["url"])
+	assert.InDelta(t, http.StatusOK, create["status"], 0)
+	assert.Equal(t, map[string]any{"Image": "nginx", "Env": redactedValue}, create["body"])
+	assert.Empty(t, create["prev_hash"])
+
+	remove := entries[1]
+	assert.Equal(t, http.MethodDelete, remove["method"])
+	assert.Equal(t, "/containers/web", remove["url"])
+	assert.NotContains(t, remove, "body")
+	assert.Equal(t, create["hash"], remove["prev_hash"])
+
+	upload := entries[2]
+	assert.Equal(t, strings.Repeat("x", 64), upload["body"])
+	assert.InDelta(t, 100, upload["body_size"], 0)
+	assert.Equal(t, true, upload["body_truncated"])
+
+	// Rejected by authentication before reaching the proxy handler
+	denied := entries[3]
+	assert.Equal(t, http.MethodDelete, denied["method"])
+	assert.Equal(t, "/u/app/containers/web", denied["request_uri"])
+	assert.InDelta(t, http.StatusUnauthorized, denied["status"], 0)
+	assert.Equal(t, codeUnauthorized, denied["error"])
+	assert.NotContains(t, denied, "identity")
+
+	f, err := os.Open(file) //nolint:gosec // test file with controlled path
+	require.NoError(t, err)
+
+	defer func() { _ = f.Close() }()
+
+	n, err := VerifyAuditLog(f)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/filewatch"
+)
+
+// tokenReloadDelay 是令牌文件最后一次变更到重新加载之间的等待时间。
+// 编辑器和 os.WriteFile 会先截断文件再写入，立即加载可能读到空文件。
+const tokenReloadDelay = 100 * time.Millisecond
+
+// tokenDigest 是令牌的 SHA-256 摘要。
+// 令牌以摘要作为键保存和比较，查找耗时与令牌内容无关。
+type tokenDigest [sha256.Size]byte
+
+// TokenStore 保存认证令牌到身份标识的映射。
+//
+// 令牌来源包括配置中的静态令牌和可选的令牌文件。令牌文件每行一条
+// "身份标识:令牌"，空行和以 # 开头的行会被忽略。调用 [TokenStore.Watch] 后，
+// 令牌文件的变更会被自动加载；加载失败时保留之前的令牌。
+//
+// 此类型支持多个 goroutine 并发安全使用。
+type TokenStore struct {
+	static  map[tokenDigest]string
+	file    string
+	tokens  atomic.Pointer[map[tokenDigest]string]
+	mu      sync.Mutex // 保护 watcher 和 closed
+	watcher *filewatch.Watcher
+	closed  bool // Close 之后不再开始监听
+}
+
+// NewTokenStore 使用静态令牌（身份标识到令牌的映射）和令牌文件创建令牌存储。
+// file 为空时只使用静态令牌。令牌文件无法读取或格式错误时返回错误。
+func NewTokenStore(static map[string]string, file string) (*TokenStore, error) {
+	ts := &TokenStore{
+		static: make(map[tokenDigest]string, len(static)),
+		file:   file,
+	}
+
+	for identity, token := range static {
+		if identity == "" || token == "" {
+			return nil, fmt.Errorf("invalid static token for identity %q", identity)
+		}
+
+		ts.static[sha256.Sum256([]byte(token))] = identity
+	}
+
+	if err := ts.Reload(); err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// Lookup 返回令牌对应的身份标识。令牌为空或未知时返回 false。
+func (ts *TokenStore) Lookup(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+
+	identity, ok := (*ts.tokens.Load())[sha256.Sum256([]byte(token))]
+
+	return identity, ok
+}
+
+// Reload 重新读取令牌文件，并与静态令牌合并后原子替换当前令牌。
+// 失败时当前令牌保持不变。
+func (ts *TokenStore) Reload() error {
+	tokens := make(map[tokenDigest]string, len(ts.static))
+	for digest, identity := range ts.static {
+		tokens[digest] = identity
+	}
+
+	if ts.file != "" {
+		content, err := os.ReadFile(ts.file)
+		if err != nil {
+			return fmt.Errorf("failed to read token file: %w", err)
+		}
+
+		if err := parseTokenFile(content, tokens); err != nil {
+			return fmt.Errorf("invalid token file %s: %w", ts.file, err)
+		}
+	}
+
+	ts.tokens.Store(&tokens)
+
+	return nil
+}
+
+// Watch 开始监听令牌文件（见 [filewatch.Watch]），文件变更稍作等待后自动重新加载。
+// 未配置令牌文件或令牌存储已关闭时什么也不做。
+func (ts *TokenStore) Watch() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.file == "" || ts.watcher != nil || ts.closed {
+		return nil
+	}
+
+	watcher, err := filewatch.Watch(ts.file, tokenReloadDelay, ts.reloadFile)
+	if err != nil {
+		return err
+	}
+
+	ts.watcher = watcher
+
+	return nil
+}
+
+// reloadFile 重新加载令牌文件并记录结果。
+func (ts *TokenStore) reloadFile() {
+	if err := ts.Reload(); err != nil {
+		slog.Warn("令牌文件重新加载失败，继续使用原有令牌", "file", ts.file, "error", err)
+
+		return
+	}
+
+	slog.Info("令牌文件已重新加载", "file", ts.file)
+}
+
+// Close 停止监听令牌文件，之后调用 [TokenStore.Watch] 不再生效。
+func (ts *TokenStore) Close() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.closed = true
+
+	if ts.watcher == nil {
+		return nil
+	}
+
+	err := ts.watcher.Close()
+	ts.watcher = nil
+
+	return err
+}
+
+// parseTokenFile 解析令牌文件内容并写入 tokens。
+func parseTokenFile(content []byte, tokens map[tokenDigest]string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		identity, token, ok := strings.Cut(line, ":")
+		identity, token = strings.TrimSpace(identity), strings.TrimSpace(token)
+
+		if !ok || identity == "" || token == "" {
+			return fmt.Errorf("line %d: expected 'identity:token'", lineNo)
+		}
+
+		tokens[sha256.Sum256([]byte(token))] = identity
+	}
+
+	return scanner.Err()
+}
+
+// errNoCredentials 表示请求未携带任何认证凭据。
+var errNoCredentials = errors.New("no credentials")
+
+// requestToken 从请求中提取认证令牌，并从请求头中移除，避免令牌被转发到后端。
+// 支持 "Authorization: Bearer <token>" 和 "X-API-Key: <token>" 两种形式。
+func requestToken(r *http.Request) (string, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			r.Header.Del("Authorization")
+
+			return strings.TrimSpace(token), nil
+		}
+	}
+
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		r.Header.Del("X-API-Key")
+
+		return token, nil
+	}
+
+	return "", errNoCredentials
+}
+
+// matchPath 报告路径是否匹配 patterns 中的任一项，用于认证公开路径和访问日志过滤。
+// 以 "/" 结尾的配置项按前缀匹配，其余按精确匹配。
+func matchPath(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if p == path || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authMiddleware 返回认证中间件。
+//
+// 未配置令牌和客户端 CA 时直接放行。否则，除公开路径外的请求都必须提供
+// 已验证的客户端证书或有效令牌，两者都没有时返回 401。认证通过后，证书主题
+// 或令牌对应的身份标识写入请求信息，供访问日志和后续策略使用。
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := s.settingsFor(r)
+
+		if !s.authEnabled(st) || matchPath(st.config.Auth.PublicPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if identity := clientCertIdentity(r); identity != "" {
+			requestInfoFrom(r.Context()).identity = identity
+
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if st.tokens == nil {
+			s.writeError(w, r, codeUnauthorized, proxyTarget{})
+
+			return
+		}
+
+		token, err := requestToken(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="uds-proxy"`)
+			s.writeError(w, r, codeUnauthorized, proxyTarget{})
+
+			return
+		}
+
+		identity, ok := st.tokens.Lookup(token)
+		if !ok {
+			loggerFrom(r.Context()).Warn("认证失败", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="uds-proxy", error="invalid_token"`)
+			s.writeError(w, r, codeUnauthorized, proxyTarget{})
+
+			return
+		}
+
+		requestInfoFrom(r.Context()).identity = identity
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireIdentity 只允许已认证的请求访问 next，请求没有身份标识时返回 401。
+// 未启用认证时所有请求都没有身份标识，next 只能通过指标服务的独立地址访问。
+func (s *Server) requireIdentity(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestInfoFrom(r.Context()).identity == "" {
+			s.writeError(w, r, codeUnauthorized, proxyTarget{})
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// authEnabled 报告是否启用了认证（令牌或 mTLS）。
+func (s *Server) authEnabled(st *settings) bool {
+	return st.tokens != nil || s.config.TLSClientCA != ""
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewTokenStore 测试静态令牌和令牌文件的加载
+func TestNewTokenStore(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("# CI 令牌\n\nci: ci-token\ndeploy:deploy-token\n"), 0600))
+
+	store, err := NewTokenStore(map[string]string{"admin": "admin-token"}, tokenFile)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		token    string
+		identity string
+		ok       bool
+	}{
+		{"静态令牌", "admin-token", "admin", true},
+		{"文件令牌", "ci-token", "ci", true},
+		{"文件令牌无空格", "deploy-token", "deploy", true},
+		{"未知令牌", "guess", "", false},
+		{"空令牌", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, ok := store.Lookup(tt.token)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.identity, identity)
+		})
+	}
+}
+
+// TestNewTokenStore_Invalid 测试无效的令牌配置
+func TestNewTokenStore_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	badFile := filepath.Join(dir, "bad")
+	require.NoError(t, os.WriteFile(badFile, []byte("ci:ok\nmissing-separator\n"), 0600))
+
+	_, err := NewTokenStore(nil, badFile)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	_, err = NewTokenStore(nil, filepath.Join(dir, "missing"))
+	require.Error(t, err)
+
+	_, err = NewTokenStore(map[string]string{"admin": ""}, "")
+	require.Error(t, err)
+}
+
+// TestTokenStore_Watch 测试令牌文件变更后自动重新加载
+func TestTokenStore_Watch(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("ci:old-token\n"), 0600))
+
+	store, err := NewTokenStore(nil, tokenFile)
+	require.NoError(t, err)
+	require.NoError(t, store.Watch())
+	t.Cleanup(func() { _ = store.Close() })
+
+	// 原子替换文件
+	tmp := tokenFile + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte("ci:new-token\n"), 0600))
+	require.NoError(t, os.Rename(tmp, tokenFile))
+
+	assert.Eventually(t, func() bool {
+		_, ok := store.Lookup("new-token")
+
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, ok := store.Lookup("old-token")
+	assert.False(t, ok)
+
+	// 写入无效内容时保留原有令牌
+	require.NoError(t, os.WriteFile(tokenFile, []byte("invalid\n"), 0600))
+	time.Sleep(3 * tokenReloadDelay)
+
+	identity, ok := store.Lookup("new-token")
+	assert.True(t, ok)
+	assert.Equal(t, "ci", identity)
+}
+
+// TestTokenStore_WatchAfterClose 测试关闭后的令牌存储不再开始监听
+func TestTokenStore_WatchAfterClose(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("ci:ci-token\n"), 0600))
+
+	store, err := NewTokenStore(nil, tokenFile)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Watch())
+	assert.Nil(t, store.watcher)
+}
+
+// TestServer_authMiddleware 测试认证中间件
+func TestServer_authMiddleware(t *testing.T) {
+	var backendHeaders http.Header
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	server, err := NewServer(&config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+		Auth: config.AuthConfig{
+			Tokens:      map[string]string{"admin": "secret"},
+			PublicPaths: []string{"/health"},
+		},
+	})
+	require.NoError(t, err)
+
+	var identity string
+
+	handler := server.accessLogMiddleware(server.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.routes().ServeHTTP(w, r)
+		identity = requestInfoFrom(r.Context()).identity
+	})))
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		value    string
+		status   int
+		identity string
+	}{
+		{"公开路径无需令牌", "/health", "", "", http.StatusOK, ""},
+		{"缺少令牌", "/u/app/info", "", "", http.StatusUnauthorized, ""},
+		{"无效令牌", "/u/app/info", "Authorization", "Bearer wrong", http.StatusUnauthorized, ""},
+		{"非 Bearer 方案", "/u/app/info", "Authorization", "Basic c2VjcmV0", http.StatusUnauthorized, ""},
+		{"Bearer 令牌", "/u/app/info", "Authorization", "Bearer secret", http.StatusOK, "admin"},
+		{"Bearer 方案不区分大小写", "/u/app/info", "Authorization", "bearer secret", http.StatusOK, "admin"},
+		{"API Key", "/u/app/info", "X-API-Key", "secret", http.StatusOK, "admin"},
+		{"根路径需要令牌", "/", "", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity = ""
+			backendHeaders = nil
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.identity, identity)
+
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+
+			if backendHeaders != nil {
+				// 令牌不会转发到后端
+				assert.Empty(t, backendHeaders.Get("Authorization"))
+				assert.Empty(t, backendHeaders.Get("X-API-Key"))
+			}
+		})
+	}
+}
+
+// TestServer_requireIdentity 测试代理端口上的指标和 /admin 端点只对已认证的客户端开放
+func TestServer_requireIdentity(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   config.AuthConfig
+		token  string
+		status int
+	}{
+		{"未启用认证", config.AuthConfig{}, "", http.StatusUnauthorized},
+		{"缺少令牌", config.AuthConfig{Tokens: map[string]string{"ops": "secret"}}, "", http.StatusUnauthorized},
+		{"列为公开路径", config.AuthConfig{
+			Tokens:      map[string]string{"ops": "secret"},
+			PublicPaths: []string{"/metrics", "/admin/"},
+		}, "", http.StatusUnauthorized},
+		{"有效令牌", config.AuthConfig{Tokens: map[string]string{"ops": "secret"}}, "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewServer(&config.Config{
+				NoAccessLog: true,
+				Metrics:     config.MetricsConfig{Enabled: true},
+				Auth:        tt.auth,
+			})
+			require.NoError(t, err)
+
+			handler := server.accessLogMiddleware(server.authMiddleware(server.routes()))
+
+			for _, path := range []string{"/metrics", "/admin/breakers", "/admin/queues"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if tt.token != "" {
+					req.Header.Set("X-API-Key", tt.token)
+				}
+
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				assert.Equal(t, tt.status, rec.Code, path)
+			}
+		})
+	}
+}
+
+// TestServer_authMiddleware_Disabled 测试未配置令牌时不启用认证
+func TestServer_authMiddleware_Disabled(t *testing.T) {
+	server := newTestServer()
+	handler := server.authMiddleware(server.routes())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestMatchPath 测试公开路径匹配
+func TestMatchPath(t *testing.T) {
+	publicPaths := []string{"/health", "/static/"}
+
+	assert.True(t, matchPath(publicPaths, "/health"))
+	assert.True(t, matchPath(publicPaths, "/static/app.js"))
+	assert.False(t, matchPath(publicPaths, "/healthz"))
+	assert.False(t, matchPath(publicPaths, "/proxy"))
+	assert.False(t, matchPath(nil, "/health"))
+}
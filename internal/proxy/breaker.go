@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// breakerState 是熔断器的状态。
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常转发，统计失败
+	breakerOpen                         // 直接拒绝请求
+	breakerHalfOpen                     // 放行少量探测请求，根据结果关闭或重新打开
+)
+
+func (st breakerState) String() string {
+	switch st {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker 是单个套接字的熔断器状态。
+type breaker struct {
+	state       breakerState
+	reason      string    // 最近一次打开的原因
+	windowStart time.Time // 当前统计窗口的开始时间
+	requests    int       // 统计窗口内完成的请求数
+	failures    int       // 统计窗口内失败的请求数
+	timeouts    int       // 连续超时次数，请求成功时清零
+	openedAt    time.Time // 最近一次打开的时间
+	probes      int       // 半开状态下正在进行的探测请求数
+}
+
+// breakerStatus 是 /admin/breakers 返回的单个熔断器状态。
+type breakerStatus struct {
+	Socket              string     `json:"socket"`
+	State               string     `json:"state"`
+	Reason              string     `json:"reason,omitempty"`
+	Requests            int        `json:"requests"`
+	Failures            int        `json:"failures"`
+	ConsecutiveTimeouts int        `json:"consecutive_timeouts"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAfter          float64    `json:"retry_after,omitempty"` // 秒
+}
+
+// breakerSet 按规范化的套接字路径管理熔断器，通过不同别名或 /proxy 访问同一套接字的请求共用一个熔断器。
+//
+// 请求只统计连接失败和超时等未收到后端响应的错误，后端返回的 5xx 响应视为成功。
+// 熔断器在连续超时次数或统计窗口内的错误率达到阈值时打开，打开期间请求直接返回 503，
+// 等待 OpenTimeout 后进入半开状态，放行少量探测请求：探测成功则关闭，失败则重新打开。
+//
+// 只为最近出现过失败的套接字保留熔断器，统计窗口过期且没有失败的熔断器会被移除，
+// 因此通过 /proxy 访问大量不同套接字时不会无限增长。
+type breakerSet struct {
+	mu       sync.Mutex
+	cfg      config.BreakerConfig
+	breakers map[string]*breaker
+}
+
+// newBreakerSet 创建熔断器集合。
+func newBreakerSet(cfg config.BreakerConfig) *breakerSet {
+	return &breakerSet{
+		cfg:      cfg,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Configure 更新熔断器配置。已打开的熔断器保持打开，按新的 OpenTimeout 进入半开状态；
+// 禁用时移除全部熔断器。
+func (bs *breakerSet) Configure(cfg config.BreakerConfig) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.cfg = cfg
+
+	if !cfg.Enabled {
+		clear(bs.breakers)
+	}
+}
+
+// acquire 报告是否可以向 socket 发送请求。
+//
+// 允许时返回的 done 必须以请求结果调用，重复调用无效；
+// 拒绝时返回建议客户端等待的时间。
+func (bs *breakerSet) acquire(socket string) (func(error), time.Duration, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.breakers[socket]
+	if !bs.cfg.Enabled || b == nil || b.state == breakerClosed {
+		return bs.recorder(socket, false), 0, true
+	}
+
+	if b.state == breakerOpen {
+		wait := time.Until(b.openedAt.Add(bs.openTimeout()))
+		if wait > 0 {
+			return nil, wait, false
+		}
+
+		b.state, b.probes = breakerHalfOpen, 0
+		slog.Info("熔断器进入半开状态", "socket", socket)
+	}
+
+	if b.probes >= max(bs.cfg.HalfOpenRequests, 1) {
+		return nil, time.Second, false
+	}
+
+	b.probes++
+
+	return bs.recorder(socket, true), 0, true
+}
+
+// recorder 返回记录请求结果的函数，只有第一次调用生效。
+func (bs *breakerSet) recorder(socket string, probe bool) func(error) {
+	var once sync.Once
+
+	return func(err error) {
+		once.Do(func() { bs.record(socket, probe, err) })
+	}
+}
+
+// record 记录一次请求的结果，必要时切换熔断器状态。
+// 客户端取消的请求结果未知，不计入统计。
+func (bs *breakerSet) record(socket string, probe bool, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	canceled := errors.Is(err, context.Canceled)
+	b := bs.breakers[socket]
+
+	if probe && b != nil && b.state == breakerHalfOpen {
+		b.probes--
+
+		switch {
+		case canceled:
+		case err != nil:
+			bs.tripLocked(socket, b, "探测请求失败")
+		default:
+			delete(bs.breakers, socket)
+			slog.Info("熔断器已关闭", "socket", socket)
+		}
+
+		return
+	}
+
+	if !bs.cfg.Enabled || canceled {
+		return
+	}
+
+	now := time.Now()
+
+	if b == nil {
+		if err == nil {
+			return
+		}
+
+		bs.pruneLocked(now)
+
+		b = &breaker{windowStart: now}
+		bs.breakers[socket] = b
+	}
+
+	// Requests sent before the breaker opened
+	if b.state != breakerClosed {
+		return
+	}
+
+	if now.Sub(b.windowStart) >= bs.window() {
+		if err == nil && b.failures == 0 {
+			delete(bs.breakers, socket)
+
+			return
+		}
+
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+
+	b.requests++
+
+	if err == nil {
+		b.timeouts = 0
+
+		return
+	}
+
+	b.failures++
+
+	if os.IsTimeout(err) {
+		b.timeouts++
+	}
+
+	switch {
+	case bs.cfg.ConsecutiveTimeouts > 0 && b.timeouts >= bs.cfg.ConsecutiveTimeouts:
+		bs.tripLocked(socket, b, "连续超时")
+	case bs.cfg.ErrorRate > 0 && b.requests >= bs.cfg.MinRequests &&
+		b.failures*100 >= bs.cfg.ErrorRate*b.requests:
+		bs.tripLocked(socket, b, "错误率过高")
+	}
+}
+
+// tripLocked 打开熔断器。调用者必须持有 bs.mu。
+func (bs *breakerSet) tripLocked(socket string, b *breaker, reason string) {
+	b.state, b.reason, b.openedAt = breakerOpen, reason, time.Now()
+
+	slog.Warn("熔断器已打开",
+		"socket", socket,
+		"reason", reason,
+		"requests", b.requests,
+		"failures", b.failures,
+		"open_timeout", bs.openTimeout(),
+	)
+
+	b.requests, b.failures, b.timeouts = 0, 0, 0
+}
+
+// pruneLocked 移除统计窗口已过期的关闭状态熔断器。调用者必须持有 bs.mu。
+func (bs *breakerSet) pruneLocked(now time.Time) {
+	for socket, b := range bs.breakers {
+		if b.state == breakerClosed && now.Sub(b.windowStart) >= bs.window() {
+			delete(bs.breakers, socket)
+		}
+	}
+}
+
+// Status 返回全部熔断器的状态，按套接字路径排序。
+func (bs *breakerSet) Status() []breakerStatus {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	statuses := make([]breakerStatus, 0, len(bs.breakers))
+
+	for socket, b := range bs.breakers {
+		status := breakerStatus{
+			Socket:              socket,
+			State:               b.state.String(),
+			Reason:              b.reason,
+			Requests:            b.requests,
+			Failures:            b.failures,
+			ConsecutiveTimeouts: b.timeouts,
+		}
+
+		if b.state != breakerClosed {
+			openedAt := b.openedAt
+			status.OpenedAt = &openedAt
+		}
+
+		if b.state == breakerOpen {
+			status.RetryAfter = max(time.Until(b.openedAt.Add(bs.openTimeout())), 0).Seconds()
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	slices.SortFunc(statuses, func(a, b breakerStatus) int { return strings.Compare(a.Socket, b.Socket) })
+
+	return statuses
+}
+
+func (bs *breakerSet) window() time.Duration {
+	return time.Duration(bs.cfg.Window) * time.Millisecond
+}
+
+func (bs *breakerSet) openTimeout() time.Duration {
+	return time.Duration(bs.cfg.OpenTimeout) * time.Millisecond
+}
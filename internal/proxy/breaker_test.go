@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errRefused = errors.New("connection refused")
+
+// recordResults 依次发送请求并记录结果，请求被拒绝时测试失败。
+func recordResults(t *testing.T, bs *breakerSet, socket string, results ...error) {
+	t.Helper()
+
+	for _, err := range results {
+		done, _, ok := bs.acquire(socket)
+		require.True(t, ok)
+		done(err)
+	}
+}
+
+// TestBreakerSet_ConsecutiveTimeouts 测试连续超时打开熔断器及半开探测
+func TestBreakerSet_ConsecutiveTimeouts(t *testing.T) {
+	bs := newBreakerSet(config.BreakerConfig{
+		Enabled:             true,
+		ConsecutiveTimeouts: 2,
+		Window:              10000,
+		OpenTimeout:         50,
+		HalfOpenRequests:    1,
+	})
+
+	// A success in between resets the streak
+	recordResults(t, bs, "a.sock", os.ErrDeadlineExceeded, nil, os.ErrDeadlineExceeded)
+
+	_, _, ok := bs.acquire("a.sock")
+	require.True(t, ok)
+
+	recordResults(t, bs, "a.sock", os.ErrDeadlineExceeded)
+
+	_, retryAfter, ok := bs.acquire("a.sock")
+	require.False(t, ok)
+	assert.Positive(t, retryAfter)
+
+	status := bs.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, "open", status[0].State)
+	assert.Equal(t, "连续超时", status[0].Reason)
+	assert.NotNil(t, status[0].OpenedAt)
+
+	// Other sockets are unaffected
+	_, _, ok = bs.acquire("b.sock")
+	assert.True(t, ok)
+
+	t.Run("探测失败重新打开", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+
+		done, _, ok := bs.acquire("a.sock")
+		require.True(t, ok)
+
+		_, _, ok = bs.acquire("a.sock")
+		assert.False(t, ok, "半开状态只放行一个探测请求")
+
+		done(errRefused)
+
+		_, _, ok = bs.acquire("a.sock")
+		assert.False(t, ok)
+	})
+
+	t.Run("探测成功关闭", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+
+		done, _, ok := bs.acquire("a.sock")
+		require.True(t, ok)
+		assert.Equal(t, "half-open", bs.Status()[0].State)
+
+		done(nil)
+		done(errRefused) // ignored
+
+		assert.Empty(t, bs.Status())
+	})
+}
+
+// TestBreakerSet_ErrorRate 测试按错误率打开熔断器
+func TestBreakerSet_ErrorRate(t *testing.T) {
+	bs := newBreakerSet(config.BreakerConfig{
+		Enabled:     true,
+		ErrorRate:   50,
+		MinRequests: 4,
+		Window:      10000,
+		OpenTimeout: 10000,
+	})
+
+	recordResults(t, bs, "a.sock", errRefused, nil, errRefused)
+
+	_, _, ok := bs.acquire("a.sock")
+	require.True(t, ok, "请求数未达到 min_requests")
+
+	recordResults(t, bs, "a.sock", errRefused)
+
+	_, _, ok = bs.acquire("a.sock")
+	assert.False(t, ok)
+	assert.Equal(t, "错误率过高", bs.Status()[0].Reason)
+}
+
+// TestBreakerSet_Ignored 测试不计入统计的情况
+func TestBreakerSet_Ignored(t *testing.T) {
+	cfg := config.BreakerConfig{
+		Enabled:             true,
+		ConsecutiveTimeouts: 1,
+		Window:              20,
+		OpenTimeout:         10000,
+	}
+
+	t.Run("客户端取消", func(t *testing.T) {
+		bs := newBreakerSet(cfg)
+		recordResults(t, bs, "a.sock", context.Canceled, context.Canceled)
+		assert.Empty(t, bs.Status())
+	})
+
+	t.Run("未启用", func(t *testing.T) {
+		disabled := cfg
+		disabled.Enabled = false
+
+		bs := newBreakerSet(disabled)
+		recordResults(t, bs, "a.sock", os.ErrDeadlineExceeded, os.ErrDeadlineExceeded)
+		assert.Empty(t, bs.Status())
+	})
+
+	t.Run("统计窗口过期后移除", func(t *testing.T) {
+		bs := newBreakerSet(config.BreakerConfig{Enabled: true, ErrorRate: 50, MinRequests: 10, Window: 20})
+		recordResults(t, bs, "a.sock", errRefused)
+		assert.Len(t, bs.Status(), 1)
+
+		time.Sleep(30 * time.Millisecond)
+
+		recordResults(t, bs, "b.sock", errRefused)
+		assert.Equal(t, "b.sock", bs.Status()[0].Socket)
+		assert.Len(t, bs.Status(), 1)
+	})
+}
+
+// TestServer_Breaker 测试熔断器打开后直接返回 503
+func TestServer_Breaker(t *testing.T) {
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // wedged backend
+	}))
+
+	// The alias reaches the socket through a symlink
+	link := filepath.Join(t.TempDir(), "wedged.sock")
+	require.NoError(t, os.Symlink(socketPath, link))
+
+	canonical, err := canonicalSocketPath(socketPath)
+	require.NoError(t, err)
+
+	server, err := NewServer(&config.Config{
+		Timeout:     50,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"wedged": link},
+		Breaker: config.BreakerConfig{
+			Enabled:             true,
+			ConsecutiveTimeouts: 2,
+			Window:              10000,
+			OpenTimeout:         30000,
+		},
+	})
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		return rec
+	}
+
+	// Failures through the alias and /proxy count toward the same breaker
+	assert.Equal(t, http.StatusGatewayTimeout, get("/u/wedged/info").Code)
+	assert.Equal(t, http.StatusGatewayTimeout, get("/proxy?path="+socketPath+"&url=/info").Code)
+
+	rec := get("/u/wedged/info")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	server.handleBreakers(rec, httptest.NewRequest(http.MethodGet, "/admin/breakers", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Breakers []breakerStatus `json:"breakers"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	require.Len(t, body.Breakers, 1)
+	assert.Equal(t, canonical, body.Breakers[0].Socket)
+	assert.Equal(t, "open", body.Breakers[0].State)
+	assert.InDelta(t, 30, body.Breakers[0].RetryAfter, 1)
+
+	t.Run("重新加载后禁用", func(t *testing.T) {
+		cfg := *server.config
+		cfg.Breaker.Enabled = false
+
+		_, err := server.Reload(&cfg)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusGatewayTimeout, get("/u/wedged/info").Code)
+	})
+}
@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// 排队失败的原因，用作 uds_proxy_queue_rejections_total 的 reason 标签。
+const (
+	queueRejectFull     = "full"
+	queueRejectTimeout  = "timeout"
+	queueRejectCanceled = "canceled"
+)
+
+// queueWaiter 是等待队列中的一个请求。
+type queueWaiter struct {
+	ready   chan struct{} // 获得名额时关闭
+	name    string        // 上游别名或请求的套接字路径
+	granted bool
+}
+
+// queueLoad 是单个上游名称的转发数和排队数，用作监控指标的标签值。
+type queueLoad struct {
+	name     string
+	inFlight int
+	queued   int
+}
+
+// socketQueue 是单个套接字的并发计数和等待队列。
+type socketQueue struct {
+	inFlight int
+	waiters  [2][]*queueWaiter     // 下标 0 为优先身份，1 为其他请求，各自先进先出
+	loads    map[string]*queueLoad // 按上游名称拆分的计数，别名和 /proxy 共享同一套接字的名额
+}
+
+// load 返回 name 的计数，不存在时创建。
+func (q *socketQueue) load(name string) *queueLoad {
+	l := q.loads[name]
+	if l == nil {
+		l = &queueLoad{name: name}
+		q.loads[name] = l
+	}
+
+	return l
+}
+
+// pruneLoad 在 name 没有转发中和等待中的请求时移除其计数。
+func (q *socketQueue) pruneLoad(l *queueLoad) {
+	if l.inFlight <= 0 && l.queued <= 0 {
+		delete(q.loads, l.name)
+	}
+}
+
+// queued 返回等待中的请求数。
+func (q *socketQueue) queued() int {
+	return len(q.waiters[0]) + len(q.waiters[1])
+}
+
+// queueStatus 是 /admin/queues 返回的单个套接字的并发状态。
+type queueStatus struct {
+	Socket   string `json:"socket"`
+	InFlight int    `json:"in_flight"`
+	Queued   int    `json:"queued"`
+	Priority int    `json:"priority"` // 等待中的优先请求数
+}
+
+// concurrencyLimiter 按规范化的套接字路径限制同时转发的请求数。
+//
+// 名额用尽时请求进入该套接字的等待队列，名额释放后按先优先身份、再按到达顺序出队；
+// 队列已满或等待超过 QueueTimeout 的请求被拒绝。MaxInFlight 为 0 时不限制。
+// 只为有请求在转发或等待的套接字保留状态。
+type concurrencyLimiter struct {
+	mu     sync.Mutex
+	cfg    config.ConcurrencyConfig
+	queues map[string]*socketQueue
+}
+
+// newConcurrencyLimiter 创建并发限制器。
+func newConcurrencyLimiter(cfg config.ConcurrencyConfig) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		cfg:    cfg,
+		queues: make(map[string]*socketQueue),
+	}
+}
+
+// Configure 更新并发限制配置。提高或取消限制时，等待中的请求立即按新的名额出队；
+// 降低限制时已在转发的请求不受影响。
+func (cl *concurrencyLimiter) Configure(cfg config.ConcurrencyConfig) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.cfg = cfg
+
+	for _, q := range cl.queues {
+		cl.grantLocked(q)
+	}
+}
+
+// priority 报告身份是否为优先身份。
+func (cl *concurrencyLimiter) priority(identity string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	return identity != "" && slices.Contains(cl.cfg.PriorityIdentities, identity)
+}
+
+// acquire 为 socket 获取一个转发名额，必要时排队等待。name 是请求使用的上游名称，只用于监控指标。
+//
+// 成功时返回的 release 必须在请求结束后调用，重复调用无效；
+// 失败时返回原因：队列已满、等待超时或 ctx 被取消。
+func (cl *concurrencyLimiter) acquire(ctx context.Context, socket, name string, priority bool) (func(), string) {
+	cl.mu.Lock()
+
+	if cl.cfg.MaxInFlight <= 0 {
+		cl.mu.Unlock()
+
+		return func() {}, ""
+	}
+
+	q := cl.queues[socket]
+	if q == nil {
+		q = &socketQueue{loads: make(map[string]*queueLoad)}
+		cl.queues[socket] = q
+	}
+
+	if q.inFlight < cl.cfg.MaxInFlight && q.queued() == 0 {
+		q.inFlight++
+		q.load(name).inFlight++
+		cl.mu.Unlock()
+
+		return cl.releaser(socket, name), ""
+	}
+
+	if q.queued() >= cl.cfg.MaxQueue {
+		cl.mu.Unlock()
+
+		return nil, queueRejectFull
+	}
+
+	level := 1
+	if priority {
+		level = 0
+	}
+
+	w := &queueWaiter{ready: make(chan struct{}), name: name}
+	q.waiters[level] = append(q.waiters[level], w)
+	q.load(name).queued++
+	timeout := time.Duration(cl.cfg.QueueTimeout) * time.Millisecond
+
+	cl.mu.Unlock()
+
+	var expired <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	var reason string
+
+	select {
+	case <-w.ready:
+		return cl.releaser(socket, name), ""
+	case <-expired:
+		reason = queueRejectTimeout
+	case <-ctx.Done():
+		reason = queueRejectCanceled
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// Granted while the timer fired
+	if w.granted {
+		return cl.releaser(socket, name), ""
+	}
+
+	q.waiters[level] = slices.DeleteFunc(q.waiters[level], func(other *queueWaiter) bool { return other == w })
+
+	l := q.load(name)
+	l.queued--
+	q.pruneLoad(l)
+	cl.pruneLocked(socket, q)
+
+	return nil, reason
+}
+
+// releaser 返回释放名额的函数，调用方必须恰好调用一次。
+func (cl *concurrencyLimiter) releaser(socket, name string) func() {
+	return func() { cl.release(socket, name) }
+}
+
+// release 释放 name 占用的一个名额并让等待中的请求出队。
+func (cl *concurrencyLimiter) release(socket, name string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	q := cl.queues[socket]
+	if q == nil {
+		return
+	}
+
+	q.inFlight--
+
+	if l := q.loads[name]; l != nil {
+		l.inFlight--
+		q.pruneLoad(l)
+	}
+
+	cl.grantLocked(q)
+	cl.pruneLocked(socket, q)
+}
+
+// grantLocked 在名额允许时让等待中的请求出队。调用者必须持有 cl.mu。
+func (cl *concurrencyLimiter) grantLocked(q *socketQueue) {
+	for cl.cfg.MaxInFlight <= 0 || q.inFlight < cl.cfg.MaxInFlight {
+		level := 0
+		if len(q.waiters[0]) == 0 {
+			level = 1
+		}
+
+		if len(q.waiters[level]) == 0 {
+			return
+		}
+
+		w := q.waiters[level][0]
+		q.waiters[level] = q.waiters[level][1:]
+
+		w.granted = true
+		close(w.ready)
+		q.inFlight++
+
+		l := q.load(w.name)
+		l.queued--
+		l.inFlight++
+	}
+}
+
+// pruneLocked 在套接字没有转发中和等待中的请求时移除其状态。调用者必须持有 cl.mu。
+func (cl *concurrencyLimiter) pruneLocked(socket string, q *socketQueue) {
+	if q.inFlight <= 0 && q.queued() == 0 {
+		delete(cl.queues, socket)
+	}
+}
+
+// Status 返回有请求在转发或等待的套接字的并发状态，按套接字路径排序。
+func (cl *concurrencyLimiter) Status() []queueStatus {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	statuses := make([]queueStatus, 0, len(cl.queues))
+
+	for socket, q := range cl.queues {
+		statuses = append(statuses, queueStatus{
+			Socket:   socket,
+			InFlight: q.inFlight,
+			Queued:   q.queued(),
+			Priority: len(q.waiters[0]),
+		})
+	}
+
+	slices.SortFunc(statuses, func(a, b queueStatus) int { return strings.Compare(a.Socket, b.Socket) })
+
+	return statuses
+}
+
+// loads 返回有请求在转发或等待的上游名称的计数，按名称排序。
+// 同一名称通常只对应一个套接字；套接字的符号链接改变指向时，计数按名称合并。
+func (cl *concurrencyLimiter) loads() []queueLoad {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	byName := make(map[string]queueLoad)
+
+	for _, q := range cl.queues {
+		for name, l := range q.loads {
+			sum := byName[name]
+			sum.name = name
+			sum.inFlight += l.inFlight
+			sum.queued += l.queued
+			byName[name] = sum
+		}
+	}
+
+	loads := make([]queueLoad, 0, len(byName))
+	for _, l := range byName {
+		loads = append(loads, l)
+	}
+
+	slices.SortFunc(loads, func(a, b queueLoad) int { return strings.Compare(a.name, b.name) })
+
+	return loads
+}
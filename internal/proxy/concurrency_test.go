@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitQueued 等待 socket 的排队数达到 n。
+func waitQueued(t *testing.T, cl *concurrencyLimiter, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		for _, st := range cl.Status() {
+			if st.Queued == n {
+				return true
+			}
+		}
+
+		return n == 0
+	}, time.Second, time.Millisecond)
+}
+
+// TestConcurrencyLimiter_acquire 测试并发名额、排队顺序和排队失败
+func TestConcurrencyLimiter_acquire(t *testing.T) {
+	const socket = "/run/app.sock"
+
+	t.Run("不限制", func(t *testing.T) {
+		cl := newConcurrencyLimiter(config.ConcurrencyConfig{})
+
+		release, reason := cl.acquire(t.Context(), socket, "app", false)
+		require.NotNil(t, release)
+		assert.Empty(t, reason)
+		assert.Empty(t, cl.Status())
+	})
+
+	t.Run("优先身份先出队", func(t *testing.T) {
+		cl := newConcurrencyLimiter(config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 2, QueueTimeout: 5000})
+
+		release, _ := cl.acquire(t.Context(), socket, "app", false)
+		require.NotNil(t, release)
+
+		var (
+			mu    sync.Mutex
+			order []string
+			wg    sync.WaitGroup
+		)
+
+		enqueue := func(name string, priority bool) {
+			wg.Go(func() {
+				next, reason := cl.acquire(t.Context(), socket, "app", priority)
+				assert.Empty(t, reason)
+
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+
+				next()
+			})
+		}
+
+		enqueue("normal", false)
+		waitQueued(t, cl, 1)
+		enqueue("priority", true)
+		waitQueued(t, cl, 2)
+
+		assert.Equal(t, []queueStatus{{Socket: socket, InFlight: 1, Queued: 2, Priority: 1}}, cl.Status())
+		assert.Equal(t, []queueLoad{{name: "app", inFlight: 1, queued: 2}}, cl.loads())
+
+		_, reason := cl.acquire(t.Context(), socket, "app", true)
+		assert.Equal(t, queueRejectFull, reason)
+
+		release()
+		wg.Wait()
+
+		assert.Equal(t, []string{"priority", "normal"}, order)
+		assert.Empty(t, cl.Status())
+		assert.Empty(t, cl.loads())
+	})
+
+	t.Run("排队超时", func(t *testing.T) {
+		cl := newConcurrencyLimiter(config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20})
+
+		release, _ := cl.acquire(t.Context(), socket, "app", false)
+		defer release()
+
+		start := time.Now()
+		next, reason := cl.acquire(t.Context(), socket, "app", false)
+		assert.Nil(t, next)
+		assert.Equal(t, queueRejectTimeout, reason)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		assert.Equal(t, 0, cl.Status()[0].Queued)
+	})
+
+	t.Run("客户端断开", func(t *testing.T) {
+		cl := newConcurrencyLimiter(config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1})
+
+		release, _ := cl.acquire(t.Context(), socket, "app", false)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+		defer cancel()
+
+		_, reason := cl.acquire(ctx, socket, "app", false)
+		assert.Equal(t, queueRejectCanceled, reason)
+	})
+
+	t.Run("提高限制后立即出队", func(t *testing.T) {
+		cfg := config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 5000}
+		cl := newConcurrencyLimiter(cfg)
+
+		release, _ := cl.acquire(t.Context(), socket, "app", false)
+		defer release()
+
+		granted := make(chan func())
+
+		go func() {
+			next, _ := cl.acquire(t.Context(), socket, "app", false)
+			granted <- next
+		}()
+
+		waitQueued(t, cl, 1)
+
+		cfg.MaxInFlight = 2
+		cl.Configure(cfg)
+
+		next := <-granted
+		require.NotNil(t, next)
+		assert.Equal(t, 2, cl.Status()[0].InFlight)
+		next()
+	})
+}
+
+// TestConcurrencyLimiter_priority 测试优先身份的判断
+func TestConcurrencyLimiter_priority(t *testing.T) {
+	cl := newConcurrencyLimiter(config.ConcurrencyConfig{PriorityIdentities: []string{"ops"}})
+
+	assert.True(t, cl.priority("ops"))
+	assert.False(t, cl.priority("ci"))
+	assert.False(t, cl.priority(""))
+}
+
+// TestServer_Concurrency 测试代理请求的并发限制、错误码和监控指标
+func TestServer_Concurrency(t *testing.T) {
+	unblock := make(chan struct{})
+	entered := make(chan struct{}, 1)
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-unblock
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	server, err := NewServer(&config.Config{
+		Timeout:     5000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+		Metrics:     config.MetricsConfig{Enabled: true},
+		Concurrency: config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 0},
+	})
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		return rec
+	}
+
+	done := make(chan int)
+
+	go func() { done <- serve("/u/app/slow").Code }()
+
+	<-entered
+
+	rec := serve("/u/app/fast")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, codeUpstreamBusy, rec.Header().Get(errorHeader))
+
+	// The same socket through /proxy shares the slot
+	rec = serve("/proxy?path=" + socketPath + "&url=/fast")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = httptest.NewRecorder()
+	server.handleQueues(rec, httptest.NewRequest(http.MethodGet, "/admin/queues", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Queues []queueStatus `json:"queues"`
+	}
+
+	canonical, err := canonicalSocketPath(socketPath)
+	require.NoError(t, err)
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, []queueStatus{{Socket: canonical, InFlight: 1}}, body.Queues)
+
+	// Labelled like the request metrics: by alias, or by the requested path for /proxy
+	metrics := scrape(t, server.metrics.Handler())
+	assert.Contains(t, metrics, `uds_proxy_in_flight_requests{socket="app"} 1`)
+	assert.Contains(t, metrics, `uds_proxy_queue_depth{socket="app"} 0`)
+	assert.Contains(t, metrics, `uds_proxy_queue_rejections_total{reason="full",socket="app"} 1`)
+	assert.Contains(t, metrics, `uds_proxy_queue_rejections_total{reason="full",socket="`+socketPath+`"} 1`)
+
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	assert.Equal(t, http.StatusOK, serve("/u/app/fast").Code)
+}
+
+// TestServer_Concurrency_ReleaseOnce 测试每种响应方式都恰好释放一次名额。
+// 另一个请求一直占用一个名额，重复释放会使在途数低于 1
+func TestServer_Concurrency_ReleaseOnce(t *testing.T) {
+	unblock := make(chan struct{})
+	entered := make(chan struct{}, 1)
+	upgrade := upgradeBackend(t, "")
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hold":
+			entered <- struct{}{}
+			<-unblock
+		case "/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: 1\n\n"))
+		case "/attach":
+			upgrade.ServeHTTP(w, r)
+		case "/declined":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+
+	server, err := NewServer(&config.Config{
+		Timeout:     5000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+		Concurrency: config.ConcurrencyConfig{MaxInFlight: 2},
+	})
+	require.NoError(t, err)
+
+	// Signals after the handler and all of its deferred calls have returned
+	finished := make(chan struct{}, 1)
+	routes := server.routes()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { finished <- struct{}{} }()
+
+		routes.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	inFlight := func() int {
+		for _, st := range server.concurrency.Status() {
+			return st.InFlight
+		}
+
+		return 0
+	}
+
+	held := make(chan struct{})
+
+	go func() {
+		defer close(held)
+
+		resp, err := http.Get(proxy.URL + "/u/app/hold")
+		if assert.NoError(t, err) {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	<-entered
+
+	get := func(path string) {
+		resp, err := http.Get(proxy.URL + path)
+		require.NoError(t, err)
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	tests := []struct {
+		name string
+		run  func()
+	}{
+		{"普通响应", func() { get("/u/app/plain") }},
+		{"流式响应", func() { get("/u/app/stream") }},
+		{"协议升级", func() {
+			conn, reader, resp := dialUpgrade(t, proxy.URL, "/u/app/attach", "tcp")
+			require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+			_, _ = io.ReadAll(reader)
+		}},
+		{"后端拒绝升级", func() {
+			_, _, resp := dialUpgrade(t, proxy.URL, "/u/app/declined", "tcp")
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run()
+			<-finished
+
+			assert.Equal(t, 1, inFlight())
+		})
+	}
+
+	close(unblock)
+	<-held
+	<-finished
+
+	assert.Empty(t, server.concurrency.Status())
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader 是携带请求 ID 的请求头和响应头。
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 是接受的客户端请求 ID 的最大长度，更长的 ID 会被替换。
+const maxRequestIDLength = 128
+
+// contextKey 是本包存入请求上下文的键类型，避免与其他包冲突。
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	settingsKey
+	peerUIDKey
+)
+
+// requestInfo 记录请求处理过程中产生的信息。
+//
+// 它由最外层中间件创建并存入请求上下文，处理函数在处理过程中填写，
+// 中间件在请求结束后读取，用于记录指标和访问日志。
+// 同一请求的读写都发生在处理该请求的 goroutine 中，无需加锁。
+type requestInfo struct {
+	id          string // 请求 ID，用于关联访问日志和错误响应
+	errorCode   string // 网关错误码，后端响应或成功时为空
+	socket      string // 目标套接字名称（上游别名或套接字路径），非代理请求为空
+	socketPath  string // 实际连接的套接字路径，套接字不存在时也会填写
+	url         string // 发往后端的路径和查询参数
+	upstreamErr string // 后端请求失败的原因
+	method      string // 实际发往后端的 HTTP 方法
+	identity    string // 认证通过的身份标识，未启用认证时为空
+	attempts    int    // 后端请求的尝试次数，未发往后端时为 0
+	upgradedIn  int64  // 协议升级后客户端发往后端的字节数
+	upgradedOut int64  // 协议升级后后端发往客户端的字节数
+
+	log *slog.Logger // 附带请求 ID 的日志记录器
+}
+
+// withRequestInfo 返回携带新 requestInfo 的上下文，id 为请求 ID。
+func withRequestInfo(ctx context.Context, id string) (context.Context, *requestInfo) {
+	info := &requestInfo{id: id, log: slog.With("request_id", id)}
+
+	return context.WithValue(ctx, requestInfoKey, info), info
+}
+
+// requestInfoFrom 返回上下文中的 requestInfo。
+// 上下文中没有时返回一个临时实例，调用方无需判空。
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+
+	return &requestInfo{}
+}
+
+// RequestIDFromContext 返回上下文中的请求 ID，上下文不属于经过代理中间件的请求时返回空字符串。
+func RequestIDFromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		return info.id
+	}
+
+	return ""
+}
+
+// loggerFrom 返回附带请求 ID 的日志记录器，上下文中没有请求信息时返回默认记录器。
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok && info.log != nil {
+		return info.log
+	}
+
+	return slog.Default()
+}
+
+// requestID 返回请求的 ID。
+//
+// 依次使用客户端的 X-Request-ID、ctx 中 span 的 trace-id（启用追踪时）和 W3C traceparent 中的 trace-id，
+// 都没有或格式无效时生成新的 ID。X-Request-ID 只接受不超过 128 个字符的可打印 ASCII，
+// 避免客户端向日志注入换行等控制字符。
+func requestID(ctx context.Context, r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+
+	if traceID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		return traceID
+	}
+
+	return newRequestID()
+}
+
+// validRequestID 报告客户端提供的请求 ID 是否可以使用。
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseTraceparent 解析 W3C Trace Context 的 traceparent 头，返回其中的 trace-id。
+// 格式为 version-traceid-parentid-flags，全部为小写十六进制；
+// 版本 ff 和全零的 trace-id、parent-id 无效。
+func parseTraceparent(value string) (string, bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false
+	}
+
+	// Only version 00 has a fixed layout; later versions may append fields
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", false
+	}
+
+	for _, part := range parts[:4] {
+		if !isLowerHex(part) {
+			return "", false
+		}
+	}
+
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// isLowerHex 报告 s 是否只包含小写十六进制字符。
+func isLowerHex(s string) bool {
+	for i := range len(s) {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID 返回 32 位十六进制的随机请求 ID。
+func newRequestID() string {
+	var b [16]byte
+
+	_, _ = rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseTraceparent 测试 traceparent 请求头的解析
+func TestParseTraceparent(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"有效", "00-" + traceID + "-00f067aa0ba902b7-01", traceID},
+		{"未来版本附加字段", "01-" + traceID + "-00f067aa0ba902b7-01-extra", traceID},
+		{"空值", "", ""},
+		{"版本 ff", "ff-" + traceID + "-00f067aa0ba902b7-01", ""},
+		{"版本 00 附加字段", "00-" + traceID + "-00f067aa0ba902b7-01-extra", ""},
+		{"大写十六进制", "00-" + strings.ToUpper(traceID) + "-00f067aa0ba902b7-01", ""},
+		{"trace-id 全零", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"parent-id 全零", "00-" + traceID + "-0000000000000000-01", ""},
+		{"长度错误", "00-" + traceID[:31] + "-00f067aa0ba902b7-01", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTraceparent(tt.value)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want != "", ok)
+		})
+	}
+}
+
+// TestRequestID 测试请求 ID 的来源优先级和校验
+func TestRequestID(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name        string
+		requestID   string
+		traceparent string
+		want        string // 为空表示生成新的 ID
+	}{
+		{"使用 X-Request-ID", "req-123", traceparent, "req-123"},
+		{"使用 traceparent", "", traceparent, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"X-Request-ID 含空格", "bad id", "", ""},
+		{"X-Request-ID 过长", strings.Repeat("a", maxRequestIDLength+1), "", ""},
+		{"X-Request-ID 含非 ASCII", "请求", traceparent, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"都没有", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.requestID != "" {
+				r.Header.Set(requestIDHeader, tt.requestID)
+			}
+
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+
+			got := requestID(r.Context(), r)
+			if tt.want == "" {
+				assert.Len(t, got, 32)
+				assert.NotEqual(t, tt.requestID, got)
+			} else {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+// TestServer_RequestID 测试请求 ID 的传递：转发到后端、在响应中返回并写入日志
+func TestServer_RequestID(t *testing.T) {
+	var logs bytes.Buffer
+
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	var backendID, backendTraceparent string
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendID = r.Header.Get(requestIDHeader)
+		backendTraceparent = r.Header.Get("traceparent")
+		w.Header().Set(requestIDHeader, "backend-id")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	server, err := NewServer(&config.Config{
+		Timeout:   1000,
+		Upstreams: map[string]string{"app": socketPath},
+	})
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	t.Run("透传客户端的请求 ID", func(t *testing.T) {
+		logs.Reset()
+
+		req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+		req.Header.Set(requestIDHeader, "client-id")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "client-id", backendID)
+		assert.Equal(t, []string{"client-id"}, rec.Header().Values(requestIDHeader))
+
+		// Every line emitted while handling the request carries the ID
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		require.GreaterOrEqual(t, len(lines), 2)
+
+		for _, line := range lines {
+			assert.Contains(t, line, "request_id=client-id")
+		}
+	})
+
+	t.Run("使用 traceparent 的 trace-id", func(t *testing.T) {
+		const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+		req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+		req.Header.Set("traceparent", traceparent)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", backendID)
+		assert.Equal(t, traceparent, backendTraceparent)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rec.Header().Get(requestIDHeader))
+	})
+
+	t.Run("网关错误也返回请求 ID", func(t *testing.T) {
+		logs.Reset()
+
+		req := httptest.NewRequest(http.MethodGet, "/u/unknown/info", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(requestIDHeader)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Len(t, id, 32)
+		assert.Contains(t, logs.String(), `msg=上游未配置 request_id=`+id)
+	})
+
+	t.Run("上下文中的请求 ID", func(t *testing.T) {
+		var got string
+
+		h := server.accessLogMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			got = RequestIDFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(requestIDHeader, "ctx-id")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "ctx-id", got)
+		assert.Empty(t, RequestIDFromContext(t.Context()))
+	})
+}
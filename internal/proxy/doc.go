@@ -4,9 +4,30 @@
 // # 功能特性
 //
 // 本包包含以下功能：
-//   - 连接池，实现客户端复用以提高性能
+//   - 连接池，实现客户端复用以提高性能，限制客户端数量并清理空闲客户端
+//   - 检测后端重建的套接字文件（inode 变化），自动回收失效的空闲连接
 //   - 可配置的超时和连接数限制
-//   - 访问日志中间件
+//   - 幂等请求在收到响应前连接失败时自动重试，指数退避
+//   - 按套接字熔断，后端持续超时或失败时快速返回 503
+//   - 按套接字限制并发请求数，有界等待队列，可指定优先身份
+//   - 令牌桶限流：按客户端身份、IP 或目标套接字，可限定目标路径前缀，超出时返回 429
+//   - 网关错误携带稳定的错误码（X-UDS-Proxy-Error），可选 RFC 9457 problem details 响应体
+//   - 定期主动探测上游套接字，区分存活和就绪探针
+//   - 配置热加载，正在处理的请求不受影响
+//   - 优雅关闭：排空期间健康检查返回 503，结束流式响应和升级连接
+//   - Bearer Token / API Key 认证，令牌文件变更自动生效
+//   - 原生 TLS 和 mTLS，证书轮换后自动重新加载
+//   - 同时监听多个 TCP 和 Unix 套接字地址（含抽象命名空间）
+//   - systemd 套接字激活和 sd_notify 就绪/看门狗通知
+//   - 套接字访问策略（允许/拒绝列表，支持 glob 模式）
+//   - Docker API 内置策略（只读、禁止 exec、禁止特权容器），可按上游或身份指定
+//   - WebSocket 和 HTTP Upgrade（含 Docker 原始流）透传
+//   - 流式响应逐块刷新，支持 Docker events/logs 等长连接
+//   - 请求 ID 关联：接受 X-Request-ID 或 traceparent，转发到后端并在响应和日志中返回
+//   - 访问日志（slog、JSON、Apache combined 或自定义模板），可写入独立文件并按大小轮转、按路径和状态码过滤
+//   - 写请求审计日志：请求体脱敏，哈希链防篡改，可配置 fsync
+//   - Prometheus 指标
+//   - OpenTelemetry 链路追踪，导出到 OTLP、标准输出或文件
 //   - 健康检查和服务信息端点
 //
 // # 使用示例
@@ -32,8 +53,14 @@
 //
 // 服务器提供以下端点：
 //   - GET /         - 返回服务信息和使用说明
-//   - GET /health   - 健康检查端点
+//   - GET /health   - 健康检查端点，包含各上游的探测结果
+//   - GET /livez    - 存活探针
+//   - GET /readyz   - 就绪探针，必需的上游不可用时返回 503
 //   - GET /proxy    - 代理请求到 Unix 套接字
+//   - ALL /u/{name}/{path...} - 通过配置的上游别名代理请求
+//   - GET /admin/breakers - 各套接字熔断器的状态
+//   - GET /admin/queues - 各套接字的并发数和排队数
+//   - GET /metrics  - Prometheus 指标（可配置为独立监听地址）
 //
 // 代理端点参数：
 //   - path   (必需) Unix 套接字文件路径
@@ -43,4 +70,5 @@
 // 示例请求：
 //
 //	GET /proxy?path=/var/run/docker.sock&url=/containers/json
+//	GET /u/docker/containers/json
 package proxy
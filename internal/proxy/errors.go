@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// errorHeader 是网关错误响应携带的响应头，值为错误码。
+// 后端返回的响应没有这个响应头，调用方据此区分网关错误和后端错误。
+const errorHeader = "X-UDS-Proxy-Error"
+
+// problemContentType 是 RFC 9457 problem details 的媒体类型。
+const problemContentType = "application/problem+json"
+
+// 网关错误码。错误码是对外的稳定接口，只能新增，不能修改含义。
+const (
+	codeMissingPath       = "missing_path"
+	codeUnauthorized      = "unauthorized"
+	codeSocketDenied      = "socket_denied"
+	codeProfileDenied     = "profile_denied"
+	codeUpstreamNotFound  = "upstream_not_found"
+	codeSocketNotFound    = "socket_not_found"
+	codeNotASocket        = "not_a_socket"
+	codePermissionDenied  = "permission_denied"
+	codeConnectionRefused = "connection_refused"
+	codeUpstreamTimeout   = "upstream_timeout"
+	codeUpstreamError     = "upstream_error"
+	codeCircuitOpen       = "circuit_open"
+	codeRateLimited       = "rate_limited"
+	codeUpstreamBusy      = "upstream_busy"
+	codeProxyError        = "proxy_error"
+)
+
+// gatewayError 是错误码对应的状态码和说明。
+type gatewayError struct {
+	status int
+	detail string
+}
+
+// gatewayErrors 是全部网关错误码。
+//
+// 连接前检查套接字的四种结果使用互不相同的状态码，只看状态码的客户端和监控也能区分：
+// 套接字不存在通常是后端尚未启动，返回可重试的 503；套接字残留但无进程监听返回 502；
+// 进程不在套接字属组中是持续性的部署问题，重试无法恢复，返回 403；
+// 路径不是套接字是目标配置错误，不是代理故障，返回 422。
+var gatewayErrors = map[string]gatewayError{
+	codeMissingPath:       {http.StatusBadRequest, "the path query parameter is required"},
+	codeUnauthorized:      {http.StatusUnauthorized, "a valid token or client certificate is required"},
+	codeSocketDenied:      {http.StatusForbidden, "the socket is not allowed by the socket policy"},
+	codeProfileDenied:     {http.StatusForbidden, "the request is denied by a Docker API profile"},
+	codeUpstreamNotFound:  {http.StatusNotFound, "the upstream is not configured"},
+	codeSocketNotFound:    {http.StatusServiceUnavailable, "the socket file does not exist"},
+	codeNotASocket:        {http.StatusUnprocessableEntity, "the path exists but is not a Unix socket"},
+	codePermissionDenied:  {http.StatusForbidden, "the proxy has no permission to connect to the socket"},
+	codeConnectionRefused: {http.StatusBadGateway, "no process is listening on the socket"},
+	codeUpstreamTimeout:   {http.StatusGatewayTimeout, "the backend did not respond in time"},
+	codeUpstreamError:     {http.StatusBadGateway, "the connection to the backend failed"},
+	codeCircuitOpen:       {http.StatusServiceUnavailable, "the circuit breaker for the socket is open"},
+	codeRateLimited:       {http.StatusTooManyRequests, "the request exceeds a rate limit"},
+	codeUpstreamBusy:      {http.StatusServiceUnavailable, "the socket is at its concurrency limit and the wait queue is full or timed out"},
+	codeProxyError:        {http.StatusBadGateway, "the proxy failed to relay the request"},
+}
+
+// problem 是 RFC 9457 problem details 响应体。
+// type 为 about:blank，title 为状态码的标准描述，code 等为扩展成员。
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	Socket    string `json:"socket,omitempty"`
+	URL       string `json:"url,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// upstreamErrorCode 将后端请求错误归类为错误码。
+func upstreamErrorCode(err error) string {
+	switch {
+	case os.IsTimeout(err):
+		return codeUpstreamTimeout
+	case errors.Is(err, syscall.ENOENT):
+		return codeSocketNotFound
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return codePermissionDenied
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return codeConnectionRefused
+	default:
+		return codeUpstreamError
+	}
+}
+
+// writeError 写入网关错误响应。
+//
+// 响应总是携带 [errorHeader]。默认不返回响应体，保持网关错误无 body 的约定；
+// 配置了 problem_details 或请求的 Accept 包含 application/problem+json 时，
+// 返回 RFC 9457 problem details，包含错误码、目标套接字、目标路径和请求 ID。
+// target 为空表示请求尚未确定后端目标。
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, code string, target proxyTarget) {
+	e := gatewayErrors[code]
+	info := requestInfoFrom(r.Context())
+	info.errorCode = code
+
+	w.Header().Set(errorHeader, code)
+
+	if !s.settingsFor(r).config.ProblemDetails && !acceptsProblem(r) {
+		w.WriteHeader(e.status)
+
+		return
+	}
+
+	body := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.status),
+		Status:    e.status,
+		Detail:    e.detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		Socket:    target.name,
+		URL:       target.requestURI(),
+		RequestID: info.id,
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(e.status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("JSON编码失败", "error", err)
+	}
+}
+
+// acceptsProblem 报告请求的 Accept 是否明确包含 application/problem+json。
+func acceptsProblem(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for part := range strings.SplitSeq(value, ",") {
+			mediaType, _, _ := strings.Cut(part, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), problemContentType) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
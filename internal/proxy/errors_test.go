@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUpstreamErrorCode 测试后端错误的分类
+func TestUpstreamErrorCode(t *testing.T) {
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "unix", Err: os.NewSyscallError("connect", errno)}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"超时", os.ErrDeadlineExceeded, codeUpstreamTimeout},
+		{"套接字不存在", dialErr(syscall.ENOENT), codeSocketNotFound},
+		{"没有权限", dialErr(syscall.EACCES), codePermissionDenied},
+		{"连接被拒绝", dialErr(syscall.ECONNREFUSED), codeConnectionRefused},
+		{"连接被重置", dialErr(syscall.ECONNRESET), codeUpstreamError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, upstreamErrorCode(tt.err))
+		})
+	}
+}
+
+// TestGatewayErrors 测试每个错误码的状态码。错误码和状态码是对外的稳定接口
+func TestGatewayErrors(t *testing.T) {
+	tests := []struct {
+		code string
+		want int
+	}{
+		{codeMissingPath, http.StatusBadRequest},
+		{codeUnauthorized, http.StatusUnauthorized},
+		{codeSocketDenied, http.StatusForbidden},
+		{codeProfileDenied, http.StatusForbidden},
+		{codeUpstreamNotFound, http.StatusNotFound},
+		{codeSocketNotFound, http.StatusServiceUnavailable},
+		{codeNotASocket, http.StatusUnprocessableEntity},
+		{codePermissionDenied, http.StatusForbidden},
+		{codeConnectionRefused, http.StatusBadGateway},
+		{codeUpstreamTimeout, http.StatusGatewayTimeout},
+		{codeUpstreamError, http.StatusBadGateway},
+		{codeCircuitOpen, http.StatusServiceUnavailable},
+		{codeRateLimited, http.StatusTooManyRequests},
+		{codeUpstreamBusy, http.StatusServiceUnavailable},
+		{codeProxyError, http.StatusBadGateway},
+	}
+
+	require.Len(t, gatewayErrors, len(tests))
+
+	for _, tt := range tests {
+		e, ok := gatewayErrors[tt.code]
+		require.True(t, ok, tt.code)
+		assert.Equal(t, tt.want, e.status, tt.code)
+		assert.NotEmpty(t, e.detail, tt.code)
+	}
+}
+
+// TestAcceptsProblem 测试 Accept 请求头的解析
+func TestAcceptsProblem(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/problem+json", true},
+		{"application/json, Application/Problem+JSON;q=0.9", true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+
+		assert.Equal(t, tt.want, acceptsProblem(r), tt.accept)
+	}
+}
+
+// newDeadSocket 创建一个没有进程监听的套接字文件，连接时返回 ECONNREFUSED。
+func newDeadSocket(t *testing.T) string {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "dead.sock")
+
+	var lc net.ListenConfig
+
+	listener, err := lc.Listen(context.Background(), "unix", socketPath)
+	require.NoError(t, err)
+
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, listener.Close())
+
+	return socketPath
+}
+
+// TestServer_writeError 测试网关错误的响应头和 problem details 响应体
+func TestServer_writeError(t *testing.T) {
+	dir := t.TempDir()
+	refused := newDeadSocket(t)
+
+	cfg := &config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams: map[string]string{
+			"missing": filepath.Join(dir, "missing.sock"),
+			"refused": refused,
+		},
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	serve := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("默认无响应体", func(t *testing.T) {
+		tests := []struct {
+			target string
+			status int
+			code   string
+		}{
+			{"/proxy", http.StatusBadRequest, codeMissingPath},
+			{"/u/unknown/info", http.StatusNotFound, codeUpstreamNotFound},
+			{"/u/missing/info", http.StatusServiceUnavailable, codeSocketNotFound},
+			{"/u/refused/info", http.StatusBadGateway, codeConnectionRefused},
+		}
+
+		for _, tt := range tests {
+			rec := serve(tt.target, "")
+			assert.Equal(t, tt.status, rec.Code, tt.target)
+			assert.Equal(t, tt.code, rec.Header().Get(errorHeader), tt.target)
+			assert.Empty(t, rec.Body.String(), tt.target)
+		}
+	})
+
+	t.Run("Accept 请求 problem details", func(t *testing.T) {
+		rec := serve("/u/refused/containers/json?all=1", "application/problem+json")
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"))
+
+		var body problem
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "about:blank", body.Type)
+		assert.Equal(t, "Bad Gateway", body.Title)
+		assert.Equal(t, http.StatusBadGateway, body.Status)
+		assert.Equal(t, codeConnectionRefused, body.Code)
+		assert.Equal(t, "refused", body.Socket)
+		assert.Equal(t, "/containers/json?all=1", body.URL)
+		assert.Equal(t, "/u/refused/containers/json", body.Instance)
+		assert.Len(t, body.RequestID, 32)
+	})
+
+	t.Run("配置开启 problem details", func(t *testing.T) {
+		next := *cfg
+		next.ProblemDetails = true
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+
+		rec := serve("/proxy", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var body problem
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, codeMissingPath, body.Code)
+		assert.Empty(t, body.Socket)
+	})
+
+	t.Run("后端响应不带错误头", func(t *testing.T) {
+		socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+
+		rec := serve("/proxy?path="+socketPath, "")
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Empty(t, rec.Header().Get(errorHeader))
+	})
+}
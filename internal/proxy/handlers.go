@@ -1,19 +1,24 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lwmacct/251207-go-pkg-version/pkg/version"
 )
 
 // handleRoot 处理根路径请求，返回服务信息。
-// 响应包含服务名称、版本、描述和使用示例。
+// 响应包含服务名称、版本、描述、用法以及已配置的上游别名。
+// 上游只列出访问入口，不暴露对应的套接字路径。
 func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
@@ -21,17 +26,24 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	configured := s.settingsFor(r).config.Upstreams
+
+	upstreams := make(map[string]string, len(configured))
+	for name := range configured {
+		upstreams[name] = "/u/" + name + "/"
+	}
+
+	usage := "GET /proxy?path={socket}&url={path}"
+	if len(upstreams) > 0 {
+		usage = "GET /u/{upstream}/{path}"
+	}
+
 	info := map[string]any{
 		"service":     "uds-proxy",
 		"version":     version.GetVersion(),
 		"description": "HTTP server that proxies requests to Unix domain sockets",
-		"usage":       "GET /proxy?path=/var/run/docker.sock&url=/containers/json",
-		"examples": map[string]string{
-			"获取容器列表": "GET /proxy?path=/var/run/docker.sock&url=/containers/json",
-			"获取镜像列表": "GET /proxy?path=/var/run/docker.sock&url=/images/json",
-			"获取系统信息": "GET /proxy?path=/var/run/docker.sock&url=/info",
-			"获取版本信息": "GET /proxy?path=/var/run/docker.sock&url=/version",
-		},
+		"usage":       usage,
+		"upstreams":   upstreams,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -43,17 +55,98 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 
 // handleHealth 处理健康检查请求。
 // 返回 JSON 格式的健康状态信息，用于负载均衡器或监控系统。
+// 服务器开始关闭后返回 503 和 "draining" 状态，使负载均衡器尽快摘除流量。
+//
+// 启用上游健康检查时同时返回各上游的状态，有上游不是 up（包括尚未完成首次探测）时返回 200 和 "degraded"。
+// 必需的上游不可用时同样返回 200，是否接收流量由 [Server.handleReadyz] 判断。
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	upstreams := s.health.Status(s.settingsFor(r))
+
+	status, code := "healthy", http.StatusOK
 
-	if err := json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
+	if s.draining.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	} else {
+		for _, upstream := range upstreams {
+			if upstream.Status != upstreamUp {
+				status = "degraded"
+			}
+		}
+	}
+
+	resp := map[string]any{
+		"status":  status,
 		"service": "uds-proxy",
-	}); err != nil {
+	}
+
+	if len(upstreams) > 0 {
+		resp["upstreams"] = upstreams
+	}
+
+	writeJSON(w, code, resp)
+}
+
+// handleLivez 处理存活探针请求，进程能够处理请求即返回 200。
+// 关闭过程中和上游不可用时同样返回 200，避免编排系统重启只是暂时无法服务的实例。
+func (s *Server) handleLivez(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz 处理就绪探针请求。
+// 服务器开始关闭或必需的上游不可用（包括尚未完成首次探测）时返回 503。
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+
+		return
+	}
+
+	if names := unavailable(s.health.Status(s.settingsFor(r))); len(names) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"status":    "unavailable",
+			"upstreams": names,
+		})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// writeJSON 以 JSON 格式写入状态码 code 和响应体 v。
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
 		slog.Error("JSON编码失败", "error", err)
 	}
 }
 
+// handleBreakers 返回各套接字熔断器的状态。
+// 只列出最近出现过失败的套接字，未列出的套接字处于关闭（正常）状态。
+func (s *Server) handleBreakers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"breakers": s.breakers.Status()})
+}
+
+// handleQueues 返回各套接字的并发数和排队数。
+func (s *Server) handleQueues(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"queues": s.concurrency.Status()})
+}
+
+// proxyTarget 描述一次代理请求的后端目标。
+type proxyTarget struct {
+	name       string // 连接池中的客户端名称：上游别名或规范化后的套接字路径
+	socketPath string // 实际连接的 Unix 套接字路径
+	method     string // 后端请求的 HTTP 方法
+	url        string // 后端请求的完整 URL，主机固定为 localhost
+}
+
+// requestURI 返回发往后端的路径和查询参数。
+func (t proxyTarget) requestURI() string {
+	return strings.TrimPrefix(t.url, "http://localhost")
+}
+
 // handleProxy 是核心代理处理函数，将 HTTP 请求转发到 Unix 域套接字。
 //
 // 请求参数：
@@ -62,19 +155,22 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 //   - method: (可选) HTTP 方法，默认使用请求本身的方法
 //
 // 其他查询参数会被透传到后端请求。请求头（除 hop-by-hop 头）也会被复制。
+// 套接字路径在连接前会被规范化并按 [SocketPolicy] 检查，不在允许范围内时返回 403；
+// 方法和 url 目标路径还会按适用的 Docker API 策略检查。
 func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	// Get socket path from query parameter
-	socketPath := r.URL.Query().Get("path")
-	if socketPath == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	requestedPath := r.URL.Query().Get("path")
+	if requestedPath == "" {
+		s.writeError(w, r, codeMissingPath, proxyTarget{})
 
 		return
 	}
 
-	// Verify socket exists
-	if _, err := os.Stat(socketPath); os.IsNotExist(err) {
-		slog.Warn("socket文件不存在", "path", socketPath)
-		w.WriteHeader(http.StatusBadGateway)
+	// Enforce socket policy on the canonical path
+	socketPath, allowed := s.settingsFor(r).policy.Check(requestedPath)
+	if !allowed {
+		loggerFrom(r.Context()).Warn("socket不在允许范围内", "path", requestedPath, "resolved", socketPath)
+		s.writeError(w, r, codeSocketDenied, proxyTarget{name: requestedPath})
 
 		return
 	}
@@ -91,8 +187,6 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		method = r.Method
 	}
 
-	method = strings.ToUpper(method)
-
 	// Build query parameters (excluding proxy-specific ones)
 	queryParams := url.Values{}
 
@@ -110,58 +204,234 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		targetURL += "?" + queryParams.Encode()
 	}
 
-	slog.Debug("代理请求", "method", method, "url", targetURL, "socket", socketPath)
+	s.forward(w, r, proxyTarget{
+		name:       socketPath,
+		socketPath: socketPath,
+		method:     strings.ToUpper(method),
+		url:        targetURL,
+	})
+}
 
-	// Create backend request
-	backendReq, err := http.NewRequestWithContext(r.Context(), method, targetURL, r.Body)
-	if err != nil {
-		slog.Error("创建请求失败", "error", err)
-		w.WriteHeader(http.StatusBadGateway)
+// handleUpstream 通过配置的上游别名代理请求，路由格式为 /u/{name}/{path...}。
+//
+// 别名之后的路径和全部查询参数原样透传到后端，HTTP 方法使用请求本身的方法，
+// 与 [Server.handleProxy] 一样统一为大写，策略和重试对两种路由的判断一致。
+// 调用方无需知道套接字的文件系统路径；未配置的别名返回 404。
+func (s *Server) handleUpstream(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	socketPath, ok := s.settingsFor(r).config.Upstreams[name]
+	if !ok {
+		loggerFrom(r.Context()).Warn("上游未配置", "upstream", name)
+		s.writeError(w, r, codeUpstreamNotFound, proxyTarget{name: name})
 
 		return
 	}
 
-	// Copy headers (excluding hop-by-hop headers)
-	for key, values := range r.Header {
-		lowerKey := strings.ToLower(key)
-		if lowerKey == "host" || lowerKey == "content-length" || lowerKey == "transfer-encoding" {
-			continue
-		}
+	targetURL := "http://localhost" + upstreamPath(r)
+	if r.URL.RawQuery != "" {
+		targetURL += "?" + r.URL.RawQuery
+	}
 
-		for _, v := range values {
-			backendReq.Header.Add(key, v)
-		}
+	s.forward(w, r, proxyTarget{
+		name:       name,
+		socketPath: socketPath,
+		method:     strings.ToUpper(r.Method),
+		url:        targetURL,
+	})
+}
+
+// upstreamPath 返回 /u/{name} 之后的路径，保留客户端的原始转义。
+// 按路径段去掉别名，不要求客户端对别名的转义方式与 [url.PathEscape] 相同。
+func upstreamPath(r *http.Request) string {
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), "/u/")
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		return rest[i:]
+	}
+
+	return "/"
+}
+
+// forward 将请求转发到 target 指定的 Unix 套接字并回写响应。
+// 转发前按适用的 Docker API 策略检查请求，被拒绝时返回 403；
+// 套接字不存在、不是套接字、没有权限或无进程监听时分别返回 503、422、403 和 502；
+// 熔断器打开时返回 503 和 Retry-After；套接字的并发名额用尽且排队失败时返回 503。
+// 名额在收到流式响应的响应头或协议升级完成后即释放，此后的流和隧道不计入并发数。
+// 请求头（除 hop-by-hop 头）会被复制，后端响应的状态码、响应头和响应体原样透传。
+// 长度未知的流式响应由 [streamBody] 逐块刷新，不受超时限制；
+// 其他响应的响应体须在收到响应头后的超时时间内传输完毕，否则中断连接；超时时间为 0 时不限制。
+// 协议升级请求（WebSocket、Docker attach/exec 等）交由 [Server.tunnel] 处理。
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, target proxyTarget) {
+	socketPath := target.socketPath
+	log := loggerFrom(r.Context())
+
+	// Annotate the access log, even if the request is rejected below
+	info := requestInfoFrom(r.Context())
+	info.socketPath = socketPath
+	info.url = target.requestURI()
+
+	// Enforce Docker API profiles before touching the socket
+	if !s.checkProfiles(w, r, target) {
+		return
 	}
 
-	// Get client from pool and make request
-	client := s.pool.GetClient(socketPath)
+	// Classify missing, non-socket and inaccessible paths before dialing
+	if code := preflightSocket(r.Context(), socketPath); code != "" {
+		s.writeError(w, r, code, target)
+
+		return
+	}
+
+	// Annotate the request for metrics; only existing sockets become label values
+	info.socket = target.name
+	info.method = target.method
+
+	log.Debug("代理请求", "method", target.method, "url", target.url, "socket", socketPath)
+
+	// Aliases and /proxy reaching the same socket share its state
+	socketKey := socketPath
+	if canonical, err := canonicalSocketPath(socketPath); err == nil {
+		socketKey = canonical
+	}
+
+	// Fail fast while the socket's circuit breaker is open, before queueing for a slot
+	done, retryAfter, ok := s.breakers.acquire(socketKey)
+	if !ok {
+		log.Warn("熔断器已打开，拒绝请求", "socket", socketPath, "retry_after", retryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		s.writeError(w, r, codeCircuitOpen, target)
+
+		return
+	}
+
+	// Wait for a slot while the socket is at its concurrency limit
+	release, reason := s.concurrency.acquire(r.Context(), socketKey, target.name, s.concurrency.priority(info.identity))
+	if release == nil {
+		// Give up a half-open probe without counting it as a success or failure
+		done(context.Canceled)
+
+		log.Warn("并发已满，拒绝请求", "socket", socketPath, "reason", reason)
+		s.metrics.queueRejected(target.name, reason)
+		s.writeError(w, r, codeUpstreamBusy, target)
+
+		return
+	}
+
+	// Upgrade requests bypass the pooled client and get a dedicated connection
+	if isUpgradeRequest(r) {
+		// The verdict and the slot cover the handshake, not the upgraded connection
+		s.tunnel(w, r, target, func(err error) {
+			done(err)
+			release()
+		})
+
+		return
+	}
+
+	// Cancelled when the handler returns, or earlier for streams on shutdown
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Send the request, retrying idempotent ones that fail before any response
+	resp, attempts, err := s.roundTrip(ctx, r, target, s.settingsFor(r).config.Retry)
+	done(err)
+
+	// Long-lived streams would hold the slot indefinitely; release it once the headers arrive
+	streaming := err == nil && isStreamingResponse(resp)
+	if streaming {
+		release()
+	} else {
+		defer release()
+	}
+
+	info.attempts = attempts
+	w.Header().Set(attemptsHeader, strconv.Itoa(attempts))
 
-	resp, err := client.Do(backendReq)
 	if err != nil {
-		// Remove client from pool on connection error
-		s.pool.RemoveClient(socketPath)
+		info.upstreamErr = err.Error()
 
 		if os.IsTimeout(err) {
-			slog.Warn("请求超时", "socket", socketPath, "error", err)
-			w.WriteHeader(http.StatusGatewayTimeout)
+			log.Warn("请求超时", "socket", socketPath, "attempts", attempts, "error", err)
 		} else {
-			slog.Warn("连接失败", "socket", socketPath, "error", err)
-			w.WriteHeader(http.StatusBadGateway)
+			log.Warn("连接失败", "socket", socketPath, "attempts", attempts, "error", err)
 		}
 
+		s.writeError(w, r, upstreamErrorCode(err), target)
+
 		return
 	}
 
 	defer func() { _ = resp.Body.Close() }()
 
 	// Copy response headers
-	for key, values := range resp.Header {
-		for _, v := range values {
-			w.Header().Add(key, v)
-		}
-	}
+	copyHeader(w.Header(), resp.Header)
+	w.Header().Set(requestIDHeader, info.id)
 
 	// Write status code and body
 	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+
+	copyCtx := s.tracing.startCopy(ctx)
+
+	if streaming {
+		// Infinite streams would hold up shutdown; end them cleanly instead
+		defer s.untilShutdown(cancel)()
+
+		n := streamBody(w, r, resp.Body)
+		s.tracing.endCopy(copyCtx, n, nil)
+
+		return
+	}
+
+	// The transport only bounds the headers; a stalled body would hold the request forever
+	if timeout := s.settingsFor(r).timeout(); timeout > 0 {
+		timer := time.AfterFunc(timeout, cancel)
+		defer timer.Stop()
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil && ctx.Err() != nil && r.Context().Err() == nil {
+		log.Warn("读取响应体超时", "socket", socketPath, "bytes", n)
+	}
+
+	s.tracing.endCopy(copyCtx, n, err)
+}
+
+// newBackendRequest 根据客户端请求构建发往 target 的后端请求，后端请求使用 ctx 控制取消。
+// 请求体和长度原样保留（无请求体时使用 [http.NoBody]，避免被当作分块传输），
+// 请求头按 [copyRequestHeader] 的规则复制。
+func newBackendRequest(ctx context.Context, r *http.Request, target proxyTarget) (*http.Request, error) {
+	body := r.Body
+	if r.ContentLength == 0 {
+		body = http.NoBody
+	}
+
+	backendReq, err := http.NewRequestWithContext(ctx, target.method, target.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	backendReq.ContentLength = r.ContentLength
+
+	copyRequestHeader(backendReq.Header, r.Header)
+
+	// Let the backend log the same ID; traceparent is passed through as is
+	if id := RequestIDFromContext(ctx); id != "" {
+		backendReq.Header.Set(requestIDHeader, id)
+	}
+
+	return backendReq, nil
+}
+
+// copyRequestHeader 将客户端请求头复制到后端请求，跳过 Host 和消息长度相关的头。
+func copyRequestHeader(dst, src http.Header) {
+	for key, values := range src {
+		lowerKey := strings.ToLower(key)
+		if lowerKey == "host" || lowerKey == "content-length" || lowerKey == "transfer-encoding" {
+			continue
+		}
+
+		for _, v := range values {
+			dst.Add(key, v)
+		}
+	}
 }
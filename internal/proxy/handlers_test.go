@@ -1,9 +1,16 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/lwmacct/251124-uds-proxy/internal/config"
@@ -47,7 +54,28 @@ func TestServer_handleRoot(t *testing.T) {
 		assert.Equal(t, version.GetVersion(), resp["version"])
 		assert.NotEmpty(t, resp["description"])
 		assert.NotEmpty(t, resp["usage"])
-		assert.NotNil(t, resp["examples"])
+		assert.NotNil(t, resp["upstreams"])
+	})
+
+	t.Run("列出上游别名且不暴露套接字路径", func(t *testing.T) {
+		server, err := NewServer(&config.Config{
+			Upstreams: map[string]string{
+				"docker": "/var/run/docker.sock",
+				"app":    "/run/app/api.sock",
+			},
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		server.handleRoot(rec, req)
+
+		var resp map[string]any
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, map[string]any{"docker": "/u/docker/", "app": "/u/app/"}, resp["upstreams"])
+		assert.NotContains(t, rec.Body.String(), ".sock")
 	})
 
 	t.Run("非根路径返回 404", func(t *testing.T) {
@@ -81,6 +109,22 @@ func TestServer_handleHealth(t *testing.T) {
 	assert.Equal(t, "uds-proxy", resp["service"])
 }
 
+// TestServer_handleHealth_Draining 测试关闭过程中健康检查返回 503
+func TestServer_handleHealth_Draining(t *testing.T) {
+	server := newTestServer()
+	server.draining.Store(true)
+
+	rec := httptest.NewRecorder()
+	server.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var resp map[string]string
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "draining", resp["status"])
+}
+
 // TestServer_handleProxy 测试代理处理
 func TestServer_handleProxy(t *testing.T) {
 	server := newTestServer()
@@ -95,15 +139,192 @@ func TestServer_handleProxy(t *testing.T) {
 		assert.Empty(t, rec.Body.Bytes()) // 纯网关模式：无 body
 	})
 
-	t.Run("socket 文件不存在返回 502", func(t *testing.T) {
+	t.Run("socket 文件不存在返回 503", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/proxy?path=/nonexistent/socket.sock", nil)
 		rec := httptest.NewRecorder()
 
 		server.handleProxy(rec, req)
 
-		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Empty(t, rec.Body.Bytes()) // 纯网关模式：无 body
+	})
+}
+
+// TestServer_handleProxy_Policy 测试套接字访问策略
+func TestServer_handleProxy_Policy(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		Host:           "127.0.0.1",
+		Timeout:        1000,
+		AllowedSockets: []string{"/nonexistent/allowed/*.sock"},
+		DeniedSockets:  []string{"/nonexistent/allowed/denied.sock"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"允许的套接字继续处理", "/nonexistent/allowed/app.sock", http.StatusServiceUnavailable},
+		{"不在允许列表中返回 403", "/var/run/docker.sock", http.StatusForbidden},
+		{"拒绝列表中返回 403", "/nonexistent/allowed/denied.sock", http.StatusForbidden},
+		{"路径穿越返回 403", "/nonexistent/allowed/../../var/run/docker.sock", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/proxy?path="+tt.path, nil)
+			rec := httptest.NewRecorder()
+
+			server.handleProxy(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Empty(t, rec.Body.Bytes()) // 纯网关模式：无 body
+		})
+	}
+
+	t.Run("非法模式无法创建服务器", func(t *testing.T) {
+		_, err := NewServer(&config.Config{AllowedSockets: []string{"/run/[.sock"}})
+
+		assert.Error(t, err)
+	})
+}
+
+// newUnixBackend 在临时 Unix 套接字上启动测试后端，返回套接字路径。
+// 使用短路径目录，避免超出 Unix 套接字路径长度限制。
+func newUnixBackend(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "uds")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "backend.sock")
+
+	var lc net.ListenConfig
+
+	listener, err := lc.Listen(context.Background(), "unix", socketPath)
+	require.NoError(t, err)
+
+	backend := httptest.NewUnstartedServer(handler)
+	backend.Listener = listener
+	backend.Start()
+	t.Cleanup(backend.Close)
+
+	return socketPath
+}
+
+// echoHandler 以 JSON 返回收到的请求信息，用于验证转发结果。
+func echoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"method":            r.Method,
+			"path":              r.URL.Path,
+			"query":             r.URL.RawQuery,
+			"body":              string(body),
+			"content_length":    strconv.FormatInt(r.ContentLength, 10),
+			"transfer_encoding": strings.Join(r.TransferEncoding, ","),
+		})
+	})
+}
+
+// TestServer_handleUpstream 测试通过上游别名代理
+func TestServer_handleUpstream(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+
+	server, err := NewServer(&config.Config{
+		Timeout:   1000,
+		Upstreams: map[string]string{"app": socketPath, "my-sock": socketPath},
+	})
+	require.NoError(t, err)
+
+	handler := server.routes()
+
+	t.Run("转发路径、查询参数和方法", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/u/app/containers/abc?force=1&v=true", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp map[string]string
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, http.MethodDelete, resp["method"])
+		assert.Equal(t, "/containers/abc", resp["path"])
+		assert.Equal(t, "force=1&v=true", resp["query"])
+	})
+
+	t.Run("请求体和长度原样转发", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/u/app/containers/create", strings.NewReader(`{"Image":"nginx"}`))
+		rec := httptest.NewRecorder()
+
+		server.accessLogMiddleware(handler).ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp map[string]string
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.JSONEq(t, `{"Image":"nginx"}`, resp["body"])
+		assert.Equal(t, "17", resp["content_length"])
+		assert.Empty(t, resp["transfer_encoding"])
+	})
+
+	t.Run("无请求体时不使用分块传输", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+		rec := httptest.NewRecorder()
+
+		server.accessLogMiddleware(handler).ServeHTTP(rec, req)
+
+		var resp map[string]string
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "0", resp["content_length"])
+		assert.Empty(t, resp["transfer_encoding"])
+	})
+
+	t.Run("别名的转义方式不同", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/u/my%2Dsock/containers/json", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"path":"/containers/json"`)
+	})
+
+	t.Run("别名根路径", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/u/app/", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"path":"/"`)
+	})
+
+	t.Run("未配置的别名返回 404", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/u/unknown/info", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
 		assert.Empty(t, rec.Body.Bytes()) // 纯网关模式：无 body
 	})
+
+	t.Run("连接池以别名为键", func(t *testing.T) {
+		server.pool.mu.RLock()
+		_, exists := server.pool.clients["app"]
+		server.pool.mu.RUnlock()
+
+		assert.True(t, exists)
+	})
 }
 
 // TestServer_handleRoot_Methods 测试不同 HTTP 方法
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// 上游健康状态。
+const (
+	upstreamUp      = "up"
+	upstreamDown    = "down"
+	upstreamUnknown = "unknown" // 尚未完成探测
+)
+
+// upstreamHealth 是 /health 返回的单个上游状态。
+type upstreamHealth struct {
+	Status    string     `json:"status"`
+	Required  bool       `json:"required,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// probeResult 是一次探测的结果。
+type probeResult struct {
+	socketPath string // 探测的套接字路径，上游改为其他路径后结果作废
+	err        error
+	checkedAt  time.Time
+}
+
+// healthChecker 定期主动探测配置的上游套接字。
+//
+// 每轮探测从当前配置快照读取上游和探测设置，重新加载后新增或修改的上游在下一轮生效。
+// 配置了 path 的上游发送 HTTP GET 请求，2xx 和 3xx 响应视为正常；否则只检查能否建立连接。
+// 探测使用独立的连接，不占用连接池，也不受熔断器影响。
+type healthChecker struct {
+	settings func() *settings
+
+	mu       sync.Mutex
+	results  map[string]probeResult // 按上游别名
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// newHealthChecker 创建健康检查器，settings 返回当前配置快照。
+// 调用 [healthChecker.Configure] 后才开始探测。
+func newHealthChecker(settings func() *settings) *healthChecker {
+	return &healthChecker{
+		settings: settings,
+		results:  make(map[string]probeResult),
+	}
+}
+
+// Configure 按 interval 启动或重启定期探测，立即开始第一轮；interval 为 0 时停止探测并清除结果。
+func (hc *healthChecker) Configure(interval time.Duration) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if interval == hc.interval && (hc.stop != nil || interval <= 0) {
+		return
+	}
+
+	hc.stopLocked()
+	hc.interval = interval
+
+	if interval <= 0 {
+		clear(hc.results)
+
+		return
+	}
+
+	stop := make(chan struct{})
+	hc.stop = stop
+
+	go hc.run(interval, stop)
+}
+
+// Close 停止定期探测。
+func (hc *healthChecker) Close() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hc.stopLocked()
+}
+
+// stopLocked 停止探测循环。调用者必须持有 hc.mu。
+func (hc *healthChecker) stopLocked() {
+	if hc.stop != nil {
+		close(hc.stop)
+		hc.stop = nil
+	}
+}
+
+// run 每隔 interval 探测一轮，直到 stop 关闭。
+func (hc *healthChecker) run(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		hc.checkAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll 并发探测全部上游并记录结果，状态变化时写日志。
+func (hc *healthChecker) checkAll(ctx context.Context) {
+	cfg := hc.settings().config
+	timeout := time.Duration(cfg.HealthCheck.Timeout) * time.Millisecond
+
+	results := make(map[string]probeResult, len(cfg.Upstreams))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for name, socketPath := range cfg.Upstreams {
+		check := cfg.HealthCheck.Upstreams[name]
+
+		wg.Go(func() {
+			err := probeUpstream(ctx, socketPath, check.Path, timeout)
+
+			mu.Lock()
+			results[name] = probeResult{socketPath: socketPath, err: err, checkedAt: time.Now()}
+			mu.Unlock()
+		})
+	}
+
+	wg.Wait()
+
+	// Results of an interrupted round are meaningless
+	if ctx.Err() != nil {
+		return
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	for name, result := range results {
+		prev, seen := hc.results[name]
+
+		switch {
+		case result.err != nil && (!seen || prev.err == nil):
+			slog.Warn("上游健康检查失败", "upstream", name, "socket", result.socketPath, "error", result.err)
+		case result.err == nil && seen && prev.err != nil:
+			slog.Info("上游恢复正常", "upstream", name, "socket", result.socketPath)
+		}
+	}
+
+	hc.results = results
+}
+
+// probeUpstream 探测 socketPath：path 为空时只建立连接，否则发送 GET 请求并检查状态码。
+func probeUpstream(ctx context.Context, socketPath, path string, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+
+	if path == "" {
+		conn, err := dialer.DialContext(ctx, "unix", socketPath)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Status 返回 st 中各上游的健康状态，未启用探测时返回 nil。
+func (hc *healthChecker) Status(st *settings) map[string]upstreamHealth {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if hc.interval <= 0 {
+		return nil
+	}
+
+	statuses := make(map[string]upstreamHealth, len(st.config.Upstreams))
+
+	for name, socketPath := range st.config.Upstreams {
+		status := upstreamHealth{
+			Status:   upstreamUnknown,
+			Required: st.config.HealthCheck.Upstreams[name].Required,
+		}
+
+		if result, ok := hc.results[name]; ok && result.socketPath == socketPath {
+			checkedAt := result.checkedAt
+			status.Status, status.CheckedAt = upstreamUp, &checkedAt
+
+			if result.err != nil {
+				status.Status, status.Error = upstreamDown, result.err.Error()
+			}
+		}
+
+		statuses[name] = status
+	}
+
+	return statuses
+}
+
+// unavailable 返回状态不是 up 的必需上游。
+func unavailable(statuses map[string]upstreamHealth) []string {
+	var names []string
+
+	for name, status := range statuses {
+		if status.Required && status.Status != upstreamUp {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// validateHealthChecks 校验健康检查配置：只能为已配置的上游设置探测，探测路径必须以 "/" 开头。
+func validateHealthChecks(checks map[string]config.UpstreamCheck, upstreams map[string]string) error {
+	for name, check := range checks {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("health check configured for unknown upstream %q", name)
+		}
+
+		if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+			return fmt.Errorf("upstream %q: health check path %q must start with '/'", name, check.Path)
+		}
+	}
+
+	return nil
+}
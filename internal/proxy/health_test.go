@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProbeUpstream 测试单次探测
+func TestProbeUpstream(t *testing.T) {
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+
+	tests := []struct {
+		name    string
+		socket  string
+		path    string
+		healthy bool
+	}{
+		{"只检查连接", socketPath, "", true},
+		{"HTTP 探测", socketPath, "/_ping", true},
+		{"HTTP 探测返回 5xx", socketPath, "/info", false},
+		{"套接字不存在", missing, "", false},
+		{"套接字不存在时 HTTP 探测", missing, "/_ping", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := probeUpstream(context.Background(), tt.socket, tt.path, time.Second)
+			assert.Equal(t, tt.healthy, err == nil, err)
+		})
+	}
+}
+
+// TestValidateHealthChecks 测试健康检查配置校验
+func TestValidateHealthChecks(t *testing.T) {
+	upstreams := map[string]string{"docker": "/var/run/docker.sock"}
+
+	require.NoError(t, validateHealthChecks(map[string]config.UpstreamCheck{"docker": {Path: "/_ping", Required: true}}, upstreams))
+	require.Error(t, validateHealthChecks(map[string]config.UpstreamCheck{"missing": {}}, upstreams))
+	require.Error(t, validateHealthChecks(map[string]config.UpstreamCheck{"docker": {Path: "_ping"}}, upstreams))
+}
+
+// TestServer_HealthChecks 测试 /health、/livez 和 /readyz 报告上游状态
+func TestServer_HealthChecks(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+
+	cfg := &config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams: map[string]string{
+			"app":     socketPath,
+			"missing": filepath.Join(t.TempDir(), "missing.sock"),
+		},
+		HealthCheck: config.HealthCheckConfig{
+			Interval: 3600000,
+			Timeout:  1000,
+			Upstreams: map[string]config.UpstreamCheck{
+				"app":     {Path: "/_ping", Required: true},
+				"missing": {Required: true},
+			},
+		},
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	handler := server.routes()
+
+	get := func(target string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+		return rec.Code, body
+	}
+
+	t.Cleanup(server.health.Close)
+
+	t.Run("尚未探测时未就绪", func(t *testing.T) {
+		// Enable reporting without starting the probe loop
+		server.health.interval = time.Hour
+
+		code, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, []any{"app", "missing"}, body["upstreams"])
+
+		_, body = get("/health")
+		assert.Equal(t, "degraded", body["status"])
+		assert.Equal(t, "unknown", body["upstreams"].(map[string]any)["app"].(map[string]any)["status"])
+	})
+
+	server.health.checkAll(context.Background())
+
+	t.Run("必需的上游不可用", func(t *testing.T) {
+		code, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, []any{"missing"}, body["upstreams"])
+
+		// /health reports the outage; only /readyz gates traffic
+		code, body = get("/health")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "degraded", body["status"])
+
+		upstreams, ok := body["upstreams"].(map[string]any)
+		require.True(t, ok)
+		assert.Equal(t, "up", upstreams["app"].(map[string]any)["status"])
+		assert.Equal(t, "down", upstreams["missing"].(map[string]any)["status"])
+		assert.NotEmpty(t, upstreams["missing"].(map[string]any)["error"])
+
+		code, body = get("/livez")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", body["status"])
+	})
+
+	t.Run("非必需的上游不可用", func(t *testing.T) {
+		next := *cfg
+		next.HealthCheck.Upstreams = map[string]config.UpstreamCheck{"app": {Path: "/_ping", Required: true}}
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+
+		code, body := get("/readyz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ready", body["status"])
+
+		code, body = get("/health")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "degraded", body["status"])
+	})
+
+	t.Run("关闭过程中", func(t *testing.T) {
+		server.draining.Store(true)
+		defer server.draining.Store(false)
+
+		code, _ := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+
+		code, _ = get("/livez")
+		assert.Equal(t, http.StatusOK, code)
+	})
+}
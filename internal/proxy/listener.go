@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/lwmacct/251124-uds-proxy/internal/systemd"
+	"golang.org/x/sys/unix"
+)
+
+// 监听器网络类型。
+const (
+	networkTCP  = "tcp"
+	networkUnix = "unix"
+)
+
+// boundListener 是已完成绑定的监听器。
+type boundListener struct {
+	net.Listener
+
+	network    string
+	socketFile string // 本服务创建的套接字文件，关闭时需要删除；TCP 和抽象套接字为空
+}
+
+// validateListeners 校验监听器配置。
+func validateListeners(listeners []config.ListenerConfig) error {
+	for i, l := range listeners {
+		switch l.Network {
+		case networkTCP, networkUnix:
+		default:
+			return fmt.Errorf("listener %d: unsupported network %q", i, l.Network)
+		}
+
+		if l.Address == "" {
+			return fmt.Errorf("listener %d: empty address", i)
+		}
+
+		hasPerms := l.Mode != "" || l.Owner != "" || l.Group != ""
+		if hasPerms && (l.Network != networkUnix || isAbstractSocket(l.Address)) {
+			return fmt.Errorf("listener %d: mode, owner and group only apply to unix socket files", i)
+		}
+
+		if _, err := parseSocketMode(l.Mode); err != nil {
+			return fmt.Errorf("listener %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// listen 绑定配置的所有监听器。
+// 通过 systemd 套接字激活启动时直接使用传入的监听器，忽略监听器配置；
+// 未配置监听器时监听 Host:Port（Port 为 0 时自动分配）。
+// 实际端口取第一个 TCP 监听器的端口，用于写入端口文件。
+// 任一监听器绑定失败时关闭已绑定的监听器并返回错误。
+func (s *Server) listen() ([]*boundListener, error) {
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("failed to use systemd socket activation: %w", err)
+	}
+
+	if len(activated) > 0 {
+		listeners := make([]*boundListener, 0, len(activated))
+
+		for _, l := range activated {
+			slog.Info("使用 systemd 传入的监听器", "name", l.Name, "addr", l.Addr().String())
+
+			listeners = append(listeners, &boundListener{Listener: l.Listener, network: l.Addr().Network()})
+		}
+
+		s.setActualPort(listeners)
+
+		return listeners, nil
+	}
+
+	specs := s.config.Listeners
+	if len(specs) == 0 {
+		port, err := s.getAvailablePort()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get available port: %w", err)
+		}
+
+		specs = []config.ListenerConfig{{
+			Network: networkTCP,
+			Address: net.JoinHostPort(s.config.Host, strconv.Itoa(port)),
+		}}
+	}
+
+	listeners := make([]*boundListener, 0, len(specs))
+
+	for _, spec := range specs {
+		l, err := listenOne(spec)
+		if err != nil {
+			closeListeners(listeners)
+
+			return nil, fmt.Errorf("failed to listen on %s %s: %w", spec.Network, spec.Address, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	s.setActualPort(listeners)
+
+	return listeners, nil
+}
+
+// setActualPort 将实际端口设置为第一个 TCP 监听器的端口。
+func (s *Server) setActualPort(listeners []*boundListener) {
+	for _, l := range listeners {
+		if tcpAddr, ok := l.Addr().(*net.TCPAddr); ok {
+			s.actualPort = tcpAddr.Port
+
+			return
+		}
+	}
+}
+
+// listenOne 按配置绑定单个监听器。
+func listenOne(spec config.ListenerConfig) (*boundListener, error) {
+	if spec.Network == networkUnix {
+		return listenUnix(spec)
+	}
+
+	lc := net.ListenConfig{}
+
+	l, err := lc.Listen(context.Background(), networkTCP, spec.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boundListener{Listener: l, network: networkTCP}, nil
+}
+
+// listenUnix 绑定 Unix 套接字监听器。
+//
+// 以 "@" 开头的地址表示 Linux 抽象命名空间，不会创建文件。
+// 对于文件套接字，先在同目录下的私有临时目录中绑定并设置权限和属主，
+// 再重命名到目标路径，保证客户端可见时权限已经生效。
+// 目标路径上遗留的套接字文件（无进程监听）会被删除。
+func listenUnix(spec config.ListenerConfig) (*boundListener, error) {
+	lc := net.ListenConfig{}
+
+	if isAbstractSocket(spec.Address) {
+		l, err := lc.Listen(context.Background(), networkUnix, spec.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		return &boundListener{Listener: l, network: networkUnix}, nil
+	}
+
+	if err := removeStaleSocket(spec.Address); err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(spec.Address), ".uds")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	tmpPath := filepath.Join(tmpDir, "s")
+
+	l, err := lc.Listen(context.Background(), networkUnix, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket file is renamed below, so unlinking is handled by Shutdown.
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	if err := applySocketPerms(tmpPath, spec); err != nil {
+		_ = l.Close()
+
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, spec.Address); err != nil {
+		_ = l.Close()
+
+		return nil, err
+	}
+
+	return &boundListener{Listener: l, network: networkUnix, socketFile: spec.Address}, nil
+}
+
+// removeStaleSocket 删除路径上无进程监听的遗留套接字文件。
+// 路径存在但不是套接字，或套接字仍在使用时返回错误。
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	dialer := net.Dialer{Timeout: time.Second}
+
+	conn, err := dialer.DialContext(context.Background(), networkUnix, path)
+	if err == nil {
+		_ = conn.Close()
+
+		return fmt.Errorf("%s is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// applySocketPerms 设置套接字文件的权限和属主。
+func applySocketPerms(path string, spec config.ListenerConfig) error {
+	mode, err := parseSocketMode(spec.Mode)
+	if err != nil {
+		return err
+	}
+
+	if spec.Mode != "" {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if spec.Owner == "" && spec.Group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if spec.Owner != "" {
+		if uid, err = lookupID(spec.Owner, lookupUser); err != nil {
+			return err
+		}
+	}
+
+	if spec.Group != "" {
+		if gid, err = lookupID(spec.Group, lookupGroup); err != nil {
+			return err
+		}
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// parseSocketMode 解析八进制权限字符串，如 "0660"。空字符串返回 0。
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q", mode)
+	}
+
+	return os.FileMode(v), nil
+}
+
+// lookupID 将用户名或组名解析为数字 ID，纯数字直接返回。
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// lookupUser 返回用户名对应的 UID。
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Uid, nil
+}
+
+// lookupGroup 返回组名对应的 GID。
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return g.Gid, nil
+}
+
+// isAbstractSocket 报告地址是否位于 Linux 抽象命名空间。
+func isAbstractSocket(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+// closeListeners 关闭监听器并删除创建的套接字文件。
+func closeListeners(listeners []*boundListener) {
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+
+	removeSocketFiles(listeners)
+}
+
+// removeSocketFiles 删除监听器创建的套接字文件。
+func removeSocketFiles(listeners []*boundListener) {
+	for _, l := range listeners {
+		if l.socketFile != "" {
+			_ = os.Remove(l.socketFile)
+		}
+	}
+}
+
+// peerCredContext 用作 [http.Server.ConnContext]，为 Unix 套接字连接记录对端进程的 uid（SO_PEERCRED）。
+// 这类连接没有客户端地址，限流据此区分客户端。无法取得凭证时不记录。
+func peerCredContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+
+	var (
+		cred    *unix.Ucred
+		credErr error
+	)
+
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerUIDKey, cred.Uid)
+}
+
+// peerUIDFrom 返回 [peerCredContext] 记录的对端 uid，不是 Unix 套接字连接或无法取得凭证时返回 false。
+func peerUIDFrom(ctx context.Context) (uint32, bool) {
+	uid, ok := ctx.Value(peerUIDKey).(uint32)
+
+	return uid, ok
+}
@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateListeners 测试监听器配置校验
+func TestValidateListeners(t *testing.T) {
+	tests := []struct {
+		name     string
+		listener config.ListenerConfig
+		wantErr  bool
+	}{
+		{"TCP", config.ListenerConfig{Network: "tcp", Address: "127.0.0.1:8080"}, false},
+		{"Unix 套接字文件", config.ListenerConfig{Network: "unix", Address: "/run/uds-proxy.sock", Mode: "0660", Group: "docker"}, false},
+		{"抽象命名空间", config.ListenerConfig{Network: "unix", Address: "@uds-proxy"}, false},
+		{"不支持的网络类型", config.ListenerConfig{Network: "udp", Address: "127.0.0.1:8080"}, true},
+		{"空地址", config.ListenerConfig{Network: "tcp"}, true},
+		{"无效权限", config.ListenerConfig{Network: "unix", Address: "/run/uds-proxy.sock", Mode: "rw-rw----"}, true},
+		{"权限超出范围", config.ListenerConfig{Network: "unix", Address: "/run/uds-proxy.sock", Mode: "4777"}, true},
+		{"TCP 不支持权限", config.ListenerConfig{Network: "tcp", Address: "127.0.0.1:8080", Mode: "0660"}, true},
+		{"抽象命名空间不支持属主", config.ListenerConfig{Network: "unix", Address: "@uds-proxy", Owner: "root"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateListeners([]config.ListenerConfig{tt.listener})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestRemoveStaleSocket 测试遗留套接字文件的处理
+func TestRemoveStaleSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "uds")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	t.Run("路径不存在", func(t *testing.T) {
+		assert.NoError(t, removeStaleSocket(filepath.Join(dir, "missing.sock")))
+	})
+
+	t.Run("普通文件", func(t *testing.T) {
+		path := filepath.Join(dir, "file")
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+
+		require.Error(t, removeStaleSocket(path))
+		assert.FileExists(t, path)
+	})
+
+	t.Run("套接字仍在使用", func(t *testing.T) {
+		path := filepath.Join(dir, "busy.sock")
+
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+
+		require.Error(t, removeStaleSocket(path))
+		assert.FileExists(t, path)
+	})
+
+	t.Run("无进程监听的遗留套接字", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, l.Close())
+
+		require.NoError(t, removeStaleSocket(path))
+		assert.NoFileExists(t, path)
+	})
+}
+
+// TestPeerCredContext 测试 Unix 套接字连接记录对端 uid，TCP 连接不记录
+func TestPeerCredContext(t *testing.T) {
+	accept := func(network, address string) net.Conn {
+		var lc net.ListenConfig
+
+		listener, err := lc.Listen(context.Background(), network, address)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = listener.Close() })
+
+		var d net.Dialer
+
+		client, err := d.DialContext(context.Background(), network, listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return conn
+	}
+
+	uid, ok := peerUIDFrom(peerCredContext(context.Background(), accept("unix", filepath.Join(t.TempDir(), "s"))))
+	require.True(t, ok)
+	assert.Equal(t, uint32(os.Getuid()), uid)
+
+	_, ok = peerUIDFrom(peerCredContext(context.Background(), accept("tcp", "127.0.0.1:0")))
+	assert.False(t, ok)
+}
+
+// TestServer_Run_Listeners 测试在多个监听器上提供服务，关闭后删除创建的套接字文件
+func TestServer_Run_Listeners(t *testing.T) {
+	dir, err := os.MkdirTemp("", "uds")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "proxy.sock")
+	abstract := fmt.Sprintf("@uds-proxy-test-%d", time.Now().UnixNano())
+
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		Listeners: []config.ListenerConfig{
+			{Network: "tcp", Address: "127.0.0.1:0"},
+			{
+				Network: "unix",
+				Address: socketPath,
+				Mode:    "0660",
+				Owner:   strconv.Itoa(os.Getuid()),
+				Group:   strconv.Itoa(os.Getgid()),
+			},
+			{Network: "unix", Address: abstract},
+		},
+	})
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+
+	go func() { done <- server.Run() }()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	fi, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+	assert.NotZero(t, fi.Mode()&os.ModeSocket)
+
+	// 临时目录已清理
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	health := func(network, address string) int {
+		client := &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+
+					return d.DialContext(ctx, network, address)
+				},
+			},
+		}
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/health", nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+
+		_ = resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, health("unix", socketPath))
+	assert.Equal(t, http.StatusOK, health("unix", abstract))
+
+	server.mu.Lock()
+	port := server.actualPort
+	server.mu.Unlock()
+
+	assert.Equal(t, http.StatusOK, health("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))))
+
+	server.Shutdown()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+
+	assert.NoFileExists(t, socketPath)
+}
+
+// TestServer_Run_ListenError 测试监听失败时关闭已绑定的监听器
+func TestServer_Run_ListenError(t *testing.T) {
+	dir, err := os.MkdirTemp("", "uds")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "proxy.sock")
+
+	server, err := NewServer(&config.Config{
+		Listeners: []config.ListenerConfig{
+			{Network: "unix", Address: socketPath},
+			{Network: "unix", Address: filepath.Join(dir, "missing", "proxy.sock")},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Error(t, server.Run())
+	assert.NoFileExists(t, socketPath)
+}
+
+// TestServer_Run_MetricsListenError 测试指标服务启动失败时不留下后台监听和探测
+func TestServer_Run_MetricsListenError(t *testing.T) {
+	var lc net.ListenConfig
+
+	occupied, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = occupied.Close() })
+
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "tokens-a"), filepath.Join(dir, "tokens-b")}
+
+	for _, file := range files {
+		require.NoError(t, os.WriteFile(file, []byte("ci:ci-token\n"), 0600))
+	}
+
+	cfg := &config.Config{
+		Host:         "127.0.0.1",
+		WatchSockets: true,
+		Upstreams:    map[string]string{"app": filepath.Join(dir, "app.sock")},
+		HealthCheck:  config.HealthCheckConfig{Interval: 1000, Timeout: 1000},
+		Metrics:      config.MetricsConfig{Enabled: true, Listen: occupied.Addr().String()},
+		Auth:         config.AuthConfig{TokenFile: files[0]},
+	}
+
+	tests := []struct {
+		name   string
+		reload bool
+	}{
+		{"未重新加载", false},
+		{"启动前重新加载", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewServer(cfg)
+			require.NoError(t, err)
+
+			stores := []*TokenStore{server.settings.Load().tokens}
+
+			if tt.reload {
+				next := *cfg
+				next.Auth.TokenFile = files[1]
+				next.HealthCheck.Interval = 500
+
+				_, err := server.Reload(&next)
+				require.NoError(t, err)
+
+				stores = append(stores, server.settings.Load().tokens)
+			}
+
+			require.ErrorContains(t, server.Run(), "metrics server")
+
+			for _, store := range stores {
+				store.mu.Lock()
+				assert.Nil(t, store.watcher)
+				store.mu.Unlock()
+			}
+
+			server.pool.mu.RLock()
+			assert.Nil(t, server.pool.watcher)
+			server.pool.mu.RUnlock()
+
+			server.health.mu.Lock()
+			assert.Nil(t, server.health.stop)
+			server.health.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 上游错误原因，用作 uds_proxy_upstream_errors_total 的 reason 标签。
+const (
+	upstreamErrorDial    = "dial"
+	upstreamErrorTimeout = "timeout"
+	upstreamErrorOther   = "other"
+)
+
+// Metrics 汇总代理服务的 Prometheus 指标。
+//
+// 它使用独立的注册表，避免与进程内其他组件的全局指标冲突。
+// 所有记录方法在接收者为 nil 时什么也不做，未启用指标时无需判空。
+type Metrics struct {
+	registry       *prometheus.Registry
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	upstreamErrors *prometheus.CounterVec
+	requestBytes   *prometheus.CounterVec
+	responseBytes  *prometheus.CounterVec
+	queueRejects   *prometheus.CounterVec
+}
+
+// NewMetrics 创建代理指标并注册连接池状态采集器。
+func NewMetrics(pool *ClientPool) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uds_proxy_requests_total",
+			Help: "Total number of HTTP requests by socket, method and status class.",
+		}, []string{"socket", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "uds_proxy_request_duration_seconds",
+			Help:    "HTTP request latency in seconds by socket and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"socket", "method"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uds_proxy_upstream_errors_total",
+			Help: "Total number of failed upstream round trips by socket and reason (dial, timeout, other).",
+		}, []string{"socket", "reason"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uds_proxy_request_bytes_total",
+			Help: "Total number of bytes received from clients by socket.",
+		}, []string{"socket"}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uds_proxy_response_bytes_total",
+			Help: "Total number of bytes sent to clients by socket.",
+		}, []string{"socket"}),
+		queueRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uds_proxy_queue_rejections_total",
+			Help: "Total number of requests rejected by the per-socket concurrency limit by socket and reason (full, timeout).",
+		}, []string{"socket", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.upstreamErrors,
+		m.requestBytes,
+		m.responseBytes,
+		m.queueRejects,
+		newPoolCollector(pool),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler 返回以 Prometheus 文本格式暴露指标的 HTTP 处理器。
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeRequest 记录一次已完成的请求。
+func (m *Metrics) observeRequest(socket, method string, status int, duration time.Duration, bytesIn, bytesOut int64) {
+	if m == nil {
+		return
+	}
+
+	method = normalizeMethod(method)
+
+	m.requests.WithLabelValues(socket, method, statusClass(status)).Inc()
+	m.duration.WithLabelValues(socket, method).Observe(duration.Seconds())
+	m.requestBytes.WithLabelValues(socket).Add(float64(bytesIn))
+	m.responseBytes.WithLabelValues(socket).Add(float64(bytesOut))
+}
+
+// upstreamError 记录一次失败的上游请求，原因由错误类型推断。
+func (m *Metrics) upstreamError(socket string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.upstreamErrors.WithLabelValues(socket, upstreamErrorReason(err)).Inc()
+}
+
+// queueRejected 记录一次被并发限制拒绝的请求。客户端在排队时断开不计入。
+func (m *Metrics) queueRejected(socket, reason string) {
+	if m == nil || reason == queueRejectCanceled {
+		return
+	}
+
+	m.queueRejects.WithLabelValues(socket, reason).Inc()
+}
+
+// observeQueues 注册并发限制器的状态采集器。
+func (m *Metrics) observeQueues(cl *concurrencyLimiter) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(newQueueCollector(cl))
+}
+
+// upstreamErrorReason 将上游错误归类为拨号失败、超时或其他错误。
+func upstreamErrorReason(err error) string {
+	if os.IsTimeout(err) {
+		return upstreamErrorTimeout
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return upstreamErrorDial
+	}
+
+	return upstreamErrorOther
+}
+
+// statusClass 返回状态码所属的类别，如 "2xx"。
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// normalizeMethod 将非标准 HTTP 方法归并为 "OTHER"，限制指标标签基数。
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// poolCollector 在每次采集时读取连接池状态。
+type poolCollector struct {
+	pool        *ClientPool
+	clients     *prometheus.Desc
+	connections *prometheus.Desc
+	evictions   *prometheus.Desc
+}
+
+// newPoolCollector 创建连接池状态采集器。
+func newPoolCollector(pool *ClientPool) *poolCollector {
+	return &poolCollector{
+		pool: pool,
+		clients: prometheus.NewDesc(
+			"uds_proxy_pool_clients",
+			"Number of HTTP clients currently held by the pool.",
+			nil, nil,
+		),
+		connections: prometheus.NewDesc(
+			"uds_proxy_pool_connections",
+			"Number of upstream connections per socket by state (active, idle).",
+			[]string{"socket", "state"}, nil,
+		),
+		evictions: prometheus.NewDesc(
+			"uds_proxy_pool_evictions_total",
+			"Total number of HTTP clients evicted from the pool by reason (lru, idle).",
+			[]string{"reason"}, nil,
+		),
+	}
+}
+
+// Describe 实现 [prometheus.Collector]。
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clients
+	ch <- c.connections
+	ch <- c.evictions
+}
+
+// Collect 实现 [prometheus.Collector]。
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.clients, prometheus.GaugeValue, float64(len(stats)))
+
+	for _, st := range stats {
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(st.Active), st.Name, "active")
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(st.Idle), st.Name, "idle")
+	}
+
+	evictions := c.pool.Evictions()
+
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(evictions.LRU), "lru")
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(evictions.Idle), "idle")
+}
+
+// queueCollector 在每次采集时读取各上游名称的并发数和排队数，标签与请求指标的 socket 标签一致。
+type queueCollector struct {
+	limiter  *concurrencyLimiter
+	inFlight *prometheus.Desc
+	queued   *prometheus.Desc
+}
+
+// newQueueCollector 创建并发状态采集器。
+func newQueueCollector(cl *concurrencyLimiter) *queueCollector {
+	return &queueCollector{
+		limiter: cl,
+		inFlight: prometheus.NewDesc(
+			"uds_proxy_in_flight_requests",
+			"Number of requests currently forwarded per socket under the concurrency limit.",
+			[]string{"socket"}, nil,
+		),
+		queued: prometheus.NewDesc(
+			"uds_proxy_queue_depth",
+			"Number of requests waiting for a concurrency slot per socket.",
+			[]string{"socket"}, nil,
+		),
+	}
+}
+
+// Describe 实现 [prometheus.Collector]。
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inFlight
+	ch <- c.queued
+}
+
+// Collect 实现 [prometheus.Collector]。
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, l := range c.limiter.loads() {
+		ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(l.inFlight), l.name)
+		ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, float64(l.queued), l.name)
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape 请求指标处理器并返回文本格式的指标。
+func scrape(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	return rec.Body.String()
+}
+
+// TestMetrics_observeRequest 测试请求指标记录
+func TestMetrics_observeRequest(t *testing.T) {
+	metrics := NewMetrics(NewClientPool(10, 5, time.Second))
+
+	metrics.observeRequest("docker", http.MethodGet, http.StatusOK, 50*time.Millisecond, 10, 200)
+	metrics.observeRequest("docker", "BREW", http.StatusBadGateway, time.Millisecond, 0, 0)
+	metrics.upstreamError("docker", os.ErrDeadlineExceeded)
+
+	body := scrape(t, metrics.Handler())
+
+	assert.Contains(t, body, `uds_proxy_requests_total{code="2xx",method="GET",socket="docker"} 1`)
+	assert.Contains(t, body, `uds_proxy_requests_total{code="5xx",method="OTHER",socket="docker"} 1`)
+	assert.Contains(t, body, `uds_proxy_request_duration_seconds_count{method="GET",socket="docker"} 1`)
+	assert.Contains(t, body, `uds_proxy_request_bytes_total{socket="docker"} 10`)
+	assert.Contains(t, body, `uds_proxy_response_bytes_total{socket="docker"} 200`)
+	assert.Contains(t, body, `uds_proxy_upstream_errors_total{reason="timeout",socket="docker"} 1`)
+	assert.Contains(t, body, `uds_proxy_pool_clients 0`)
+	assert.Contains(t, body, `uds_proxy_pool_evictions_total{reason="lru"} 0`)
+}
+
+// TestMetrics_Nil 测试未启用指标时记录方法不会 panic
+func TestMetrics_Nil(t *testing.T) {
+	var metrics *Metrics
+
+	assert.NotPanics(t, func() {
+		metrics.observeRequest("docker", http.MethodGet, http.StatusOK, time.Millisecond, 0, 0)
+		metrics.upstreamError("docker", errors.New("boom"))
+		metrics.queueRejected("docker", queueRejectFull)
+		metrics.observeQueues(newConcurrencyLimiter(config.ConcurrencyConfig{}))
+	})
+}
+
+// TestUpstreamErrorReason 测试上游错误分类
+func TestUpstreamErrorReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"超时", os.ErrDeadlineExceeded, upstreamErrorTimeout},
+		{"拨号失败", &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connection refused")}, upstreamErrorDial},
+		{"读取失败", &net.OpError{Op: "read", Net: "unix", Err: errors.New("connection reset")}, upstreamErrorOther},
+		{"其他错误", errors.New("boom"), upstreamErrorOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, upstreamErrorReason(tt.err))
+		})
+	}
+}
+
+// TestStatusClass 测试状态码分类
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "1xx", statusClass(http.StatusSwitchingProtocols))
+	assert.Equal(t, "2xx", statusClass(http.StatusOK))
+	assert.Equal(t, "4xx", statusClass(http.StatusForbidden))
+	assert.Equal(t, "5xx", statusClass(http.StatusGatewayTimeout))
+	assert.Equal(t, "unknown", statusClass(0))
+}
+
+// TestClientPool_Stats 测试连接池的活跃和空闲连接统计
+func TestClientPool_Stats(t *testing.T) {
+	release := make(chan struct{})
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+
+	pool := NewClientPool(10, 5, time.Second)
+	client := pool.GetNamedClient("app", socketPath)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, []PoolStats{{Name: "app", SocketPath: socketPath, Active: 1, Idle: 0}}, pool.Stats())
+
+	close(release)
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, []PoolStats{{Name: "app", SocketPath: socketPath, Active: 0, Idle: 1}}, pool.Stats())
+
+	pool.CloseAll()
+	assert.Empty(t, pool.Stats())
+}
+
+// TestServer_Metrics 测试指标端点的注册和数据采集
+func TestServer_Metrics(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+
+	t.Run("与代理共用监听地址", func(t *testing.T) {
+		server, err := NewServer(&config.Config{
+			Timeout:     1000,
+			NoAccessLog: true,
+			Upstreams:   map[string]string{"app": socketPath},
+			Metrics:     config.MetricsConfig{Enabled: true, Path: "/metrics"},
+			Auth:        config.AuthConfig{Tokens: map[string]string{"ops": "secret"}},
+		})
+		require.NoError(t, err)
+
+		handler := server.accessLogMiddleware(server.authMiddleware(server.routes()))
+
+		// 代理端口上的指标端点要求认证
+		authenticated := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-API-Key", "secret")
+			handler.ServeHTTP(w, r)
+		})
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+		authenticated.ServeHTTP(httptest.NewRecorder(), req)
+
+		body := scrape(t, authenticated)
+
+		assert.Contains(t, body, `uds_proxy_requests_total{code="2xx",method="GET",socket="app"} 1`)
+		assert.Contains(t, body, `uds_proxy_pool_connections{socket="app",state="idle"} 1`)
+		assert.Contains(t, body, `uds_proxy_pool_clients 1`)
+	})
+
+	t.Run("记录上游拨号错误", func(t *testing.T) {
+		deadSocket := newDeadSocket(t)
+
+		server, err := NewServer(&config.Config{
+			Timeout:   1000,
+			Upstreams: map[string]string{"dead": deadSocket},
+			Metrics:   config.MetricsConfig{Enabled: true},
+		})
+		require.NoError(t, err)
+
+		handler := server.accessLogMiddleware(server.routes())
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/u/dead/info", nil))
+		require.Equal(t, http.StatusBadGateway, rec.Code)
+
+		body := scrape(t, server.metrics.Handler())
+
+		assert.Contains(t, body, `uds_proxy_upstream_errors_total{reason="dial",socket="dead"} 1`)
+		assert.Contains(t, body, `uds_proxy_requests_total{code="5xx",method="GET",socket="dead"} 1`)
+	})
+
+	t.Run("未启用时不注册端点", func(t *testing.T) {
+		server, err := NewServer(&config.Config{})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("独立监听地址", func(t *testing.T) {
+		server, err := NewServer(&config.Config{
+			Metrics: config.MetricsConfig{Enabled: true, Listen: "127.0.0.1:0", Path: "/stats"},
+		})
+		require.NoError(t, err)
+
+		// 代理路由中不包含指标端点
+		rec := httptest.NewRecorder()
+		server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		require.NoError(t, server.startMetricsServer())
+		t.Cleanup(server.Shutdown)
+
+		rec = httptest.NewRecorder()
+		server.metricsServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "uds_proxy_pool_clients")
+
+		// /admin 端点同样在独立地址上提供，无需认证
+		for _, path := range []string{"/admin/breakers", "/admin/queues"} {
+			rec = httptest.NewRecorder()
+			server.metricsServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, http.StatusOK, rec.Code, path)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SocketPolicy 定义允许代理访问的 Unix 套接字范围。
+//
+// 允许列表和拒绝列表中的每一项既可以是精确路径，也可以是 [filepath.Match]
+// 支持的 glob 模式。拒绝列表优先于允许列表；允许列表为空时，
+// 所有未被拒绝的套接字都允许访问。
+//
+// 请求路径在匹配前会被规范化（解析符号链接、`..` 和重复斜杠），
+// 因此无法通过构造等价路径绕过策略。
+//
+// 此类型创建后只读，支持多个 goroutine 并发安全使用。
+type SocketPolicy struct {
+	allow []string
+	deny  []string
+}
+
+// NewSocketPolicy 使用指定的允许列表和拒绝列表创建套接字访问策略。
+// 列表中的路径和模式会按请求路径相同的规则规范化。
+// 如果某个模式不是合法的 glob 模式，则返回错误。
+func NewSocketPolicy(allow, deny []string) (*SocketPolicy, error) {
+	p := &SocketPolicy{}
+
+	var err error
+
+	if p.allow, err = canonicalPatterns(allow); err != nil {
+		return nil, fmt.Errorf("invalid allowed socket pattern: %w", err)
+	}
+
+	if p.deny, err = canonicalPatterns(deny); err != nil {
+		return nil, fmt.Errorf("invalid denied socket pattern: %w", err)
+	}
+
+	return p, nil
+}
+
+// Check 规范化 socketPath 并判断是否允许访问。
+// 返回规范化后的路径，调用方应使用该路径建立连接，
+// 避免检查和连接之间路径含义发生变化。
+func (p *SocketPolicy) Check(socketPath string) (string, bool) {
+	resolved, err := canonicalSocketPath(socketPath)
+	if err != nil {
+		return "", false
+	}
+
+	if matchAny(p.deny, resolved) {
+		return resolved, false
+	}
+
+	if len(p.allow) == 0 {
+		return resolved, true
+	}
+
+	return resolved, matchAny(p.allow, resolved)
+}
+
+// matchAny 报告 path 是否匹配 patterns 中的任意一项。
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canonicalSocketPath 返回套接字路径的规范形式：
+// 绝对路径、清除 `..` 和重复斜杠，并尽可能解析符号链接。
+// 如果文件本身不存在，则只解析其所在目录，以便缺失的套接字仍能按真实位置匹配。
+func canonicalSocketPath(socketPath string) (string, error) {
+	abs, err := filepath.Abs(socketPath)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+
+	dir, base := filepath.Split(abs)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return filepath.Join(resolved, base), nil
+	}
+
+	return abs, nil
+}
+
+// canonicalPatterns 规范化并校验一组路径模式。
+func canonicalPatterns(patterns []string) ([]string, error) {
+	out := make([]string, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%q: %w", pattern, err)
+		}
+
+		canonical, err := canonicalPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", pattern, err)
+		}
+
+		out = append(out, canonical)
+	}
+
+	return out, nil
+}
+
+// canonicalPattern 规范化单个路径模式。
+// 不含通配符的部分（最长的静态目录前缀）会解析符号链接，
+// 使 /var/run/*.sock 这类模式能够匹配规范化后的 /run/xxx.sock。
+func canonicalPattern(pattern string) (string, error) {
+	abs, err := filepath.Abs(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	if !hasMeta(abs) {
+		return canonicalSocketPath(abs)
+	}
+
+	// Split into the static directory prefix and the part containing wildcards
+	prefix, rest := abs, ""
+	for hasMeta(prefix) {
+		rest = filepath.Join(filepath.Base(prefix), rest)
+		prefix = filepath.Dir(prefix)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(prefix); err == nil {
+		prefix = resolved
+	}
+
+	return filepath.Join(prefix, rest), nil
+}
+
+// hasMeta 报告路径是否包含 glob 元字符。
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewSocketPolicy 测试创建套接字访问策略
+func TestNewSocketPolicy(t *testing.T) {
+	t.Run("合法模式", func(t *testing.T) {
+		policy, err := NewSocketPolicy([]string{"/var/run/docker.sock", "/run/app/*.sock"}, nil)
+
+		require.NoError(t, err)
+		assert.NotNil(t, policy)
+	})
+
+	t.Run("非法模式返回错误", func(t *testing.T) {
+		_, err := NewSocketPolicy([]string{"/run/[.sock"}, nil)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("非法拒绝模式返回错误", func(t *testing.T) {
+		_, err := NewSocketPolicy(nil, []string{"/run/[.sock"})
+
+		assert.Error(t, err)
+	})
+}
+
+// TestSocketPolicy_Check 测试套接字访问策略匹配
+func TestSocketPolicy_Check(t *testing.T) {
+	dir := t.TempDir()
+	realDir, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	// 目录结构：
+	//   allowed/api.sock
+	//   secret/private.sock
+	//   link -> secret/private.sock
+	require.NoError(t, os.MkdirAll(filepath.Join(realDir, "allowed"), 0750))
+	require.NoError(t, os.MkdirAll(filepath.Join(realDir, "secret"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(realDir, "allowed", "api.sock"), nil, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(realDir, "secret", "private.sock"), nil, 0600))
+	require.NoError(t, os.Symlink(filepath.Join(realDir, "secret", "private.sock"), filepath.Join(realDir, "link")))
+
+	policy, err := NewSocketPolicy(
+		[]string{filepath.Join(realDir, "allowed", "*.sock")},
+		[]string{filepath.Join(realDir, "allowed", "denied.sock")},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		path     string
+		allowed  bool
+		resolved string
+	}{
+		{
+			name:     "glob 匹配",
+			path:     filepath.Join(realDir, "allowed", "api.sock"),
+			allowed:  true,
+			resolved: filepath.Join(realDir, "allowed", "api.sock"),
+		},
+		{
+			name:     "重复斜杠被规范化",
+			path:     realDir + "//allowed///api.sock",
+			allowed:  true,
+			resolved: filepath.Join(realDir, "allowed", "api.sock"),
+		},
+		{
+			name:     "上级目录穿越被规范化后拒绝",
+			path:     filepath.Join(realDir, "allowed") + "/../secret/private.sock",
+			allowed:  false,
+			resolved: filepath.Join(realDir, "secret", "private.sock"),
+		},
+		{
+			name:     "符号链接解析为真实路径后拒绝",
+			path:     filepath.Join(realDir, "link"),
+			allowed:  false,
+			resolved: filepath.Join(realDir, "secret", "private.sock"),
+		},
+		{
+			name:     "拒绝列表优先于允许列表",
+			path:     filepath.Join(realDir, "allowed", "denied.sock"),
+			allowed:  false,
+			resolved: filepath.Join(realDir, "allowed", "denied.sock"),
+		},
+		{
+			name:     "不在允许列表中",
+			path:     "/var/run/docker.sock",
+			allowed:  false,
+			resolved: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, allowed := policy.Check(tt.path)
+
+			assert.Equal(t, tt.allowed, allowed)
+
+			if tt.resolved != "" {
+				assert.Equal(t, tt.resolved, resolved)
+			}
+		})
+	}
+}
+
+// TestSocketPolicy_Check_EmptyAllowList 测试允许列表为空时的行为
+func TestSocketPolicy_Check_EmptyAllowList(t *testing.T) {
+	policy, err := NewSocketPolicy(nil, []string{"/run/systemd/*"})
+	require.NoError(t, err)
+
+	t.Run("未被拒绝的路径允许访问", func(t *testing.T) {
+		_, allowed := policy.Check("/nonexistent/app.sock")
+
+		assert.True(t, allowed)
+	})
+
+	t.Run("被拒绝的路径禁止访问", func(t *testing.T) {
+		_, allowed := policy.Check("/run/systemd/private")
+
+		assert.False(t, allowed)
+	})
+}
+
+// TestCanonicalPattern 测试模式的静态前缀会解析符号链接
+func TestCanonicalPattern(t *testing.T) {
+	dir := t.TempDir()
+	realDir, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(realDir, "real"), 0750))
+	require.NoError(t, os.Symlink(filepath.Join(realDir, "real"), filepath.Join(realDir, "alias")))
+
+	pattern, err := canonicalPattern(filepath.Join(realDir, "alias", "*.sock"))
+
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(realDir, "real", "*.sock"), pattern)
+}
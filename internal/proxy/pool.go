@@ -2,25 +2,135 @@ package proxy
 
 import (
 	"context"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 // ClientPool 管理针对不同 Unix 域套接字的 HTTP 客户端池。
 // 它提供线程安全的客户端管理，支持自动创建客户端和连接复用以提高性能。
 //
-// 每个 Unix 套接字路径都有独立的 HTTP 客户端，配置了专用的传输层用于
-// Unix 套接字通信。客户端在首次访问时延迟创建，并缓存供后续请求使用。
+// 池中的每个客户端以名称为键，名称可以是套接字路径本身，也可以是配置的上游别名。
+// 每个客户端配置了专用的传输层用于 Unix 套接字通信，在首次访问时延迟创建，
+// 并缓存供后续请求使用。调用 [ClientPool.SetEviction] 后，池中的客户端数量受到限制，
+// 长时间未使用的客户端会被后台清理。
+//
+// 后端重启时套接字文件会被删除并重新创建，缓存的空闲连接随之失效。
+// 池记录每个套接字文件的设备号和 inode，发现变化时关闭该客户端的空闲连接，
+// 使重启后的第一个请求建立新连接而不是失败。默认在每次取用客户端时检查，
+// 调用 [ClientPool.WatchSockets] 后改为通过 inotify 监听套接字所在目录。
 //
 // 此类型支持多个 goroutine 并发安全使用。
 type ClientPool struct {
-	clients      map[string]*http.Client
+	clients      map[string]*poolEntry
 	mu           sync.RWMutex
 	maxConns     int
 	maxIdleConns int
 	timeout      time.Duration
+
+	maxClients  int
+	idleTTL     time.Duration
+	stopJanitor chan struct{} // 关闭时停止后台清理，未启动时为 nil
+	evictedLRU  atomic.Uint64
+	evictedIdle atomic.Uint64
+
+	watcher     *fsnotify.Watcher // 未监听时为 nil
+	watchedDirs map[string]bool
+	watching    atomic.Bool
+}
+
+// poolEntry 是池中的一个客户端及其连接状态。
+type poolEntry struct {
+	client     *http.Client
+	socketPath string
+	open       atomic.Int64 // 当前已建立的连接数
+	active     atomic.Int64 // 正在进行的请求数
+	lastUsed   atomic.Int64 // 最近一次被取用的时间（Unix 纳秒）
+	evicted    atomic.Bool  // 已被移出池，最后一个请求结束后关闭连接
+
+	fileMu sync.Mutex
+	file   socketFile // 上次检查时套接字文件的标识
+}
+
+// socketFile 标识一个套接字文件，文件被删除并重新创建后设备号或 inode 会变化。
+type socketFile struct {
+	dev, ino uint64
+}
+
+// statSocketFile 返回套接字文件的标识，文件不存在时返回 false。
+func statSocketFile(path string) (socketFile, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return socketFile{}, false
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return socketFile{}, false
+	}
+
+	return socketFile{dev: uint64(st.Dev), ino: st.Ino}, true //nolint:unconvert // Dev is not uint64 on every platform
+}
+
+// refresh 检查套接字文件是否被重新创建或删除，是则关闭空闲连接，
+// 使后续请求建立到新套接字的连接，而不是复用已失效的连接。
+func (e *poolEntry) refresh() {
+	file, _ := statSocketFile(e.socketPath)
+
+	e.fileMu.Lock()
+	prev := e.file
+	e.file = file
+	e.fileMu.Unlock()
+
+	if prev == file {
+		return
+	}
+
+	e.client.CloseIdleConnections()
+
+	if prev != (socketFile{}) {
+		slog.Info("套接字文件已变化，关闭空闲连接", "socket", e.socketPath)
+	}
+}
+
+// evict 标记客户端已被移出池并关闭其空闲连接。
+// 仍在进行的请求完成后，由 [poolEntry.finish] 关闭其连接，
+// 避免之后复用该客户端的请求留下的空闲连接一直保持到 IdleConnTimeout。
+func (e *poolEntry) evict() {
+	e.evicted.Store(true)
+	e.client.CloseIdleConnections()
+}
+
+// finish 结束一个请求的计数。客户端已被移出池时，最后一个请求结束后关闭空闲连接。
+func (e *poolEntry) finish() {
+	if e.active.Add(-1) == 0 && e.evicted.Load() {
+		e.client.CloseIdleConnections()
+	}
+}
+
+// EvictionStats 描述被移出池的客户端数量。
+type EvictionStats struct {
+	LRU  uint64 // 超出数量上限时被移除的最久未使用的客户端
+	Idle uint64 // 空闲超过存活时间被清理的客户端
+}
+
+// PoolStats 描述池中单个客户端的连接状态。
+type PoolStats struct {
+	Name       string // 客户端名称：上游别名或套接字路径
+	SocketPath string // 连接的套接字路径
+	Active     int    // 正在处理请求的连接数
+	Idle       int    // 空闲的保活连接数
 }
 
 // NewClientPool 创建一个新的客户端池，使用指定的连接限制和超时设置。
@@ -28,12 +138,14 @@ type ClientPool struct {
 // 参数：
 //   - maxConns: 每个 Unix 套接字的最大总连接数
 //   - maxIdleConns: 每个 Unix 套接字的最大空闲（保活）连接数
-//   - timeout: 池中所有客户端的请求超时时间
+//   - timeout: 连接套接字和等待响应头的超时时间
+//
+// 超时不限制响应体的传输时间，因此 Docker events、logs?follow=1 等无限流不会被截断。
 //
 // 返回的池可以立即使用。
 func NewClientPool(maxConns, maxIdleConns int, timeout time.Duration) *ClientPool {
 	return &ClientPool{
-		clients:      make(map[string]*http.Client),
+		clients:      make(map[string]*poolEntry),
 		maxConns:     maxConns,
 		maxIdleConns: maxIdleConns,
 		timeout:      timeout,
@@ -41,73 +153,410 @@ func NewClientPool(maxConns, maxIdleConns int, timeout time.Duration) *ClientPoo
 }
 
 // GetClient 返回针对指定 Unix 套接字路径配置的 HTTP 客户端。
-// 如果该路径的客户端已存在，则从缓存中返回。
-// 否则，创建一个新客户端，配置专用传输层用于 Unix 套接字通信。
+// 它等价于以套接字路径作为名称调用 [ClientPool.GetNamedClient]。
+func (p *ClientPool) GetClient(socketPath string) *http.Client {
+	return p.GetNamedClient(socketPath, socketPath)
+}
+
+// GetNamedClient 返回名为 name、连接到 socketPath 的 HTTP 客户端。
+// 如果该名称的客户端已存在且指向相同的套接字，则从缓存中返回。
+// 否则，创建一个新客户端，配置专用传输层用于 Unix 套接字通信；
+// 名称改为指向其他套接字时，旧客户端的空闲连接会被关闭。
 //
 // 此方法使用双重检查锁定来最小化锁竞争，同时确保线程安全。
 // 返回的客户端可以并发使用。
-func (p *ClientPool) GetClient(socketPath string) *http.Client {
+func (p *ClientPool) GetNamedClient(name, socketPath string) *http.Client {
 	p.mu.RLock()
-	client, exists := p.clients[socketPath]
+	entry, exists := p.clients[name]
 	p.mu.RUnlock()
 
-	if exists {
-		return client
+	if exists && entry.socketPath == socketPath {
+		entry.lastUsed.Store(time.Now().UnixNano())
+
+		if !p.watching.Load() {
+			entry.refresh()
+		}
+
+		return entry.client
 	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	// Double-check after acquiring write lock
-	if client, exists = p.clients[socketPath]; exists {
-		return client
+	if entry, exists = p.clients[name]; exists {
+		if entry.socketPath == socketPath {
+			entry.lastUsed.Store(time.Now().UnixNano())
+
+			if !p.watching.Load() {
+				entry.refresh()
+			}
+
+			return entry.client
+		}
+
+		entry.evict()
+	} else if p.maxClients > 0 && len(p.clients) >= p.maxClients {
+		p.evictOldest()
 	}
 
+	entry = &poolEntry{socketPath: socketPath}
+	entry.lastUsed.Store(time.Now().UnixNano())
+	entry.file, _ = statSocketFile(socketPath)
+	p.watchDirLocked(filepath.Dir(socketPath))
+	timeout := p.timeout
+
 	// Create new client with Unix socket transport
 	transport := &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			dialer := net.Dialer{Timeout: p.timeout}
+			dialer := net.Dialer{Timeout: timeout}
+
+			conn, err := dialer.DialContext(ctx, "unix", socketPath)
+			if err != nil {
+				return nil, err
+			}
+
+			entry.open.Add(1)
 
-			return dialer.DialContext(ctx, "unix", socketPath)
+			return &trackedConn{Conn: conn, open: &entry.open}, nil
 		},
-		MaxConnsPerHost:     p.maxConns,
-		MaxIdleConnsPerHost: p.maxIdleConns,
-		IdleConnTimeout:     90 * time.Second,
+		MaxConnsPerHost:       p.maxConns,
+		MaxIdleConnsPerHost:   p.maxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: timeout,
+	}
+
+	entry.client = &http.Client{
+		Transport: &trackingTransport{base: transport, entry: entry},
+	}
+
+	p.clients[name] = entry
+
+	return entry.client
+}
+
+// Configure 更新连接限制和超时设置，参数含义与 [NewClientPool] 相同。
+//
+// 设置发生变化时，池中现有的客户端会被移除并关闭空闲连接，之后的请求使用按新设置创建的客户端；
+// 正在进行的请求继续使用原来的客户端直到完成。设置未变化时什么也不做。
+func (p *ClientPool) Configure(maxConns, maxIdleConns int, timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.maxConns == maxConns && p.maxIdleConns == maxIdleConns && p.timeout == timeout {
+		return
 	}
 
-	client = &http.Client{
-		Transport: transport,
-		Timeout:   p.timeout,
+	p.maxConns, p.maxIdleConns, p.timeout = maxConns, maxIdleConns, timeout
+
+	for _, entry := range p.clients {
+		entry.evict()
+	}
+
+	p.clients = make(map[string]*poolEntry)
+}
+
+// SetEviction 设置池中客户端的数量上限和空闲存活时间。
+//
+// maxClients 大于 0 时，创建新客户端前若已达到上限，则移除最久未使用的客户端；
+// 当前数量超过新上限时立即移除多出的部分。idleTTL 大于 0 时启动后台清理，
+// 每隔 idleTTL 的一半移除没有进行中请求、且超过 idleTTL 未被使用的客户端，
+// 直到再次调用 SetEviction 或调用 [ClientPool.CloseAll]。两者为 0 时不限制。
+//
+// 被移除客户端上正在进行的请求不受影响，最后一个请求完成后其连接随即关闭。
+func (p *ClientPool) SetEviction(maxClients int, idleTTL time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxClients, p.idleTTL = maxClients, idleTTL
+
+	for maxClients > 0 && len(p.clients) > maxClients {
+		p.evictOldest()
 	}
 
-	p.clients[socketPath] = client
+	p.stopJanitorLocked()
+
+	if idleTTL > 0 {
+		stop := make(chan struct{})
+		p.stopJanitor = stop
 
-	return client
+		go p.janitor(idleTTL, stop)
+	}
 }
 
-// RemoveClient 移除并关闭指定套接字路径的 HTTP 客户端。
+// WatchSockets 开始通过 inotify 监听池中套接字所在的目录。
+//
+// 套接字文件被创建、删除或替换时立即关闭对应客户端的空闲连接，
+// 取用客户端时不再逐次检查文件。监听一直持续到调用 [ClientPool.CloseAll]，
+// 之后恢复为逐次检查。已在监听时什么也不做。
+func (p *ClientPool) WatchSockets() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.watcher != nil {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	p.watcher = watcher
+	p.watchedDirs = make(map[string]bool)
+
+	for _, entry := range p.clients {
+		p.watchDirLocked(filepath.Dir(entry.socketPath))
+		entry.refresh()
+	}
+
+	p.watching.Store(true)
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Op != fsnotify.Chmod {
+					p.socketChanged(filepath.Clean(event.Name))
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				// Events may have been lost; fall back to checking on every use
+				slog.Warn("套接字目录监听出错", "error", err)
+				p.watching.Store(false)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchDirLocked 在监听套接字时将 dir 加入监听，调用方必须持有写锁。
+func (p *ClientPool) watchDirLocked(dir string) {
+	if p.watcher == nil || p.watchedDirs[dir] {
+		return
+	}
+
+	if err := p.watcher.Add(dir); err != nil {
+		// Without a watch on this directory changes would go unnoticed
+		slog.Warn("无法监听套接字目录，改为每次使用时检查", "dir", dir, "error", err)
+		p.watching.Store(false)
+
+		return
+	}
+
+	p.watchedDirs[dir] = true
+}
+
+// socketChanged 处理套接字文件的变更事件。
+func (p *ClientPool) socketChanged(path string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, entry := range p.clients {
+		if entry.socketPath == path {
+			entry.refresh()
+		}
+	}
+}
+
+// stopWatchLocked 停止监听套接字目录，调用方必须持有写锁。
+func (p *ClientPool) stopWatchLocked() {
+	if p.watcher == nil {
+		return
+	}
+
+	p.watching.Store(false)
+	_ = p.watcher.Close()
+	p.watcher = nil
+	p.watchedDirs = nil
+}
+
+// Evictions 返回累计被移出池的客户端数量。
+func (p *ClientPool) Evictions() EvictionStats {
+	return EvictionStats{
+		LRU:  p.evictedLRU.Load(),
+		Idle: p.evictedIdle.Load(),
+	}
+}
+
+// janitor 定期清理空闲超过 ttl 的客户端，直到 stop 被关闭。
+func (p *ClientPool) janitor(ttl time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(max(ttl/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			p.evictIdle(now.Add(-ttl))
+		}
+	}
+}
+
+// evictIdle 移除没有进行中请求、且在 before 之后未被使用的客户端。
+func (p *ClientPool) evictIdle(before time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, entry := range p.clients {
+		if entry.active.Load() == 0 && entry.lastUsed.Load() < before.UnixNano() {
+			entry.evict()
+			delete(p.clients, name)
+			p.evictedIdle.Add(1)
+		}
+	}
+}
+
+// evictOldest 移除最久未使用的客户端，调用方必须持有写锁。
+//
+// 使用时间记录在各客户端上，取用客户端时只需读锁；
+// 代价是淘汰时需要遍历，而淘汰只发生在创建新客户端时。
+func (p *ClientPool) evictOldest() {
+	var (
+		oldestName string
+		oldest     *poolEntry
+	)
+
+	for name, entry := range p.clients {
+		if oldest == nil || entry.lastUsed.Load() < oldest.lastUsed.Load() {
+			oldestName, oldest = name, entry
+		}
+	}
+
+	if oldest == nil {
+		return
+	}
+
+	oldest.evict()
+	delete(p.clients, oldestName)
+	p.evictedLRU.Add(1)
+}
+
+// stopJanitorLocked 停止后台清理，调用方必须持有写锁。
+func (p *ClientPool) stopJanitorLocked() {
+	if p.stopJanitor != nil {
+		close(p.stopJanitor)
+		p.stopJanitor = nil
+	}
+}
+
+// RemoveClient 移除并关闭指定名称的 HTTP 客户端。
 // 当发生连接错误时应调用此方法，以便在下次请求时强制创建新客户端。
 // 所有空闲连接都会被关闭。
-func (p *ClientPool) RemoveClient(socketPath string) {
+func (p *ClientPool) RemoveClient(name string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if client, exists := p.clients[socketPath]; exists {
-		client.CloseIdleConnections()
-		delete(p.clients, socketPath)
+	if entry, exists := p.clients[name]; exists {
+		entry.evict()
+		delete(p.clients, name)
+	}
+}
+
+// Stats 返回池中每个客户端的连接状态，按名称排序。
+// 空闲连接数由已建立的连接数减去正在进行的请求数得出。
+func (p *ClientPool) Stats() []PoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	stats := make([]PoolStats, 0, len(p.clients))
+
+	for name, entry := range p.clients {
+		active := int(entry.active.Load())
+		idle := max(int(entry.open.Load())-active, 0)
+
+		stats = append(stats, PoolStats{
+			Name:       name,
+			SocketPath: entry.socketPath,
+			Active:     active,
+			Idle:       idle,
+		})
 	}
+
+	slices.SortFunc(stats, func(a, b PoolStats) int { return strings.Compare(a.Name, b.Name) })
+
+	return stats
 }
 
-// CloseAll 关闭池中所有 HTTP 客户端并清空客户端缓存。
+// CloseAll 关闭池中所有 HTTP 客户端、清空客户端缓存，并停止后台清理和套接字目录监听。
 // 应在服务器关闭时调用此方法以释放所有资源。
-// 调用 CloseAll 后，池仍可继续使用，会根据需要创建新客户端。
+// 调用 CloseAll 后，池仍可继续使用，会根据需要创建新客户端，数量上限仍然有效。
 func (p *ClientPool) CloseAll() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for _, client := range p.clients {
-		client.CloseIdleConnections()
+	p.stopJanitorLocked()
+	p.stopWatchLocked()
+
+	for _, entry := range p.clients {
+		entry.evict()
 	}
 
-	p.clients = make(map[string]*http.Client)
+	p.clients = make(map[string]*poolEntry)
+}
+
+// trackedConn 在连接关闭时减少所属客户端的连接计数。
+type trackedConn struct {
+	net.Conn
+
+	open *atomic.Int64
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+
+	return c.Conn.Close()
+}
+
+// trackingTransport 统计正在进行的请求数。
+// 请求从发出开始计数，直到响应体被关闭或请求失败为止。
+type trackingTransport struct {
+	base  *http.Transport
+	entry *poolEntry
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.entry.active.Add(1)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.entry.finish()
+
+		return nil, err
+	}
+
+	resp.Body = &trackedBody{ReadCloser: resp.Body, finish: t.entry.finish}
+
+	return resp, nil
+}
+
+// CloseIdleConnections 关闭底层传输层的空闲连接，供 [http.Client.CloseIdleConnections] 调用。
+func (t *trackingTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// trackedBody 在响应体关闭时结束请求计数。
+// 先关闭响应体使连接回到空闲状态，再结束计数，移出池的客户端才能关闭这个连接。
+type trackedBody struct {
+	io.ReadCloser
+
+	finish func()
+	once   sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.finish)
+
+	return err
 }
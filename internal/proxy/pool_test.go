@@ -1,6 +1,13 @@
 package proxy
 
 import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -86,6 +93,30 @@ func TestClientPool_GetClient(t *testing.T) {
 	})
 }
 
+// TestClientPool_GetNamedClient 测试按名称获取客户端
+func TestClientPool_GetNamedClient(t *testing.T) {
+	pool := NewClientPool(100, 10, 30*time.Second)
+
+	t.Run("相同名称和路径返回缓存的客户端", func(t *testing.T) {
+		client1 := pool.GetNamedClient("docker", "/var/run/docker.sock")
+		client2 := pool.GetNamedClient("docker", "/var/run/docker.sock")
+
+		assert.Same(t, client1, client2)
+	})
+
+	t.Run("名称指向新路径时重建客户端", func(t *testing.T) {
+		client1 := pool.GetNamedClient("app", "/run/app/v1.sock")
+		client2 := pool.GetNamedClient("app", "/run/app/v2.sock")
+
+		assert.NotSame(t, client1, client2)
+
+		pool.mu.RLock()
+		entry := pool.clients["app"]
+		pool.mu.RUnlock()
+		assert.Equal(t, "/run/app/v2.sock", entry.socketPath)
+	})
+}
+
 // TestClientPool_GetClient_Concurrent 测试并发获取客户端
 func TestClientPool_GetClient_Concurrent(t *testing.T) {
 	pool := NewClientPool(100, 10, 30*time.Second)
@@ -199,3 +230,239 @@ func TestClientPool_CloseAll_CanReusePool(t *testing.T) {
 	require.NotNil(t, client2, "关闭后应能创建新客户端")
 	assert.NotSame(t, client1, client2, "应是新创建的客户端")
 }
+
+// TestClientPool_Configure 测试更新连接设置
+func TestClientPool_Configure(t *testing.T) {
+	pool := NewClientPool(100, 10, 30*time.Second)
+	socketPath := "/var/run/configure.sock"
+
+	client1 := pool.GetClient(socketPath)
+
+	t.Run("设置未变化时保留客户端", func(t *testing.T) {
+		pool.Configure(100, 10, 30*time.Second)
+
+		assert.Same(t, client1, pool.GetClient(socketPath))
+	})
+
+	t.Run("设置变化后创建新客户端", func(t *testing.T) {
+		pool.Configure(50, 5, time.Second)
+
+		assert.Equal(t, 50, pool.maxConns)
+		assert.Equal(t, 5, pool.maxIdleConns)
+		assert.Equal(t, time.Second, pool.timeout)
+		assert.NotSame(t, client1, pool.GetClient(socketPath))
+	})
+}
+
+// TestClientPool_SetEviction_LRU 测试超出数量上限时移除最久未使用的客户端
+func TestClientPool_SetEviction_LRU(t *testing.T) {
+	pool := NewClientPool(100, 10, 30*time.Second)
+	pool.SetEviction(2, 0)
+
+	a := pool.GetClient("/var/run/a.sock")
+	_ = pool.GetClient("/var/run/b.sock")
+
+	// 使用 a 后，b 成为最久未使用的客户端
+	time.Sleep(time.Millisecond)
+	assert.Same(t, a, pool.GetClient("/var/run/a.sock"))
+
+	_ = pool.GetClient("/var/run/c.sock")
+
+	names := make([]string, 0, 2)
+	for _, st := range pool.Stats() {
+		names = append(names, st.Name)
+	}
+
+	assert.Equal(t, []string{"/var/run/a.sock", "/var/run/c.sock"}, names)
+	assert.Equal(t, EvictionStats{LRU: 1}, pool.Evictions())
+
+	t.Run("降低上限时立即移除多出的客户端", func(t *testing.T) {
+		pool.SetEviction(1, 0)
+
+		stats := pool.Stats()
+		require.Len(t, stats, 1)
+		assert.Equal(t, "/var/run/c.sock", stats[0].Name)
+		assert.Equal(t, EvictionStats{LRU: 2}, pool.Evictions())
+	})
+}
+
+// TestClientPool_SetEviction_LRUInFlight 测试淘汰仍有请求在进行的客户端后，
+// 请求结束时关闭其连接，而不是保留到 IdleConnTimeout
+func TestClientPool_SetEviction_LRUInFlight(t *testing.T) {
+	release := make(chan struct{})
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-release
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	pool := NewClientPool(10, 5, time.Second)
+	t.Cleanup(pool.CloseAll)
+	pool.SetEviction(1, 0)
+
+	client := pool.GetClient(socketPath)
+
+	pool.mu.RLock()
+	entry := pool.clients[socketPath]
+	pool.mu.RUnlock()
+
+	get := func(path string) *http.Response {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://localhost"+path, nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	slow := get("/slow")
+
+	_ = pool.GetClient("/var/run/other.sock")
+	assert.Equal(t, EvictionStats{LRU: 1}, pool.Evictions())
+
+	// A request still holding the evicted client reuses it after the eviction
+	fast := get("/fast")
+	_, _ = io.ReadAll(fast.Body)
+	require.NoError(t, fast.Body.Close())
+	assert.Equal(t, int64(2), entry.open.Load())
+
+	close(release)
+	_, _ = io.ReadAll(slow.Body)
+	require.NoError(t, slow.Body.Close())
+
+	require.Eventually(t, func() bool { return entry.open.Load() == 0 }, time.Second, time.Millisecond)
+	assert.Zero(t, entry.active.Load())
+}
+
+// TestClientPool_SetEviction_Idle 测试后台清理空闲客户端
+func TestClientPool_SetEviction_Idle(t *testing.T) {
+	release := make(chan struct{})
+
+	busySocket := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+
+	pool := NewClientPool(10, 5, time.Second)
+	t.Cleanup(pool.CloseAll)
+
+	// 进行中的请求使客户端不被清理
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+	require.NoError(t, err)
+
+	resp, err := pool.GetNamedClient("busy", busySocket).Do(req)
+	require.NoError(t, err)
+
+	_ = pool.GetClient("/var/run/idle.sock")
+
+	pool.SetEviction(0, 50*time.Millisecond)
+
+	require.Eventually(t, func() bool { return pool.Evictions().Idle == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	stats := pool.Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "busy", stats[0].Name)
+
+	close(release)
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	require.Eventually(t, func() bool { return len(pool.Stats()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, EvictionStats{Idle: 2}, pool.Evictions())
+
+	t.Run("CloseAll 停止后台清理", func(t *testing.T) {
+		pool.CloseAll()
+
+		pool.mu.RLock()
+		defer pool.mu.RUnlock()
+
+		assert.Nil(t, pool.stopJanitor)
+	})
+}
+
+// serveUnix 在 socketPath 上启动返回固定内容的后端。
+// 关闭时不删除套接字文件，模拟旧进程仍持有连接而文件已被替换的情况。
+func serveUnix(t *testing.T, socketPath, body string) {
+	t.Helper()
+
+	var lc net.ListenConfig
+
+	listener, err := lc.Listen(context.Background(), "unix", socketPath)
+	require.NoError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	backend.Listener = listener
+	backend.Start()
+	t.Cleanup(backend.Close)
+}
+
+// getBody 通过 client 发送请求并返回响应体。
+func getBody(t *testing.T, client *http.Client) string {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+// TestClientPool_RecreatedSocket 测试套接字重新创建后不再复用旧连接
+func TestClientPool_RecreatedSocket(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch bool
+	}{
+		{"每次取用时检查", false},
+		{"inotify 监听", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "uds")
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+			socketPath := filepath.Join(dir, "app.sock")
+			serveUnix(t, socketPath, "old")
+
+			pool := NewClientPool(10, 5, time.Second)
+			t.Cleanup(pool.CloseAll)
+
+			if tt.watch {
+				require.NoError(t, pool.WatchSockets())
+			}
+
+			require.Equal(t, "old", getBody(t, pool.GetClient(socketPath)))
+			require.Equal(t, 1, pool.Stats()[0].Idle)
+
+			// 后端重启：旧进程的连接仍然存活，但套接字文件已被替换
+			require.NoError(t, os.Remove(socketPath))
+			serveUnix(t, socketPath, "new")
+
+			if tt.watch {
+				require.Eventually(t, func() bool { return pool.Stats()[0].Idle == 0 }, 5*time.Second, 10*time.Millisecond)
+			}
+
+			assert.Equal(t, "new", getBody(t, pool.GetClient(socketPath)))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// preflightSocket 在连接前检查 socketPath 是否是可以连接的 Unix 套接字，
+// 返回对应的错误码，可以连接时返回空字符串。
+//
+// 文件不存在、不是套接字和没有权限分别对应不同的错误码和日志，
+// 便于区分后端未启动、路径配置错误和进程不在套接字属组中等情况。
+// 连接 Unix 套接字需要对套接字文件有写权限，权限按进程的有效用户和组（含附加组）检查，
+// 与连接时内核的判断一致；无法判断时交给实际连接处理。
+func preflightSocket(ctx context.Context, socketPath string) string {
+	log := loggerFrom(ctx)
+
+	fi, err := os.Stat(socketPath)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Warn("socket文件不存在", "path", socketPath)
+
+		return codeSocketNotFound
+	case errors.Is(err, fs.ErrPermission):
+		log.Warn("没有访问socket所在目录的权限", "path", socketPath, "euid", os.Geteuid(), "error", err)
+
+		return codePermissionDenied
+	case err != nil:
+		log.Warn("无法读取socket文件信息", "path", socketPath, "error", err)
+
+		return codeUpstreamError
+	}
+
+	if fi.Mode().Type() != fs.ModeSocket {
+		log.Warn("路径不是socket文件", "path", socketPath, "type", fileType(fi.Mode()))
+
+		return codeNotASocket
+	}
+
+	if err := unix.Faccessat(unix.AT_FDCWD, socketPath, unix.W_OK, unix.AT_EACCESS); errors.Is(err, unix.EACCES) {
+		attrs := []any{"path", socketPath, "mode", fi.Mode().Perm().String(), "euid", os.Geteuid(), "egid", os.Getegid()}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			attrs = append(attrs, "owner", st.Uid, "group", st.Gid)
+		}
+
+		log.Warn("没有连接socket的权限，请检查进程是否属于socket的属组", attrs...)
+
+		return codePermissionDenied
+	}
+
+	return ""
+}
+
+// fileType 返回文件类型的描述，用于日志。
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "regular file"
+	case mode.IsDir():
+		return "directory"
+	default:
+		return mode.Type().String()
+	}
+}
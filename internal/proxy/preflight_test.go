@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPreflightSocket 测试连接前对套接字路径的分类
+func TestPreflightSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	regular := filepath.Join(dir, "regular")
+	require.NoError(t, os.WriteFile(regular, nil, 0600))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"套接字", newUnixBackend(t, echoHandler()), ""},
+		{"没有进程监听的套接字", newDeadSocket(t), ""},
+		{"不存在", filepath.Join(dir, "missing.sock"), codeSocketNotFound},
+		{"普通文件", regular, codeNotASocket},
+		{"目录", dir, codeNotASocket},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, preflightSocket(t.Context(), tt.path))
+		})
+	}
+}
+
+// TestServer_handleProxy_Preflight 测试各类不可连接的路径返回互不相同的状态码
+func TestServer_handleProxy_Preflight(t *testing.T) {
+	server := newTestServer()
+	dir := t.TempDir()
+
+	regular := filepath.Join(dir, "regular")
+	require.NoError(t, os.WriteFile(regular, nil, 0600))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.handleProxy(rec, httptest.NewRequest(http.MethodGet, "/proxy?path="+url.QueryEscape(path), nil))
+
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"不存在", filepath.Join(dir, "missing.sock"), http.StatusServiceUnavailable, codeSocketNotFound},
+		{"普通文件", regular, http.StatusUnprocessableEntity, codeNotASocket},
+		{"目录", dir, http.StatusUnprocessableEntity, codeNotASocket},
+		{"没有进程监听", newDeadSocket(t), http.StatusBadGateway, codeConnectionRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.path)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.code, rec.Header().Get(errorHeader))
+		})
+	}
+
+	t.Run("状态码互不相同", func(t *testing.T) {
+		codes := []string{codeSocketNotFound, codeNotASocket, codePermissionDenied, codeConnectionRefused}
+		seen := make(map[int]string, len(codes))
+
+		for _, code := range codes {
+			status := gatewayErrors[code].status
+			if other, ok := seen[status]; ok {
+				t.Errorf("%s and %s share status %d", other, code, status)
+			}
+
+			seen[status] = code
+		}
+	})
+}
+
+// TestPreflightSocket_PermissionDenied 测试没有权限连接的套接字。
+// root 不受文件权限限制，以 root 运行时在切换到非特权用户的子进程中执行
+func TestPreflightSocket_PermissionDenied(t *testing.T) {
+	if !runUnprivileged(t) {
+		return
+	}
+
+	socketPath := newUnixBackend(t, echoHandler())
+	require.NoError(t, os.Chmod(socketPath, 0400))
+	assert.Equal(t, codePermissionDenied, preflightSocket(t.Context(), socketPath))
+
+	// Search permission on the parent directory is also required
+	require.NoError(t, os.Chmod(socketPath, 0600))
+	require.NoError(t, os.Chmod(filepath.Dir(socketPath), 0600))
+	t.Cleanup(func() { _ = os.Chmod(filepath.Dir(socketPath), 0700) })
+	assert.Equal(t, codePermissionDenied, preflightSocket(t.Context(), socketPath))
+}
+
+// TestServer_handleProxy_PreflightPermissionDenied 测试没有权限连接时的状态码和错误码
+func TestServer_handleProxy_PreflightPermissionDenied(t *testing.T) {
+	if !runUnprivileged(t) {
+		return
+	}
+
+	server := newTestServer()
+
+	socketPath := newUnixBackend(t, echoHandler())
+	require.NoError(t, os.Chmod(socketPath, 0400))
+
+	rec := httptest.NewRecorder()
+	server.handleProxy(rec, httptest.NewRequest(http.MethodGet, "/proxy?path="+url.QueryEscape(socketPath), nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, codePermissionDenied, rec.Header().Get(errorHeader))
+}
+
+// unprivilegedEnv 标记以非特权用户重新执行测试的子进程。
+const unprivilegedEnv = "UDS_PROXY_TEST_UNPRIVILEGED"
+
+// unprivilegedID 是子进程切换到的用户和组 (nobody/nogroup)。
+const unprivilegedID = 65534
+
+// runUnprivileged 使当前测试以非特权用户执行，返回 true 时调用方继续执行测试。
+//
+// 非 root 运行时直接返回 true。以 root 运行时在子进程中重新执行当前测试并返回 false，
+// 子进程在测试开始时切换到 [unprivilegedID] 后返回 true。
+// 切换用户影响整个进程，因此调用方须是顶层测试，且在切换前不能创建只有 root 可以清理的临时文件。
+func runUnprivileged(t *testing.T) bool {
+	t.Helper()
+
+	if os.Getenv(unprivilegedEnv) != "" {
+		require.NoError(t, syscall.Setgroups(nil))
+		require.NoError(t, syscall.Setresgid(unprivilegedID, unprivilegedID, unprivilegedID))
+		require.NoError(t, syscall.Setresuid(unprivilegedID, unprivilegedID, unprivilegedID))
+
+		return true
+	}
+
+	if os.Geteuid() != 0 {
+		return true
+	}
+
+	cmd := exec.CommandContext(t.Context(), os.Args[0], "-test.run=^"+t.Name()+"$", "-test.count=1", "-test.v")
+	cmd.Env = append(os.Environ(), unprivilegedEnv+"=1")
+
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "%s", out)
+	require.Contains(t, string(out), "--- PASS: "+t.Name(), "%s", out)
+
+	return false
+}
@@ -0,0 +1,528 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// 内置策略名称。
+const (
+	ProfileDockerReadOnly     = "docker-readonly"
+	ProfileDockerNoExec       = "docker-no-exec"
+	ProfileDockerNoPrivileged = "docker-no-privileged"
+)
+
+// maxInspectBodySize 是策略检查时允许缓冲的最大请求体大小。
+// Docker 的创建请求通常只有几 KB，超过此大小的请求直接拒绝。
+const maxInspectBodySize = 1 << 20
+
+// dockerVersionPrefix 匹配 Docker API 路径中的版本前缀，如 /v1.43。
+var dockerVersionPrefix = regexp.MustCompile(`^/v[0-9][0-9.]*(/|$)`)
+
+// apiRequest 是策略检查的输入。
+type apiRequest struct {
+	method   string
+	path     string   // 去掉 Docker API 版本前缀并规范化后的路径
+	segments []string // path 按 "/" 拆分后的各段
+
+	r    *http.Request
+	body []byte
+	read bool
+}
+
+// Body 读取并缓存请求体，读取后将请求体替换为缓存副本，使后续转发不受影响。
+// 请求体超过 maxInspectBodySize 或使用了内容编码时返回错误。
+func (req *apiRequest) Body() ([]byte, error) {
+	if req.read {
+		return req.body, nil
+	}
+
+	req.read = true
+
+	if enc := req.r.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return nil, fmt.Errorf("cannot inspect body with content encoding %q", enc)
+	}
+
+	if req.r.Body == nil || req.r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.r.Body, maxInspectBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxInspectBodySize {
+		return nil, errors.New("request body too large to inspect")
+	}
+
+	req.body = body
+	req.r.Body = io.NopCloser(bytes.NewReader(body))
+	req.r.ContentLength = int64(len(body))
+
+	return body, nil
+}
+
+// is 报告请求路径是否与 pattern 匹配，pattern 中的 "*" 匹配任意单个路径段。
+func (req *apiRequest) is(pattern string) bool {
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	if len(parts) != len(req.segments) {
+		return false
+	}
+
+	for i, part := range parts {
+		if part != "*" && part != req.segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// profileCheck 是一条内置的 Docker API 策略，返回拒绝原因，允许时返回空字符串。
+type profileCheck func(req *apiRequest) string
+
+// builtinProfiles 是所有内置策略，按名称索引。
+var builtinProfiles = map[string]profileCheck{
+	ProfileDockerReadOnly:     checkDockerReadOnly,
+	ProfileDockerNoExec:       checkDockerNoExec,
+	ProfileDockerNoPrivileged: checkDockerNoPrivileged,
+}
+
+// checkDockerReadOnly 只允许 GET 和 HEAD 请求，
+// 并拒绝导出文件系统、复制文件、保存镜像、读取 swarm 解锁密钥和交互式 attach
+// 等会泄露数据或可交互的读取端点。
+func checkDockerReadOnly(req *apiRequest) string {
+	if req.method != http.MethodGet && req.method != http.MethodHead {
+		return "method not allowed in read-only profile"
+	}
+
+	switch {
+	case req.is("/containers/*/export"),
+		req.is("/containers/*/archive"),
+		req.is("/containers/*/attach/ws"),
+		req.is("/images/get"),
+		strings.HasPrefix(req.path, "/images/") && strings.HasSuffix(req.path, "/get"),
+		req.is("/swarm/unlockkey"):
+		return "endpoint not allowed in read-only profile"
+	}
+
+	return ""
+}
+
+// checkDockerNoExec 拒绝在已有容器中执行命令或附加到容器的请求。
+func checkDockerNoExec(req *apiRequest) string {
+	switch {
+	case req.is("/containers/*/exec"),
+		req.is("/exec/*/start"),
+		req.is("/exec/*/resize"),
+		req.is("/containers/*/attach"),
+		req.is("/containers/*/attach/ws"):
+		return "exec and attach are not allowed"
+	}
+
+	return ""
+}
+
+// dockerDefaultCaps 是 Docker 默认授予容器的能力，CapAdd 中只允许这些能力。
+var dockerDefaultCaps = []string{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+	"NET_BIND_SERVICE", "NET_RAW", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// containerCreateRequest 是 POST /containers/create 请求体中与权限相关的字段。
+type containerCreateRequest struct {
+	HostConfig struct {
+		Privileged        bool
+		Binds             []string
+		Mounts            []containerMount
+		Devices           []json.RawMessage
+		DeviceCgroupRules []string
+		CapAdd            []string
+		SecurityOpt       []string
+		PidMode           string
+		NetworkMode       string
+		IpcMode           string
+		UTSMode           string
+		UsernsMode        string
+		CgroupnsMode      string
+	}
+}
+
+// containerUpdateRequest 是 POST /containers/{id}/update 请求体中与权限相关的字段。
+type containerUpdateRequest struct {
+	Devices           []json.RawMessage
+	DeviceCgroupRules []string
+}
+
+// execCreateRequest 是 POST /containers/{id}/exec 请求体中与权限相关的字段。
+type execCreateRequest struct {
+	Privileged bool
+}
+
+// containerMount 是 HostConfig.Mounts 的元素。
+type containerMount struct {
+	Type          string
+	VolumeOptions struct {
+		DriverConfig struct {
+			Options map[string]string
+		}
+	}
+}
+
+// serviceSpecRequest 是 POST /services/create 和 POST /services/{id}/update
+// 请求体中与权限相关的字段。
+type serviceSpecRequest struct {
+	TaskTemplate struct {
+		ContainerSpec struct {
+			Mounts        []containerMount
+			CapabilityAdd []string
+			Privileges    struct {
+				SELinuxContext struct {
+					Disable bool
+					Type    string
+				}
+				Seccomp  struct{ Mode string }
+				AppArmor struct{ Mode string }
+			}
+		}
+		Networks []struct{ Target string }
+	}
+}
+
+// volumeCreateRequest 是 POST /volumes/create 的请求体。
+type volumeCreateRequest struct {
+	DriverOpts map[string]string
+}
+
+// checkDockerNoPrivileged 检查创建容器、更新容器、创建 exec、创建和更新服务以及创建卷的请求体，
+// 拒绝特权容器和特权 exec、主机目录挂载、主机命名空间、设备映射、
+// 超出 Docker 默认能力的 CapAdd、关闭 seccomp/AppArmor/SELinux 隔离的 SecurityOpt，
+// 以及通过 local 卷驱动的 device 选项间接挂载主机目录。
+// 插件可以申请主机权限，安装、升级和修改插件设置的请求一律拒绝。
+func checkDockerNoPrivileged(req *apiRequest) string {
+	if req.method != http.MethodPost {
+		return ""
+	}
+
+	switch {
+	case req.is("/plugins/pull"),
+		req.is("/plugins/create"),
+		strings.HasPrefix(req.path, "/plugins/") && (strings.HasSuffix(req.path, "/set") || strings.HasSuffix(req.path, "/upgrade")):
+		return "installing or configuring plugins is not allowed"
+	case req.is("/containers/create"):
+		var create containerCreateRequest
+		if reason := decodeBody(req, &create); reason != "" {
+			return reason
+		}
+
+		return checkHostConfig(&create)
+	case req.is("/containers/*/update"):
+		var update containerUpdateRequest
+		if reason := decodeBody(req, &update); reason != "" {
+			return reason
+		}
+
+		if len(update.Devices) > 0 || len(update.DeviceCgroupRules) > 0 {
+			return "host devices are not allowed"
+		}
+	case req.is("/containers/*/exec"):
+		var exec execCreateRequest
+		if reason := decodeBody(req, &exec); reason != "" {
+			return reason
+		}
+
+		if exec.Privileged {
+			return "privileged exec is not allowed"
+		}
+	case req.is("/services/create"), req.is("/services/*/update"):
+		var spec serviceSpecRequest
+		if reason := decodeBody(req, &spec); reason != "" {
+			return reason
+		}
+
+		return checkServiceSpec(&spec)
+	case req.is("/volumes/create"):
+		var create volumeCreateRequest
+		if reason := decodeBody(req, &create); reason != "" {
+			return reason
+		}
+
+		if create.DriverOpts["device"] != "" {
+			return "volumes backed by host devices or directories are not allowed"
+		}
+	}
+
+	return ""
+}
+
+// checkHostConfig 检查容器创建请求中的 HostConfig。
+func checkHostConfig(create *containerCreateRequest) string {
+	hc := &create.HostConfig
+
+	if hc.Privileged {
+		return "privileged containers are not allowed"
+	}
+
+	for _, bind := range hc.Binds {
+		if strings.HasPrefix(bind, "/") {
+			return "host bind mounts are not allowed"
+		}
+	}
+
+	if reason := checkMounts(hc.Mounts); reason != "" {
+		return reason
+	}
+
+	if len(hc.Devices) > 0 || len(hc.DeviceCgroupRules) > 0 {
+		return "host devices are not allowed"
+	}
+
+	if reason := checkCapAdd(hc.CapAdd); reason != "" {
+		return reason
+	}
+
+	for _, opt := range hc.SecurityOpt {
+		if unconfinedSecurityOpt(opt) {
+			return "disabling security confinement is not allowed"
+		}
+	}
+
+	for _, mode := range []string{hc.PidMode, hc.NetworkMode, hc.IpcMode, hc.UTSMode, hc.UsernsMode, hc.CgroupnsMode} {
+		if mode == "host" {
+			return "host namespaces are not allowed"
+		}
+	}
+
+	return ""
+}
+
+// checkServiceSpec 检查服务规格中任务容器的挂载、能力、安全隔离和网络。
+func checkServiceSpec(spec *serviceSpecRequest) string {
+	cs := &spec.TaskTemplate.ContainerSpec
+
+	if reason := checkMounts(cs.Mounts); reason != "" {
+		return reason
+	}
+
+	if reason := checkCapAdd(cs.CapabilityAdd); reason != "" {
+		return reason
+	}
+
+	p := &cs.Privileges
+	if p.SELinuxContext.Disable || strings.EqualFold(p.SELinuxContext.Type, "spc_t") ||
+		strings.EqualFold(p.Seccomp.Mode, "unconfined") || strings.EqualFold(p.AppArmor.Mode, "disabled") {
+		return "disabling security confinement is not allowed"
+	}
+
+	for _, n := range spec.TaskTemplate.Networks {
+		if n.Target == "host" {
+			return "host namespaces are not allowed"
+		}
+	}
+
+	return ""
+}
+
+// checkMounts 拒绝主机目录挂载和通过卷驱动 device 选项间接挂载主机目录。
+func checkMounts(mounts []containerMount) string {
+	for _, m := range mounts {
+		if strings.EqualFold(m.Type, "bind") {
+			return "host bind mounts are not allowed"
+		}
+
+		if m.VolumeOptions.DriverConfig.Options["device"] != "" {
+			return "volumes backed by host devices or directories are not allowed"
+		}
+	}
+
+	return ""
+}
+
+// checkCapAdd 拒绝 Docker 默认能力以外的能力。
+func checkCapAdd(caps []string) string {
+	for _, c := range caps {
+		if !slices.Contains(dockerDefaultCaps, strings.TrimPrefix(strings.ToUpper(c), "CAP_")) {
+			return "adding capabilities is not allowed"
+		}
+	}
+
+	return ""
+}
+
+// unconfinedSecurityOpt 报告 SecurityOpt 选项是否关闭了 seccomp、AppArmor、
+// SELinux 或 /proc 等系统路径的隔离。选项的键值分隔符可以是 "=" 或旧式的 ":"。
+func unconfinedSecurityOpt(opt string) bool {
+	key, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		key, value, _ = strings.Cut(opt, ":")
+	}
+
+	key, value = strings.ToLower(strings.TrimSpace(key)), strings.ToLower(strings.TrimSpace(value))
+
+	switch key {
+	case "seccomp", "apparmor", "systempaths":
+		return value == "unconfined"
+	case "label":
+		return value == "disable" || value == "type:spc_t"
+	}
+
+	return false
+}
+
+// decodeBody 将请求体解码到 v，失败时返回拒绝原因。
+// 请求体无法检查时拒绝请求，而不是放行。
+func decodeBody(req *apiRequest, v any) string {
+	body, err := req.Body()
+	if err != nil {
+		return err.Error()
+	}
+
+	if len(body) == 0 {
+		return ""
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return "invalid JSON body"
+	}
+
+	return ""
+}
+
+// validateProfiles 校验策略配置中的策略名称和上游别名。
+func validateProfiles(byUpstream, byIdentity map[string][]string, upstreams map[string]string) error {
+	for name, profiles := range byUpstream {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("profiles configured for unknown upstream %q", name)
+		}
+
+		if err := checkProfileNames(profiles); err != nil {
+			return fmt.Errorf("upstream %q: %w", name, err)
+		}
+	}
+
+	for identity, profiles := range byIdentity {
+		if err := checkProfileNames(profiles); err != nil {
+			return fmt.Errorf("identity %q: %w", identity, err)
+		}
+	}
+
+	return nil
+}
+
+// checkProfileNames 检查策略名称是否都是内置策略。
+func checkProfileNames(names []string) error {
+	for _, name := range names {
+		if _, ok := builtinProfiles[name]; !ok {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+	}
+
+	return nil
+}
+
+// profilesFor 返回适用于本次请求的策略名称：
+// 目标上游的策略、目标套接字与某个上游相同时该上游的策略，以及调用方身份的策略。
+func (s *Server) profilesFor(r *http.Request, target proxyTarget) []string {
+	var names []string
+
+	cfg := s.settingsFor(r).config
+
+	for upstream, profiles := range cfg.Profiles.Upstreams {
+		if upstream == target.name || s.sameSocket(cfg.Upstreams[upstream], target.socketPath) {
+			names = append(names, profiles...)
+		}
+	}
+
+	if identity := requestInfoFrom(r.Context()).identity; identity != "" {
+		names = append(names, cfg.Profiles.Identities[identity]...)
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+// sameSocket 报告两个套接字路径规范化后是否相同。
+func (s *Server) sameSocket(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	ca, errA := canonicalSocketPath(a)
+	cb, errB := canonicalSocketPath(b)
+
+	return errA == nil && errB == nil && ca == cb
+}
+
+// checkProfiles 按适用的策略检查请求，拒绝时返回 403 并返回 false。
+func (s *Server) checkProfiles(w http.ResponseWriter, r *http.Request, target proxyTarget) bool {
+	names := s.profilesFor(r, target)
+	if len(names) == 0 {
+		return true
+	}
+
+	log := loggerFrom(r.Context())
+
+	req, err := newAPIRequest(r, target)
+	if err != nil {
+		log.Warn("无法解析目标路径，请求被策略拒绝", "url", target.url, "error", err)
+		s.writeError(w, r, codeProfileDenied, target)
+
+		return false
+	}
+
+	for _, name := range names {
+		if reason := builtinProfiles[name](req); reason != "" {
+			log.Warn("请求被策略拒绝",
+				"profile", name,
+				"reason", reason,
+				"method", req.method,
+				"path", req.path,
+				"socket", target.name,
+				"identity", requestInfoFrom(r.Context()).identity,
+			)
+			s.writeError(w, r, codeProfileDenied, target)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// apiPath 返回规范化并去掉 Docker API 版本前缀后的路径。
+func apiPath(p string) string {
+	p = path.Clean("/" + p)
+	if loc := dockerVersionPrefix.FindStringIndex(p); loc != nil {
+		p = path.Clean("/" + p[loc[1]:])
+	}
+
+	return p
+}
+
+// newAPIRequest 从代理目标构造策略检查的输入。
+func newAPIRequest(r *http.Request, target proxyTarget) (*apiRequest, error) {
+	u, err := url.Parse(target.url)
+	if err != nil {
+		return nil, err
+	}
+
+	p := apiPath(u.Path)
+
+	return &apiRequest{
+		method:   target.method,
+		path:     p,
+		segments: strings.Split(strings.Trim(p, "/"), "/"),
+		r:        r,
+	}, nil
+}
@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkProfile 使用指定策略检查请求，返回拒绝原因。
+func checkProfile(t *testing.T, name, method, target, body string) string {
+	t.Helper()
+
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if body == "" {
+		r.Body = http.NoBody
+	}
+
+	req, err := newAPIRequest(r, proxyTarget{method: method, url: "http://localhost" + target})
+	require.NoError(t, err)
+
+	return builtinProfiles[name](req)
+}
+
+// TestNewAPIRequest 测试目标路径的规范化
+func TestNewAPIRequest(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://localhost/containers/json", "/containers/json"},
+		{"http://localhost/v1.43/containers/json?all=1", "/containers/json"},
+		{"http://localhost/v1.43", "/"},
+		{"http://localhost/version", "/version"},
+		{"http://localhost//containers/abc/../abc/exec", "/containers/abc/exec"},
+		{"http://localhost/v1.43/../containers/abc/exec", "/containers/abc/exec"},
+		{"http://localhost/containers/abc%2Fdef/exec", "/containers/abc/def/exec"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req, err := newAPIRequest(httptest.NewRequest(http.MethodGet, "/", nil), proxyTarget{url: tt.url})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, req.path)
+		})
+	}
+}
+
+// TestCheckDockerReadOnly 测试只读策略
+func TestCheckDockerReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"列出容器", http.MethodGet, "/v1.43/containers/json", true},
+		{"列出镜像", http.MethodGet, "/images/json", true},
+		{"系统信息", http.MethodGet, "/info", true},
+		{"Ping", http.MethodHead, "/_ping", true},
+		{"容器日志", http.MethodGet, "/containers/abc/logs", true},
+		{"创建容器", http.MethodPost, "/containers/create", false},
+		{"删除容器", http.MethodDelete, "/containers/abc", false},
+		{"导出容器", http.MethodGet, "/containers/abc/export", false},
+		{"复制文件", http.MethodGet, "/containers/abc/archive", false},
+		{"WebSocket attach", http.MethodGet, "/containers/abc/attach/ws", false},
+		{"保存镜像", http.MethodGet, "/images/get", false},
+		{"保存带仓库名的镜像", http.MethodGet, "/images/library/nginx/get", false},
+		{"swarm 解锁密钥", http.MethodGet, "/v1.43/swarm/unlockkey", false},
+		{"swarm 信息", http.MethodGet, "/swarm", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := checkProfile(t, ProfileDockerReadOnly, tt.method, tt.path, "")
+			assert.Equal(t, tt.allowed, reason == "", reason)
+		})
+	}
+}
+
+// TestCheckDockerNoExec 测试禁止 exec 策略
+func TestCheckDockerNoExec(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"创建容器", http.MethodPost, "/containers/create", true},
+		{"启动容器", http.MethodPost, "/containers/abc/start", true},
+		{"创建 exec", http.MethodPost, "/v1.43/containers/abc/exec", false},
+		{"启动 exec", http.MethodPost, "/exec/123/start", false},
+		{"调整 exec 终端", http.MethodPost, "/exec/123/resize", false},
+		{"attach", http.MethodPost, "/containers/abc/attach", false},
+		{"WebSocket attach", http.MethodGet, "/containers/abc/attach/ws", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := checkProfile(t, ProfileDockerNoExec, tt.method, tt.path, "")
+			assert.Equal(t, tt.allowed, reason == "", reason)
+		})
+	}
+}
+
+// TestCheckDockerNoPrivileged 测试检查容器创建请求体的策略
+func TestCheckDockerNoPrivileged(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		allowed bool
+	}{
+		{"普通容器", "/containers/create", `{"Image":"nginx","HostConfig":{"Binds":["data:/data"]}}`, true},
+		{"空请求体", "/containers/create", ``, true},
+		{"其他端点", "/containers/abc/start", `{"HostConfig":{"Privileged":true}}`, true},
+		{"特权容器", "/v1.43/containers/create", `{"Image":"nginx","HostConfig":{"Privileged":true}}`, false},
+		{"字段名大小写不敏感", "/containers/create", `{"hostconfig":{"privileged":true}}`, false},
+		{"主机目录 Binds", "/containers/create", `{"HostConfig":{"Binds":["/:/host"]}}`, false},
+		{"主机目录 Mounts", "/containers/create", `{"HostConfig":{"Mounts":[{"Type":"bind","Source":"/etc","Target":"/etc"}]}}`, false},
+		{"卷驱动挂载主机目录", "/containers/create", `{"HostConfig":{"Mounts":[{"Type":"volume","VolumeOptions":{"DriverConfig":{"Name":"local","Options":{"type":"none","o":"bind","device":"/"}}}}]}}`, false},
+		{"主机 PID 命名空间", "/containers/create", `{"HostConfig":{"PidMode":"host"}}`, false},
+		{"主机网络", "/containers/create", `{"HostConfig":{"NetworkMode":"host"}}`, false},
+		{"设备映射", "/containers/create", `{"HostConfig":{"Devices":[{"PathOnHost":"/dev/sda"}]}}`, false},
+		{"设备 cgroup 规则", "/containers/create", `{"HostConfig":{"DeviceCgroupRules":["b 8:* rmw"]}}`, false},
+		{"默认能力", "/containers/create", `{"HostConfig":{"CapAdd":["NET_BIND_SERVICE","cap_chown"]}}`, true},
+		{"添加 SYS_ADMIN", "/containers/create", `{"HostConfig":{"CapAdd":["SYS_ADMIN"]}}`, false},
+		{"添加全部能力", "/containers/create", `{"HostConfig":{"CapAdd":["ALL"]}}`, false},
+		{"no-new-privileges", "/containers/create", `{"HostConfig":{"SecurityOpt":["no-new-privileges"]}}`, true},
+		{"关闭 seccomp", "/containers/create", `{"HostConfig":{"SecurityOpt":["seccomp=unconfined"]}}`, false},
+		{"旧式关闭 AppArmor", "/containers/create", `{"HostConfig":{"SecurityOpt":["apparmor:unconfined"]}}`, false},
+		{"关闭 SELinux 标签", "/containers/create", `{"HostConfig":{"SecurityOpt":["label=disable"]}}`, false},
+		{"更新资源限制", "/containers/abc/update", `{"Memory":314572800}`, true},
+		{"更新设备映射", "/v1.43/containers/abc/update", `{"Devices":[{"PathOnHost":"/dev/sda"}]}`, false},
+		{"更新设备 cgroup 规则", "/containers/abc/update", `{"DeviceCgroupRules":["c 1:3 mr"]}`, false},
+		{"普通 exec", "/containers/abc/exec", `{"Cmd":["ls"]}`, true},
+		{"特权 exec", "/containers/abc/exec", `{"Cmd":["sh"],"Privileged":true}`, false},
+		{"无效 JSON", "/containers/create", `{"HostConfig":`, false},
+		{"普通服务", "/services/create", `{"Name":"web","TaskTemplate":{"ContainerSpec":{"Image":"nginx","Mounts":[{"Type":"volume","Source":"data","Target":"/data"}]}}}`, true},
+		{"服务主机目录挂载", "/v1.43/services/create", `{"TaskTemplate":{"ContainerSpec":{"Mounts":[{"Type":"bind","Source":"/","Target":"/host"}]}}}`, false},
+		{"服务卷驱动挂载主机目录", "/services/create", `{"TaskTemplate":{"ContainerSpec":{"Mounts":[{"Type":"volume","VolumeOptions":{"DriverConfig":{"Options":{"device":"/"}}}}]}}}`, false},
+		{"服务添加 SYS_ADMIN", "/services/create", `{"TaskTemplate":{"ContainerSpec":{"CapabilityAdd":["CAP_SYS_ADMIN"]}}}`, false},
+		{"服务默认能力", "/services/create", `{"TaskTemplate":{"ContainerSpec":{"CapabilityAdd":["CAP_CHOWN"]}}}`, true},
+		{"服务关闭 seccomp", "/services/create", `{"TaskTemplate":{"ContainerSpec":{"Privileges":{"Seccomp":{"Mode":"unconfined"}}}}}`, false},
+		{"服务关闭 AppArmor", "/services/create", `{"TaskTemplate":{"ContainerSpec":{"Privileges":{"AppArmor":{"Mode":"disabled"}}}}}`, false},
+		{"服务关闭 SELinux", "/services/create", `{"TaskTemplate":{"ContainerSpec":{"Privileges":{"SELinuxContext":{"Disable":true}}}}}`, false},
+		{"服务主机网络", "/services/create", `{"TaskTemplate":{"Networks":[{"Target":"host"}]}}`, false},
+		{"更新服务为主机目录挂载", "/services/abc/update", `{"TaskTemplate":{"ContainerSpec":{"Mounts":[{"Type":"bind","Source":"/etc","Target":"/etc"}]}}}`, false},
+		{"更新服务副本数", "/services/abc/update", `{"Mode":{"Replicated":{"Replicas":3}}}`, true},
+		{"拉取插件", "/plugins/pull", `[{"Name":"network","Value":["host"]}]`, false},
+		{"从 tar 创建插件", "/plugins/create", ``, false},
+		{"修改插件设置", "/plugins/vieux/sshfs:latest/set", `["DEBUG=1"]`, false},
+		{"升级插件", "/plugins/vieux/sshfs/upgrade", `[]`, false},
+		{"启用已安装的插件", "/plugins/vieux/sshfs/enable", ``, true},
+		{"本地卷", "/volumes/create", `{"Name":"data"}`, true},
+		{"主机目录卷", "/volumes/create", `{"Name":"root","DriverOpts":{"type":"none","o":"bind","device":"/"}}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := checkProfile(t, ProfileDockerNoPrivileged, http.MethodPost, tt.path, tt.body)
+			assert.Equal(t, tt.allowed, reason == "", reason)
+		})
+	}
+}
+
+// TestApiRequest_Body 测试请求体检查的限制
+func TestApiRequest_Body(t *testing.T) {
+	t.Run("请求体过大", func(t *testing.T) {
+		body := `{"Image":"` + strings.Repeat("a", maxInspectBodySize) + `"}`
+		assert.NotEmpty(t, checkProfile(t, ProfileDockerNoPrivileged, http.MethodPost, "/containers/create", body))
+	})
+
+	t.Run("压缩的请求体", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("gzip"))
+		r.Header.Set("Content-Encoding", "gzip")
+
+		req, err := newAPIRequest(r, proxyTarget{method: http.MethodPost, url: "http://localhost/containers/create"})
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, checkDockerNoPrivileged(req))
+	})
+}
+
+// TestValidateProfiles 测试策略配置校验
+func TestValidateProfiles(t *testing.T) {
+	upstreams := map[string]string{"docker": "/var/run/docker.sock"}
+
+	require.NoError(t, validateProfiles(
+		map[string][]string{"docker": {ProfileDockerReadOnly}},
+		map[string][]string{"ci": {ProfileDockerNoExec, ProfileDockerNoPrivileged}},
+		upstreams,
+	))
+
+	require.Error(t, validateProfiles(map[string][]string{"missing": {ProfileDockerReadOnly}}, nil, upstreams))
+	require.Error(t, validateProfiles(map[string][]string{"docker": {"docker-everything"}}, nil, upstreams))
+	require.Error(t, validateProfiles(nil, map[string][]string{"ci": {"docker-everything"}}, upstreams))
+}
+
+// TestServer_Profiles 测试按上游和身份应用策略
+func TestServer_Profiles(t *testing.T) {
+	roSocket := newUnixBackend(t, echoHandler())
+	devSocket := newUnixBackend(t, echoHandler())
+
+	server, err := NewServer(&config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"ro": roSocket, "dev": devSocket},
+		Profiles: config.ProfilesConfig{
+			Upstreams:  map[string][]string{"ro": {ProfileDockerReadOnly}},
+			Identities: map[string][]string{"ci": {ProfileDockerNoPrivileged}},
+		},
+		Auth: config.AuthConfig{
+			Tokens: map[string]string{"ci": "ci-token", "admin": "admin-token"},
+		},
+	})
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.authMiddleware(server.routes()))
+
+	privileged := `{"Image":"nginx","HostConfig":{"Privileged":true}}`
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		body   string
+		status int
+	}{
+		{"只读上游允许 GET", http.MethodGet, "/u/ro/containers/json", "admin-token", "", http.StatusOK},
+		{"只读上游拒绝 POST", http.MethodPost, "/u/ro/containers/create", "admin-token", "{}", http.StatusForbidden},
+		{
+			"通过 /proxy 访问相同套接字时同样生效", http.MethodPost,
+			"/proxy?path=" + url.QueryEscape(roSocket) + "&url=/containers/create", "admin-token", "{}", http.StatusForbidden,
+		},
+		{
+			"按 method 参数覆盖后的方法检查", http.MethodGet,
+			"/proxy?path=" + url.QueryEscape(roSocket) + "&url=/containers/abc&method=DELETE", "admin-token", "", http.StatusForbidden,
+		},
+		{"身份策略拒绝特权容器", http.MethodPost, "/u/dev/containers/create", "ci-token", privileged, http.StatusForbidden},
+		{"小写方法按大写检查", "post", "/u/dev/containers/create", "ci-token", privileged, http.StatusForbidden},
+		{"只读上游允许小写 GET", "get", "/u/ro/containers/json", "admin-token", "", http.StatusOK},
+		{"其他身份不受身份策略限制", http.MethodPost, "/u/dev/containers/create", "admin-token", privileged, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+
+	t.Run("检查后的请求体完整转发", func(t *testing.T) {
+		body := `{"Image":"nginx"}`
+
+		req := httptest.NewRequest(http.MethodPost, "/u/dev/containers/create", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer ci-token")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var echo map[string]string
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&echo))
+		assert.Equal(t, body, echo["body"])
+		assert.Equal(t, "17", echo["content_length"])
+	})
+}
@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// 限流维度。
+const (
+	rateLimitByClient = "client"
+	rateLimitByIP     = "ip"
+	rateLimitBySocket = "socket"
+)
+
+// rateLimitSweepInterval 是清理已补满的令牌桶的最小间隔。
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket 是一个令牌桶，令牌按规则的速率连续补充，最多补充到容量。
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // 最近一次补充的时间
+}
+
+// bucketKey 标识一条规则下的一个令牌桶。
+type bucketKey struct {
+	rule int    // 规则在配置中的下标
+	key  string // 客户端标识或套接字路径
+}
+
+// rateLimiter 按限流规则维护令牌桶。
+//
+// 每条规则按维度为每个客户端或套接字分别维护令牌桶。一个请求适用多条规则时，
+// 只有所有令牌桶都有令牌才会放行，并从每个令牌桶各取一个；被拒绝的请求不消耗令牌。
+// 已补满的令牌桶与新建的令牌桶等价，定期清理，因此大量不同客户端不会使其无限增长。
+type rateLimiter struct {
+	rules []config.RateLimitRule
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+}
+
+// rateLimitTarget 是限流规则匹配和分组所需的请求属性。
+type rateLimitTarget struct {
+	client     string // 认证身份，未认证时为客户端 IP
+	ip         string // 客户端 IP，Unix 套接字连接为对端的 uid
+	name       string // 上游别名，经 /proxy 访问时为规范化后的套接字路径
+	socketPath string // 规范化后的套接字路径
+	path       string // 去掉 Docker API 版本前缀的目标路径
+}
+
+// rateLimitResult 是限流检查的结果，用于生成 RateLimit-* 响应头。
+type rateLimitResult struct {
+	limit      int           // 令牌桶容量
+	remaining  int           // 剩余令牌数
+	reset      time.Duration // 令牌桶补满所需时间
+	retryAfter time.Duration // 被拒绝时下一个令牌补充所需时间
+}
+
+// newRateLimiter 校验限流规则并创建限流器。
+// burst 为 0 时取 rate 向上取整；以 '/' 开头的套接字模式按 [canonicalPattern] 规范化。
+// 维度未知、速率不是正数、容量为负数、套接字 glob 模式无效或路径不以 '/' 开头时返回错误。
+func newRateLimiter(rules []config.RateLimitRule) (*rateLimiter, error) {
+	normalized := make([]config.RateLimitRule, len(rules))
+
+	for i, rule := range rules {
+		switch rule.By {
+		case rateLimitByClient, rateLimitByIP, rateLimitBySocket:
+		default:
+			return nil, fmt.Errorf("rate limit rule %d: unknown key %q, want client, ip or socket", i, rule.By)
+		}
+
+		if rule.Rate <= 0 || math.IsInf(rule.Rate, 0) || math.IsNaN(rule.Rate) {
+			return nil, fmt.Errorf("rate limit rule %d: rate must be positive", i)
+		}
+
+		if rule.Burst < 0 {
+			return nil, fmt.Errorf("rate limit rule %d: burst must not be negative", i)
+		}
+
+		if rule.Burst == 0 {
+			rule.Burst = int(math.Ceil(rule.Rate))
+		}
+
+		rule.Sockets = slices.Clone(rule.Sockets)
+
+		for j, pattern := range rule.Sockets {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rate limit rule %d: invalid socket pattern %q: %w", i, pattern, err)
+			}
+
+			// Paths match however the request spells them; aliases are kept as is
+			if strings.HasPrefix(pattern, "/") {
+				canonical, err := canonicalPattern(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("rate limit rule %d: invalid socket pattern %q: %w", i, pattern, err)
+				}
+
+				rule.Sockets[j] = canonical
+			}
+		}
+
+		for _, p := range rule.Paths {
+			if !strings.HasPrefix(p, "/") {
+				return nil, fmt.Errorf("rate limit rule %d: path %q must start with '/'", i, p)
+			}
+		}
+
+		normalized[i] = rule
+	}
+
+	return &rateLimiter{
+		rules:   normalized,
+		buckets: make(map[bucketKey]*tokenBucket),
+	}, nil
+}
+
+// take 从 keys 对应的令牌桶各取一个令牌，任一令牌桶没有令牌时不取任何令牌并拒绝请求。
+// 放行时返回剩余令牌最少的令牌桶的状态，拒绝时返回需要等待最久的令牌桶的状态。
+func (l *rateLimiter) take(now time.Time, keys []bucketKey) (rateLimitResult, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweepLocked(now)
+	}
+
+	buckets := make([]*tokenBucket, len(keys))
+	allowed := true
+
+	for i, k := range keys {
+		b := l.buckets[k]
+		if b == nil {
+			b = &tokenBucket{tokens: float64(l.rules[k.rule].Burst), last: now}
+			l.buckets[k] = b
+		}
+
+		l.refill(b, k.rule, now)
+		buckets[i] = b
+
+		if b.tokens < 1 {
+			allowed = false
+		}
+	}
+
+	var result rateLimitResult
+
+	for i, k := range keys {
+		rule, b := l.rules[k.rule], buckets[i]
+
+		if allowed {
+			b.tokens--
+		}
+
+		r := rateLimitResult{
+			limit:     rule.Burst,
+			remaining: int(b.tokens),
+			reset:     seconds((float64(rule.Burst) - b.tokens) / rule.Rate),
+		}
+
+		if !allowed && b.tokens < 1 {
+			r.retryAfter = seconds((1 - b.tokens) / rule.Rate)
+		}
+
+		if i == 0 || (allowed && r.remaining < result.remaining) || (!allowed && r.retryAfter > result.retryAfter) {
+			result = r
+		}
+	}
+
+	return result, allowed
+}
+
+// refill 按经过的时间为令牌桶补充令牌。调用者必须持有 l.mu。
+func (l *rateLimiter) refill(b *tokenBucket, rule int, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*l.rules[rule].Rate, float64(l.rules[rule].Burst))
+		b.last = now
+	}
+}
+
+// sweepLocked 移除已补满的令牌桶。调用者必须持有 l.mu。
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	for k, b := range l.buckets {
+		l.refill(b, k.rule, now)
+
+		if b.tokens >= float64(l.rules[k.rule].Burst) {
+			delete(l.buckets, k)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+// seconds 将秒数转换为 [time.Duration]。
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// setHeaders 设置 RateLimit-Limit、RateLimit-Remaining 和 RateLimit-Reset 响应头，
+// 被拒绝时还设置 Retry-After。时间向上取整到秒。
+func (r rateLimitResult) setHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(r.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(r.reset.Seconds()))))
+
+	if r.retryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(r.retryAfter.Seconds()))))
+	}
+}
+
+// rateLimitMiddleware 在转发前按限流规则检查代理请求，超出限制时返回 429。
+//
+// 适用规则的请求在响应中带有 RateLimit-* 响应头，反映剩余令牌最少的规则。
+// 无法确定目标的请求（缺少 path 参数、上游未配置）不限流，由处理函数返回相应的错误。
+func (s *Server) rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.settingsFor(r)
+		if st.limiter == nil {
+			next(w, r)
+
+			return
+		}
+
+		target, ok := newRateLimitTarget(r, st)
+		if !ok {
+			next(w, r)
+
+			return
+		}
+
+		var keys []bucketKey
+
+		for i, rule := range st.limiter.rules {
+			if s.rateLimitApplies(rule, target, st.config.Upstreams) {
+				keys = append(keys, bucketKey{rule: i, key: target.key(rule.By)})
+			}
+		}
+
+		if len(keys) == 0 {
+			next(w, r)
+
+			return
+		}
+
+		result, allowed := st.limiter.take(time.Now(), keys)
+		result.setHeaders(w.Header())
+
+		if !allowed {
+			loggerFrom(r.Context()).Warn("请求超出限流",
+				"client", target.client,
+				"socket", target.name,
+				"path", target.path,
+				"retry_after", result.retryAfter,
+			)
+			s.writeError(w, r, codeRateLimited, proxyTarget{name: target.name})
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// newRateLimitTarget 从代理请求中提取限流所需的属性，无法确定目标套接字时返回 false。
+func newRateLimitTarget(r *http.Request, st *settings) (rateLimitTarget, bool) {
+	var (
+		t      rateLimitTarget
+		target string
+	)
+
+	if name := r.PathValue("name"); name != "" {
+		socketPath, ok := st.config.Upstreams[name]
+		if !ok {
+			return t, false
+		}
+
+		t.name, t.socketPath = name, socketPath
+		target = upstreamPath(r)
+	} else {
+		requested := r.URL.Query().Get("path")
+		if requested == "" {
+			return t, false
+		}
+
+		t.name, t.socketPath = requested, requested
+		target = r.URL.Query().Get("url")
+	}
+
+	// Same path the handler sends to the backend
+	if u, err := url.Parse(target); err == nil {
+		t.path = u.Path
+	}
+
+	if canonical, err := canonicalSocketPath(t.socketPath); err == nil {
+		t.socketPath = canonical
+	}
+
+	// Named like the handler names it, however the caller spelled the path
+	if r.PathValue("name") == "" {
+		t.name = t.socketPath
+	}
+
+	t.path = apiPath(t.path)
+
+	t.ip = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		t.ip = host
+	} else if uid, ok := peerUIDFrom(r.Context()); ok {
+		// Unix socket peers have no address to tell them apart
+		t.ip = "uid:" + strconv.FormatUint(uint64(uid), 10)
+	}
+
+	t.client = "ip:" + t.ip
+	if identity := requestInfoFrom(r.Context()).identity; identity != "" {
+		t.client = "identity:" + identity
+	}
+
+	return t, true
+}
+
+// key 返回按维度 by 分组时的令牌桶标识。
+func (t rateLimitTarget) key(by string) string {
+	switch by {
+	case rateLimitByIP:
+		return t.ip
+	case rateLimitBySocket:
+		return t.socketPath
+	default:
+		return t.client
+	}
+}
+
+// rateLimitApplies 报告规则是否适用于请求：目标套接字和目标路径都在规则的范围内。
+// 套接字按上游别名、与别名相同的套接字或 glob 模式匹配；路径按路径段前缀匹配。
+func (s *Server) rateLimitApplies(rule config.RateLimitRule, t rateLimitTarget, upstreams map[string]string) bool {
+	if len(rule.Sockets) > 0 {
+		matched := false
+
+		for _, pattern := range rule.Sockets {
+			if pattern == t.name || s.sameSocket(upstreams[pattern], t.socketPath) {
+				matched = true
+
+				break
+			}
+
+			if ok, _ := filepath.Match(pattern, t.socketPath); ok {
+				matched = true
+
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if len(rule.Paths) == 0 {
+		return true
+	}
+
+	for _, prefix := range rule.Paths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix == "" || t.path == prefix || strings.HasPrefix(t.path, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewRateLimiter 测试限流规则的校验
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.RateLimitRule
+	}{
+		{"未知维度", config.RateLimitRule{By: "user", Rate: 1}},
+		{"速率为 0", config.RateLimitRule{By: rateLimitByClient}},
+		{"容量为负数", config.RateLimitRule{By: rateLimitByClient, Rate: 1, Burst: -1}},
+		{"glob 模式无效", config.RateLimitRule{By: rateLimitBySocket, Rate: 1, Sockets: []string{"/run/["}}},
+		{"路径不以 / 开头", config.RateLimitRule{By: rateLimitByIP, Rate: 1, Paths: []string{"containers"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRateLimiter([]config.RateLimitRule{tt.rule})
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("容量默认为速率向上取整", func(t *testing.T) {
+		l, err := newRateLimiter([]config.RateLimitRule{{By: rateLimitByClient, Rate: 2.5}})
+		require.NoError(t, err)
+		assert.Equal(t, 3, l.rules[0].Burst)
+	})
+}
+
+// TestRateLimiter_take 测试令牌桶的消耗、补充和多条规则的组合
+func TestRateLimiter_take(t *testing.T) {
+	l, err := newRateLimiter([]config.RateLimitRule{
+		{By: rateLimitByClient, Rate: 1, Burst: 2},
+		{By: rateLimitBySocket, Rate: 10, Burst: 3},
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	client := []bucketKey{{rule: 0, key: "a"}}
+
+	t.Run("突发后拒绝", func(t *testing.T) {
+		r, ok := l.take(start, client)
+		assert.True(t, ok)
+		assert.Equal(t, rateLimitResult{limit: 2, remaining: 1, reset: time.Second}, r)
+
+		_, ok = l.take(start, client)
+		assert.True(t, ok)
+
+		r, ok = l.take(start, client)
+		assert.False(t, ok)
+		assert.Equal(t, 0, r.remaining)
+		assert.Equal(t, time.Second, r.retryAfter)
+
+		r, ok = l.take(start.Add(500*time.Millisecond), client)
+		assert.False(t, ok)
+		assert.Equal(t, 500*time.Millisecond, r.retryAfter)
+
+		_, ok = l.take(start.Add(time.Second), client)
+		assert.True(t, ok)
+	})
+
+	t.Run("其他客户端不受影响", func(t *testing.T) {
+		_, ok := l.take(start.Add(time.Second), []bucketKey{{rule: 0, key: "b"}})
+		assert.True(t, ok)
+	})
+
+	t.Run("任一规则拒绝时不消耗令牌", func(t *testing.T) {
+		now := start.Add(time.Second)
+		both := []bucketKey{{rule: 0, key: "a"}, {rule: 1, key: "/run/app.sock"}}
+
+		_, ok := l.take(now, both)
+		assert.False(t, ok)
+
+		// The socket bucket still holds its full burst
+		r, ok := l.take(now, both[1:])
+		assert.True(t, ok)
+		assert.Equal(t, 2, r.remaining)
+	})
+
+	t.Run("放行时报告剩余最少的规则", func(t *testing.T) {
+		now := start.Add(3 * time.Second)
+		both := []bucketKey{{rule: 0, key: "a"}, {rule: 1, key: "/run/app.sock"}}
+
+		r, ok := l.take(now, both)
+		assert.True(t, ok)
+		assert.Equal(t, 2, r.limit)
+		assert.Equal(t, 1, r.remaining)
+	})
+
+	t.Run("清理已补满的令牌桶", func(t *testing.T) {
+		_, _ = l.take(start.Add(rateLimitSweepInterval+3*time.Second), client)
+
+		assert.Len(t, l.buckets, 1)
+	})
+}
+
+// TestServer_RateLimit 测试代理请求的限流和响应头
+func TestServer_RateLimit(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+
+	cfg := &config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+		RateLimit: config.RateLimitConfig{Rules: []config.RateLimitRule{
+			{By: rateLimitByClient, Rate: 0.01, Burst: 2, Sockets: []string{"app"}, Paths: []string{"/containers/json"}},
+		}},
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	serve := func(target, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remote
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("超出限制返回 429", func(t *testing.T) {
+		rec := serve("/u/app/containers/json", "192.168.1.10:51234")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "100", rec.Header().Get("RateLimit-Reset"))
+
+		// Same socket through /proxy with a Docker API version prefix
+		rec = serve("/proxy?path="+socketPath+"&url=/v1.43/containers/json", "192.168.1.10:51235")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+		rec = serve("/u/app/containers/json?all=1", "192.168.1.10:51236")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, codeRateLimited, rec.Header().Get(errorHeader))
+		assert.Equal(t, "100", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	})
+
+	t.Run("规则范围外不限流", func(t *testing.T) {
+		rec := serve("/u/app/containers/jsonx", "192.168.1.10:51234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+
+		rec = serve("/u/app/containers/json", "192.168.1.20:51234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("规则不变时重新加载保留状态", func(t *testing.T) {
+		next := *cfg
+		next.Timeout = 2000
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+
+		rec := serve("/u/app/containers/json", "192.168.1.10:51234")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	})
+
+	t.Run("未配置的上游交由处理函数", func(t *testing.T) {
+		rec := serve("/u/unknown/containers/json", "192.168.1.10:51234")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+// TestServer_RateLimit_SocketSpelling 测试 socket 路径的不同写法匹配同一条规则、共用同一个令牌桶
+func TestServer_RateLimit_SocketSpelling(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+	dir, base := filepath.Split(socketPath)
+
+	link := filepath.Join(t.TempDir(), "docker.sock")
+	require.NoError(t, os.Symlink(socketPath, link))
+
+	server, err := NewServer(&config.Config{
+		Timeout:     1000,
+		NoAccessLog: true,
+		RateLimit: config.RateLimitConfig{Rules: []config.RateLimitRule{
+			{By: rateLimitByClient, Rate: 0.01, Burst: 2, Sockets: []string{link}},
+		}},
+	})
+	require.NoError(t, err)
+
+	handler := server.routes()
+
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proxy?path="+url.QueryEscape(path)+"&url=/_ping", nil))
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/"+socketPath))
+	assert.Equal(t, http.StatusOK, serve(dir+"../"+filepath.Base(dir)+"/./"+base))
+	assert.Equal(t, http.StatusTooManyRequests, serve(link))
+}
+
+// TestServer_RateLimit_UnixPeers 测试 Unix 套接字客户端没有地址，按对端 uid 区分令牌桶
+func TestServer_RateLimit_UnixPeers(t *testing.T) {
+	socketPath := newUnixBackend(t, echoHandler())
+
+	for _, by := range []string{rateLimitByIP, rateLimitByClient} {
+		t.Run(by, func(t *testing.T) {
+			server, err := NewServer(&config.Config{
+				Timeout:     1000,
+				NoAccessLog: true,
+				Upstreams:   map[string]string{"app": socketPath},
+				RateLimit: config.RateLimitConfig{Rules: []config.RateLimitRule{
+					{By: by, Rate: 0.01, Burst: 1},
+				}},
+			})
+			require.NoError(t, err)
+
+			handler := server.accessLogMiddleware(server.routes())
+
+			serve := func(uid uint32) int {
+				req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+				req.RemoteAddr = "@"
+				req = req.WithContext(context.WithValue(req.Context(), peerUIDKey, uid))
+
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusOK, serve(1000))
+			assert.Equal(t, http.StatusTooManyRequests, serve(1000))
+			assert.Equal(t, http.StatusOK, serve(1001))
+		})
+	}
+
+	t.Run("经 Unix 监听器", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "uds")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+		listenPath := filepath.Join(dir, "proxy.sock")
+
+		server, err := NewServer(&config.Config{
+			Timeout:     1000,
+			NoAccessLog: true,
+			Listeners:   []config.ListenerConfig{{Network: "unix", Address: listenPath}},
+			Upstreams:   map[string]string{"app": socketPath},
+			RateLimit: config.RateLimitConfig{Rules: []config.RateLimitRule{
+				{By: rateLimitByIP, Rate: 0.01, Burst: 1},
+			}},
+		})
+		require.NoError(t, err)
+
+		go func() { _ = server.Run() }()
+
+		t.Cleanup(server.Shutdown)
+
+		require.Eventually(t, func() bool {
+			server.mu.Lock()
+			defer server.mu.Unlock()
+
+			return server.httpServer != nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+
+				return d.DialContext(ctx, "unix", listenPath)
+			},
+		}}
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/u/app/info", nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+
+		_ = resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		limiter := server.settings.Load().limiter
+
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+
+		assert.Contains(t, limiter.buckets, bucketKey{rule: 0, key: "uid:" + strconv.Itoa(os.Getuid())})
+	})
+}
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// settings 是可以热加载的配置快照。
+//
+// 每个请求进入时取得当前快照并存入请求上下文，处理期间始终使用同一份设置，
+// 因此重新加载不会影响正在处理的请求。快照创建后不再修改。
+type settings struct {
+	config  *config.Config
+	policy  *SocketPolicy
+	tokens  *TokenStore
+	access  *accessLogger
+	limiter *rateLimiter // 未配置限流规则时为 nil
+}
+
+// newSettings 校验 cfg 中可热加载的部分并创建快照。
+// 认证令牌来源与 prev 相同时复用 prev 的令牌存储，避免重复读取和监听令牌文件；
+// 访问日志文件同样复用；限流规则不变时复用 prev 的限流器，保留令牌桶的状态。
+func newSettings(cfg *config.Config, prev *settings) (*settings, error) {
+	policy, err := NewSocketPolicy(cfg.AllowedSockets, cfg.DeniedSockets)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateUpstreams(cfg.Upstreams); err != nil {
+		return nil, err
+	}
+
+	if err := validateProfiles(cfg.Profiles.Upstreams, cfg.Profiles.Identities, cfg.Upstreams); err != nil {
+		return nil, err
+	}
+
+	if err := validateHealthChecks(cfg.HealthCheck.Upstreams, cfg.Upstreams); err != nil {
+		return nil, err
+	}
+
+	var prevAccess *accessLogger
+	if prev != nil {
+		prevAccess = prev.access
+	}
+
+	access, err := newAccessLogger(cfg.AccessLog, prevAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &settings{config: cfg, policy: policy, access: access}
+
+	switch {
+	case len(cfg.RateLimit.Rules) == 0:
+	case prev != nil && prev.limiter != nil && fmt.Sprint(prev.config.RateLimit) == fmt.Sprint(cfg.RateLimit):
+		st.limiter = prev.limiter
+	default:
+		st.limiter, err = newRateLimiter(cfg.RateLimit.Rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.Auth.Tokens) == 0 && cfg.Auth.TokenFile == "" {
+		return st, nil
+	}
+
+	if prev != nil && prev.tokens != nil &&
+		fmt.Sprint(prev.config.Auth.Tokens) == fmt.Sprint(cfg.Auth.Tokens) &&
+		prev.config.Auth.TokenFile == cfg.Auth.TokenFile {
+		st.tokens = prev.tokens
+
+		return st, nil
+	}
+
+	st.tokens, err = NewTokenStore(cfg.Auth.Tokens, cfg.Auth.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// timeout 返回连接套接字、等待响应头和传输非流式响应体的超时时间，0 表示不限制。
+func (st *settings) timeout() time.Duration {
+	return time.Duration(st.config.Timeout) * time.Millisecond
+}
+
+// withSettings 返回携带配置快照的上下文。
+func withSettings(ctx context.Context, st *settings) context.Context {
+	return context.WithValue(ctx, settingsKey, st)
+}
+
+// pinSettings 返回当前配置快照，并登记请求对其访问日志的使用，请求结束时必须调用 st.access.release。
+// 快照的访问日志文件恰好被重新加载关闭时，改用新的快照。
+func (s *Server) pinSettings() *settings {
+	for {
+		st := s.settings.Load()
+		if st.access.acquire() {
+			return st
+		}
+	}
+}
+
+// settingsFor 返回请求所使用的配置快照。
+// 请求未经过中间件（如直接调用处理函数）时返回当前快照。
+func (s *Server) settingsFor(r *http.Request) *settings {
+	if st, ok := r.Context().Value(settingsKey).(*settings); ok {
+		return st
+	}
+
+	return s.settings.Load()
+}
+
+// configField 是一个配置项的 koanf 键及其取值函数，用于比较两份配置的差异。
+type configField struct {
+	key   string
+	value func(cfg *config.Config) any
+}
+
+// reloadableFields 是重新加载时立即生效的配置项。
+var reloadableFields = []configField{
+	{"timeout", func(c *config.Config) any { return c.Timeout }},
+	{"max_conns", func(c *config.Config) any { return c.MaxConns }},
+	{"max_idle_conns", func(c *config.Config) any { return c.MaxIdleConns }},
+	{"max_clients", func(c *config.Config) any { return c.MaxClients }},
+	{"client_idle_timeout", func(c *config.Config) any { return c.ClientIdleTimeout }},
+	{"no_access_log", func(c *config.Config) any { return c.NoAccessLog }},
+	{"problem_details", func(c *config.Config) any { return c.ProblemDetails }},
+	{"access_log", func(c *config.Config) any { return c.AccessLog }},
+	{"rate_limit", func(c *config.Config) any { return c.RateLimit }},
+	{"shutdown_delay", func(c *config.Config) any { return c.ShutdownDelay }},
+	{"shutdown_timeout", func(c *config.Config) any { return c.ShutdownTimeout }},
+	{"allowed_sockets", func(c *config.Config) any { return c.AllowedSockets }},
+	{"denied_sockets", func(c *config.Config) any { return c.DeniedSockets }},
+	{"upstreams", func(c *config.Config) any { return c.Upstreams }},
+	{"profiles", func(c *config.Config) any { return c.Profiles }},
+	{"retry", func(c *config.Config) any { return c.Retry }},
+	{"breaker", func(c *config.Config) any { return c.Breaker }},
+	{"concurrency", func(c *config.Config) any { return c.Concurrency }},
+	{"health_check", func(c *config.Config) any { return c.HealthCheck }},
+	{"auth", func(c *config.Config) any { return c.Auth }},
+}
+
+// restartFields 是需要重启才能生效的配置项。
+var restartFields = []configField{
+	{"host", func(c *config.Config) any { return c.Host }},
+	{"port", func(c *config.Config) any { return c.Port }},
+	{"port_file", func(c *config.Config) any { return c.PortFile }},
+	{"watch_config", func(c *config.Config) any { return c.WatchConfig }},
+	{"watch_sockets", func(c *config.Config) any { return c.WatchSockets }},
+	{"listeners", func(c *config.Config) any { return c.Listeners }},
+	{"metrics", func(c *config.Config) any { return c.Metrics }},
+	{"tracing", func(c *config.Config) any { return c.Tracing }},
+	{"audit", func(c *config.Config) any { return c.Audit }},
+	{"tls_cert", func(c *config.Config) any { return c.TLSCert }},
+	{"tls_key", func(c *config.Config) any { return c.TLSKey }},
+	{"tls_client_ca", func(c *config.Config) any { return c.TLSClientCA }},
+}
+
+// changedFields 返回 a 与 b 中取值不同的配置项键。
+// 按格式化结果比较，nil 与空的切片或映射视为相同。
+func changedFields(fields []configField, a, b *config.Config) []string {
+	var changed []string
+
+	for _, f := range fields {
+		if fmt.Sprint(f.value(a)) != fmt.Sprint(f.value(b)) {
+			changed = append(changed, f.key)
+		}
+	}
+
+	return changed
+}
+
+// Reload 应用新的配置，返回需要重启才能生效的已变更配置项（koanf 键）。
+//
+// 超时、连接数限制、访问日志开关和格式、错误响应格式、套接字允许/拒绝列表、上游别名、Docker API 策略、
+// 重试、熔断、健康检查和认证令牌会原子地切换到新配置：新请求使用新设置，
+// 正在处理的请求继续使用旧设置直到完成。
+// 监听地址、端口文件、指标服务、追踪和 TLS 等配置与启动时不同时只记录警告，重启后才生效。
+//
+// 在 [Server.Run] 启动后台监听之前调用时，令牌文件监听和健康检查由 Run 按最新配置启动。
+//
+// 新配置无效时返回错误，当前配置保持不变。
+func (s *Server) Reload(cfg *config.Config) ([]string, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	prev := s.settings.Load()
+
+	st, err := newSettings(cfg, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.watching && st.tokens != nil && st.tokens != prev.tokens {
+		if err := st.tokens.Watch(); err != nil {
+			slog.Warn("监听令牌文件失败，令牌文件变更需重启生效", "error", err)
+		}
+	}
+
+	s.settings.Store(st)
+
+	if prev.tokens != nil && prev.tokens != st.tokens {
+		_ = prev.tokens.Close()
+	}
+
+	// Closed once the requests still logging to the old file finish
+	if prev.access.file != st.access.file {
+		_ = prev.access.Close()
+	}
+
+	s.pool.Configure(cfg.MaxConns, cfg.MaxIdleConns, st.timeout())
+	s.pool.SetEviction(cfg.MaxClients, time.Duration(cfg.ClientIdleTimeout)*time.Millisecond)
+	s.breakers.Configure(cfg.Breaker)
+	s.concurrency.Configure(cfg.Concurrency)
+
+	if s.watching {
+		s.health.Configure(time.Duration(cfg.HealthCheck.Interval) * time.Millisecond)
+	}
+
+	for name := range prev.config.Upstreams {
+		if cfg.Upstreams[name] != prev.config.Upstreams[name] {
+			s.pool.RemoveClient(name)
+		}
+	}
+
+	slog.Info("配置已重新加载", "changed", changedFields(reloadableFields, prev.config, cfg))
+
+	pending := changedFields(restartFields, s.config, cfg)
+	if len(pending) > 0 {
+		slog.Warn("部分配置需要重启后生效", "fields", pending)
+	}
+
+	return pending, nil
+}
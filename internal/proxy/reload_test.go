@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServer_Reload 测试重新加载可热更新的配置
+func TestServer_Reload(t *testing.T) {
+	socketA := newUnixBackend(t, echoHandler())
+	socketB := newUnixBackend(t, echoHandler())
+
+	cfg := &config.Config{
+		Host:        "127.0.0.1",
+		Timeout:     1000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"a": socketA},
+		Auth:        config.AuthConfig{Tokens: map[string]string{"ci": "old-token"}},
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Shutdown)
+
+	handler := server.accessLogMiddleware(server.authMiddleware(server.routes()))
+
+	get := func(target, token string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, get("/u/a/", "old-token"))
+	require.Equal(t, http.StatusNotFound, get("/u/b/", "old-token"))
+
+	t.Run("上游、令牌和允许列表立即生效", func(t *testing.T) {
+		next := *cfg
+		next.Upstreams = map[string]string{"b": socketB}
+		next.Auth = config.AuthConfig{Tokens: map[string]string{"ci": "new-token"}}
+		next.AllowedSockets = []string{socketB}
+
+		pending, err := server.Reload(&next)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+
+		assert.Equal(t, http.StatusUnauthorized, get("/u/b/", "old-token"))
+		assert.Equal(t, http.StatusOK, get("/u/b/", "new-token"))
+		assert.Equal(t, http.StatusNotFound, get("/u/a/", "new-token"))
+		assert.Equal(t, http.StatusForbidden, get("/proxy?path="+socketA, "new-token"))
+	})
+
+	t.Run("无效配置被拒绝并保留当前配置", func(t *testing.T) {
+		next := *cfg
+		next.AllowedSockets = []string{"["}
+
+		_, err := server.Reload(&next)
+		require.Error(t, err)
+
+		assert.Equal(t, http.StatusOK, get("/u/b/", "new-token"))
+	})
+
+	t.Run("报告需要重启的配置项", func(t *testing.T) {
+		next := *cfg
+		next.Host = "0.0.0.0"
+		next.Listeners = []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}}
+
+		pending, err := server.Reload(&next)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"host", "listeners"}, pending)
+
+		// 可热更新的部分仍然生效
+		assert.Equal(t, http.StatusOK, get("/u/a/", "old-token"))
+	})
+}
+
+// TestServer_Reload_InFlight 测试正在处理的请求继续使用旧配置
+func TestServer_Reload_InFlight(t *testing.T) {
+	release := make(chan struct{})
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cfg := &config.Config{
+		Timeout:     5000,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"slow": socketPath},
+	}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Shutdown)
+
+	handler := server.accessLogMiddleware(server.routes())
+
+	done := make(chan int, 1)
+
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/u/slow/", nil))
+		done <- rec.Code
+	}()
+
+	require.Eventually(t, func() bool {
+		stats := server.pool.Stats()
+
+		return len(stats) == 1 && stats[0].Active == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 缩短超时并移除上游
+	next := *cfg
+	next.Timeout = 50
+	next.Upstreams = map[string]string{}
+
+	_, err = server.Reload(&next)
+	require.NoError(t, err)
+
+	// 超过新超时后再放行，旧请求不受影响
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	select {
+	case code := <-done:
+		assert.Equal(t, http.StatusOK, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request did not complete")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/u/slow/", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// TestServer_Reload_TokenFile 测试令牌来源变化时切换令牌存储
+func TestServer_Reload_TokenFile(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "tokens")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("ci:file-token\n"), 0600))
+
+	cfg := &config.Config{Auth: config.AuthConfig{Tokens: map[string]string{"admin": "admin-token"}}}
+
+	server, err := NewServer(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Shutdown)
+
+	old := server.settings.Load().tokens
+
+	t.Run("令牌来源未变化时复用", func(t *testing.T) {
+		next := *cfg
+		next.Timeout = 1000
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+		assert.Same(t, old, server.settings.Load().tokens)
+	})
+
+	t.Run("令牌文件无效时拒绝", func(t *testing.T) {
+		next := *cfg
+		next.Auth.TokenFile = filepath.Join(dir, "missing")
+
+		_, err := server.Reload(&next)
+		require.Error(t, err)
+		assert.Same(t, old, server.settings.Load().tokens)
+	})
+
+	t.Run("新增令牌文件", func(t *testing.T) {
+		next := *cfg
+		next.Auth.TokenFile = tokenFile
+
+		_, err := server.Reload(&next)
+		require.NoError(t, err)
+
+		tokens := server.settings.Load().tokens
+		assert.NotSame(t, old, tokens)
+
+		identity, ok := tokens.Lookup("file-token")
+		assert.True(t, ok)
+		assert.Equal(t, "ci", identity)
+	})
+}
+
+// TestConfigFields 测试每个配置项都归入立即生效或需要重启
+func TestConfigFields(t *testing.T) {
+	var keys []string
+
+	for _, f := range append(slices.Clone(reloadableFields), restartFields...) {
+		keys = append(keys, f.key)
+	}
+
+	typ := reflect.TypeFor[config.Config]()
+	for i := range typ.NumField() {
+		assert.Contains(t, keys, typ.Field(i).Tag.Get("koanf"))
+	}
+}
+
+// TestServer_Reload_DuringRun 测试启动期间重新加载（如收到 SIGHUP）时，被替换的令牌存储不再监听令牌文件
+func TestServer_Reload_DuringRun(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "tokens-a"), filepath.Join(dir, "tokens-b")}
+
+	for _, file := range files {
+		require.NoError(t, os.WriteFile(file, []byte("ci:ci-token\n"), 0600))
+	}
+
+	cfg := &config.Config{
+		NoAccessLog: true,
+		Listeners:   []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+		HealthCheck: config.HealthCheckConfig{Interval: 1000, Timeout: 1000},
+		Auth:        config.AuthConfig{TokenFile: files[0]},
+	}
+
+	// The window between Run and a reload is narrow; repeat to make overlap likely
+	for range 20 {
+		server, err := NewServer(cfg)
+		require.NoError(t, err)
+
+		stores := []*TokenStore{server.settings.Load().tokens}
+		reloaded := make(chan struct{})
+
+		// Keep reloading until Run has finished starting up
+		go func() {
+			defer close(reloaded)
+
+			for i := 1; ; i++ {
+				select {
+				case <-server.started:
+					return
+				default:
+				}
+
+				next := *cfg
+				next.Auth.TokenFile = files[i%2]
+
+				_, err := server.Reload(&next)
+				assert.NoError(t, err)
+
+				stores = append(stores, server.settings.Load().tokens)
+			}
+		}()
+
+		_, done := runServer(t, server)
+
+		<-reloaded
+		server.Shutdown()
+		require.NoError(t, <-done)
+
+		for i, store := range stores {
+			store.mu.Lock()
+			assert.Nil(t, store.watcher, i)
+			store.mu.Unlock()
+		}
+	}
+}
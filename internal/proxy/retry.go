@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+)
+
+// attemptsHeader 是报告后端请求尝试次数的响应头。
+const attemptsHeader = "X-UDS-Proxy-Attempts"
+
+// maxReplayBodySize 是为重试而缓冲的最大请求体大小，更大的请求体不重试。
+const maxReplayBodySize = 1 << 20
+
+// retryableMethod 报告方法是否可以在失败后安全重试。
+// GET、HEAD、OPTIONS 总是幂等的；PUT 和 DELETE 按语义幂等，但部分后端并不遵守，需要显式开启。
+func retryableMethod(method string, idempotentWrites bool) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPut, http.MethodDelete:
+		return idempotentWrites
+	default:
+		return false
+	}
+}
+
+// isRetryableError 报告后端请求错误是否可以重试。
+//
+// [http.Client.Do] 返回错误时尚未收到任何响应数据，拨号失败和复用的连接已被对端关闭都属于此类。
+// 超时不重试：后端可能仍在处理请求，重试只会成倍增加等待时间；客户端取消也不重试。
+func isRetryableError(err error) bool {
+	return !os.IsTimeout(err) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+// isConnectionError 报告后端请求错误是否由连接本身引起：拨号失败，或复用的连接已被对端关闭或重置。
+// 此时客户端缓存的连接可能已经失效，应从池中移除；超时和取消与连接状态无关，保留客户端。
+func isConnectionError(err error) bool {
+	if os.IsTimeout(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// retryBackoff 返回第 attempt 次尝试失败后的等待时间，从 base 开始每次翻倍，
+// 不超过 limit（limit 为 0 时不限制）。
+func retryBackoff(attempt int, base, limit time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && (limit <= 0 || d < limit); i++ {
+		d *= 2
+	}
+
+	if limit > 0 {
+		d = min(d, limit)
+	}
+
+	return d
+}
+
+// replayableBody 缓冲请求体，使其可以在重试时重新发送。
+// 没有请求体时返回空切片；请求体长度未知或超过 maxReplayBodySize 时返回 false，请求不重试。
+func replayableBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, true
+	}
+
+	if r.ContentLength < 0 || r.ContentLength > maxReplayBodySize {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	if err != nil {
+		// The body is partially consumed and can no longer be forwarded intact
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+
+		return nil, false
+	}
+
+	return body, true
+}
+
+// errReader 是总是返回 err 的 [io.Reader]。
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// roundTrip 通过连接池将请求发送到 target，返回后端响应和尝试次数。
+//
+// 方法可重试且请求体可以重放时，在收到任何响应数据之前失败的请求按 cfg 重试，
+// 重试之间按指数退避等待。连接错误（见 [isConnectionError]）会从池中移除该客户端，下一次尝试使用新的连接。
+// 返回错误时为最后一次尝试的错误。
+func (s *Server) roundTrip(ctx context.Context, r *http.Request, target proxyTarget, cfg config.RetryConfig) (*http.Response, int, error) {
+	maxAttempts := 1
+
+	var body []byte
+
+	if cfg.MaxAttempts > 1 && retryableMethod(target.method, cfg.IdempotentWrites) {
+		if buffered, ok := replayableBody(r); ok {
+			body, maxAttempts = buffered, cfg.MaxAttempts
+		}
+	}
+
+	backoff := time.Duration(cfg.Backoff) * time.Millisecond
+	maxBackoff := time.Duration(cfg.MaxBackoff) * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		attemptCtx := s.tracing.startUpstream(ctx, target, attempt)
+
+		backendReq, err := newBackendRequest(attemptCtx, r, target)
+		if err != nil {
+			s.tracing.endUpstream(attemptCtx, nil, err)
+
+			return nil, attempt, err
+		}
+
+		s.tracing.inject(attemptCtx, backendReq.Header)
+
+		resp, err := s.pool.GetNamedClient(target.name, target.socketPath).Do(backendReq)
+		s.tracing.endUpstream(attemptCtx, resp, err)
+
+		if err == nil {
+			return resp, attempt, nil
+		}
+
+		// Drop connections the backend has closed; timeouts and cancellation leave the pool alone
+		if isConnectionError(err) {
+			s.pool.RemoveClient(target.name)
+		}
+
+		s.metrics.upstreamError(target.name, err)
+
+		if attempt >= maxAttempts || !isRetryableError(err) {
+			return nil, attempt, err
+		}
+
+		delay := retryBackoff(attempt, backoff, maxBackoff)
+		loggerFrom(ctx).Warn("后端请求失败，准备重试",
+			"socket", target.socketPath,
+			"attempt", attempt,
+			"delay", delay,
+			"error", err,
+		)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, attempt, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRetryableMethod 测试可重试的方法
+func TestRetryableMethod(t *testing.T) {
+	tests := []struct {
+		method           string
+		idempotentWrites bool
+		want             bool
+	}{
+		{http.MethodGet, false, true},
+		{http.MethodHead, false, true},
+		{http.MethodOptions, false, true},
+		{http.MethodPut, false, false},
+		{http.MethodPut, true, true},
+		{http.MethodDelete, true, true},
+		{http.MethodPost, true, false},
+		{http.MethodPatch, true, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, retryableMethod(tt.method, tt.idempotentWrites), "%s %v", tt.method, tt.idempotentWrites)
+	}
+}
+
+// TestIsRetryableError 测试可重试的错误
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, isRetryableError(&net.OpError{Op: "dial", Err: os.ErrNotExist}))
+	assert.False(t, isRetryableError(context.Canceled))
+	assert.False(t, isRetryableError(context.DeadlineExceeded))
+	assert.False(t, isRetryableError(os.ErrDeadlineExceeded))
+}
+
+// TestIsConnectionError 测试需要移除客户端的连接错误
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"拨号失败", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"连接被重置", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"连接已关闭", &url.Error{Op: "Get", Err: io.EOF}, true},
+		{"等待响应头超时", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, false},
+		{"客户端取消", &url.Error{Op: "Get", Err: context.Canceled}, false},
+		{"截止时间已过", context.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isConnectionError(tt.err))
+		})
+	}
+}
+
+// TestRetryBackoff 测试指数退避
+func TestRetryBackoff(t *testing.T) {
+	base := 50 * time.Millisecond
+
+	assert.Equal(t, 50*time.Millisecond, retryBackoff(1, base, time.Second))
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(2, base, time.Second))
+	assert.Equal(t, 400*time.Millisecond, retryBackoff(4, base, time.Second))
+	assert.Equal(t, time.Second, retryBackoff(10, base, time.Second))
+	assert.Equal(t, 800*time.Millisecond, retryBackoff(5, base, 0))
+}
+
+// TestReplayableBody 测试请求体缓冲
+func TestReplayableBody(t *testing.T) {
+	t.Run("没有请求体", func(t *testing.T) {
+		body, ok := replayableBody(httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.True(t, ok)
+		assert.Empty(t, body)
+	})
+
+	t.Run("长度已知", func(t *testing.T) {
+		body, ok := replayableBody(httptest.NewRequest(http.MethodPut, "/", strings.NewReader("data")))
+		assert.True(t, ok)
+		assert.Equal(t, "data", string(body))
+	})
+
+	t.Run("长度未知", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("data"))
+		r.ContentLength = -1
+
+		_, ok := replayableBody(r)
+		assert.False(t, ok)
+	})
+
+	t.Run("超过大小限制", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(strings.Repeat("a", maxReplayBodySize+1)))
+
+		_, ok := replayableBody(r)
+		assert.False(t, ok)
+	})
+}
+
+// flakyListener 直接关闭前 fail 个连接，模拟在返回响应前断开的后端。
+type flakyListener struct {
+	net.Listener
+
+	fail atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || l.fail.Add(-1) < 0 {
+			return conn, err
+		}
+
+		_ = conn.Close()
+	}
+}
+
+// newFlakyBackend 启动一个 Unix 套接字后端，返回套接字路径和用于设置失败次数的监听器。
+func newFlakyBackend(t *testing.T) (string, *flakyListener) {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "backend.sock")
+
+	var lc net.ListenConfig
+
+	listener, err := lc.Listen(context.Background(), "unix", socketPath)
+	require.NoError(t, err)
+
+	flaky := &flakyListener{Listener: listener}
+
+	backend := httptest.NewUnstartedServer(echoHandler())
+	backend.Listener = flaky
+	backend.Start()
+	t.Cleanup(backend.Close)
+
+	return socketPath, flaky
+}
+
+// TestServer_Retry 测试连接失败时重试幂等请求
+func TestServer_Retry(t *testing.T) {
+	socketPath, backend := newFlakyBackend(t)
+
+	newHandler := func(retry config.RetryConfig) http.Handler {
+		server, err := NewServer(&config.Config{
+			Timeout:     1000,
+			NoAccessLog: true,
+			Upstreams:   map[string]string{"app": socketPath},
+			Retry:       retry,
+		})
+		require.NoError(t, err)
+
+		return server.accessLogMiddleware(server.routes())
+	}
+
+	retry := config.RetryConfig{MaxAttempts: 3, Backoff: 1, MaxBackoff: 10}
+	writes := retry
+	writes.IdempotentWrites = true
+
+	tests := []struct {
+		name     string
+		retry    config.RetryConfig
+		method   string
+		failures int32
+		status   int
+		attempts string
+	}{
+		{"GET 重试成功", retry, http.MethodGet, 1, http.StatusOK, "2"},
+		{"HEAD 重试成功", retry, http.MethodHead, 2, http.StatusOK, "3"},
+		{"超过最大尝试次数", retry, http.MethodGet, 3, http.StatusBadGateway, "3"},
+		{"POST 不重试", retry, http.MethodPost, 1, http.StatusBadGateway, "1"},
+		{"默认不重试 PUT", retry, http.MethodPut, 1, http.StatusBadGateway, "1"},
+		{"开启后重试 PUT", writes, http.MethodPut, 1, http.StatusOK, "2"},
+		{"开启后重试 DELETE", writes, http.MethodDelete, 1, http.StatusOK, "2"},
+		{"关闭重试", config.RetryConfig{MaxAttempts: 1}, http.MethodGet, 1, http.StatusBadGateway, "1"},
+		{"首次成功", retry, http.MethodGet, 0, http.StatusOK, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.fail.Store(tt.failures)
+
+			req := httptest.NewRequest(tt.method, "/u/app/items", strings.NewReader("payload"))
+			rec := httptest.NewRecorder()
+			newHandler(tt.retry).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.attempts, rec.Header().Get(attemptsHeader))
+		})
+	}
+
+	t.Run("重试时重新发送请求体", func(t *testing.T) {
+		backend.fail.Store(1)
+
+		req := httptest.NewRequest(http.MethodPut, "/u/app/items", strings.NewReader("payload"))
+		rec := httptest.NewRecorder()
+		newHandler(writes).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var echo map[string]string
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&echo))
+		assert.Equal(t, "payload", echo["body"])
+		assert.Equal(t, "7", echo["content_length"])
+	})
+}
@@ -1,96 +1,487 @@
 package proxy
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/lwmacct/251124-uds-proxy/internal/systemd"
 )
 
 // Server 表示 HTTP 代理服务器实例。
 // 它管理 HTTP 服务器、客户端连接池和服务器生命周期。
 type Server struct {
-	config     *config.Config
-	httpServer *http.Server
-	pool       *ClientPool
-	actualPort int
+	config        *config.Config // 启动时的配置，监听地址、TLS 等需要重启才能生效的设置从这里读取
+	settings      atomic.Pointer[settings]
+	reloadMu      sync.Mutex    // 串行化 Reload，并保护 watching
+	watching      bool          // Run 已启动令牌文件监听和健康检查，关闭时重置
+	mu            sync.Mutex    // 保护 running、httpServer、listeners 和 stopWatchdog，Run 与 Shutdown 可能并发调用
+	running       bool          // Run 已开始启动
+	started       chan struct{} // Run 启动完成或失败后关闭
+	httpServer    *http.Server
+	listeners     []*boundListener
+	stopWatchdog  context.CancelFunc
+	metricsServer *http.Server
+	pool          *ClientPool
+	breakers      *breakerSet
+	concurrency   *concurrencyLimiter
+	health        *healthChecker
+	metrics       *Metrics
+	tracing       *Tracing
+	audit         *auditLog
+	certs         *certReloader
+	actualPort    int
+
+	draining      atomic.Bool   // 开始关闭后为 true，/health 返回 503
+	stopping      chan struct{} // 排空等待结束后关闭，通知流式响应和升级连接结束
+	shutdownOnce  sync.Once
+	skipDelay     chan struct{} // 关闭后跳过剩余的 shutdown_delay
+	skipDelayOnce sync.Once
+	done          chan struct{} // Shutdown 完成后关闭
+	activeTunnels atomic.Int64  // 正在进行的升级连接数，http.Server 不跟踪已接管的连接
 }
 
 // NewServer 创建一个新的代理服务器实例。
-// 它使用提供的配置初始化服务器和客户端连接池。
+// 它使用提供的配置初始化服务器、客户端连接池和套接字访问策略。
+// 如果套接字访问策略中包含非法的 glob 模式、上游别名、Docker API 策略或监听器配置无效、
+// 令牌文件或 TLS 证书无法加载，则返回错误。
 func NewServer(cfg *config.Config) (*Server, error) {
+	st, err := newSettings(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateListeners(cfg.Listeners); err != nil {
+		return nil, err
+	}
+
+	if err := validateTLS(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA); err != nil {
+		return nil, err
+	}
+
 	s := &Server{
-		config: cfg,
-		pool:   NewClientPool(cfg.MaxConns, cfg.MaxIdleConns, time.Duration(cfg.Timeout)*time.Millisecond),
+		config:      cfg,
+		pool:        NewClientPool(cfg.MaxConns, cfg.MaxIdleConns, st.timeout()),
+		breakers:    newBreakerSet(cfg.Breaker),
+		concurrency: newConcurrencyLimiter(cfg.Concurrency),
+		started:     make(chan struct{}),
+		stopping:    make(chan struct{}),
+		skipDelay:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	s.settings.Store(st)
+	s.health = newHealthChecker(s.settings.Load)
+	s.pool.SetEviction(cfg.MaxClients, time.Duration(cfg.ClientIdleTimeout)*time.Millisecond)
+
+	if cfg.Metrics.Enabled {
+		s.metrics = NewMetrics(s.pool)
+		s.metrics.observeQueues(s.concurrency)
+	}
+
+	if cfg.TLSCert != "" {
+		s.certs, err = newCertReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Tracing.Exporter != "" {
+		s.tracing, err = NewTracing(cfg.Tracing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Audit.File != "" {
+		s.audit, err = newAuditLog(cfg.Audit)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return s, nil
 }
 
 // Run 启动 HTTP 服务器。
-// 它会设置路由、启动监听，并阻塞直到服务器关闭。
-// 如果配置中 Port 为 0，会自动分配可用端口。
+// 它会设置路由、绑定所有监听器，并阻塞直到服务器关闭。
+// 未配置监听器时监听 Host:Port，Port 为 0 时自动分配可用端口。
+// 配置了 TLS 证书时，TCP 监听器以 HTTPS 提供服务。
+//
+// 调用 [Server.Shutdown] 后，Run 等待关闭流程完成并返回 nil；Run 之前已调用 Shutdown 时不再启动。
+// 任一监听器异常停止时，Run 关闭整个服务器并返回该错误。
 func (s *Server) Run() error {
-	// Get available port
-	port, err := s.getAvailablePort()
-	if err != nil {
-		return fmt.Errorf("failed to get available port: %w", err)
+	s.mu.Lock()
+	if s.draining.Load() {
+		s.mu.Unlock()
+		<-s.done
+
+		return nil
 	}
 
-	s.actualPort = port
+	s.running = true
+	s.mu.Unlock()
+
+	// Let a concurrent Shutdown see the server, or learn that setup failed
+	setupDone := sync.OnceFunc(func() { close(s.started) })
+	defer setupDone()
+
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
 
 	// Write port to file
-	if err := s.writePortInfo(); err != nil {
-		slog.Warn("写入端口文件失败", "error", err)
+	if s.actualPort != 0 {
+		if err := s.writePortInfo(); err != nil {
+			slog.Warn("写入端口文件失败", "error", err)
+		}
 	}
 
 	// Setup HTTP server
+	handler := s.accessLogMiddleware(s.auditMiddleware(s.authMiddleware(s.routes())))
+
+	if err := s.startMetricsServer(); err != nil {
+		closeListeners(listeners)
+
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
+	// Background watchers start only after every listener is bound, so a failed
+	// start leaves nothing running; earlier reloads leave them to this call
+	s.startWatchers()
+
+	httpServer := &http.Server{
+		Handler:      handler,
+		ConnContext:  peerCredContext,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	if s.certs != nil {
+		httpServer.TLSConfig = s.certs.TLSConfig()
+	}
+
+	s.mu.Lock()
+	s.httpServer = httpServer
+	s.listeners = listeners
+	s.mu.Unlock()
+
+	setupDone()
+
+	// Print startup info
+	if s.actualPort != 0 {
+		slog.Info("PORT", "port", s.actualPort)
+	}
+
+	if err := s.serve(httpServer, listeners); !errors.Is(err, http.ErrServerClosed) {
+		// The remaining listeners would keep serving on their own
+		slog.Error("监听器异常停止，关闭服务器", "error", err)
+		s.skipShutdownDelay()
+		s.Shutdown()
+
+		return err
+	}
+
+	// Serve returns as soon as shutdown begins; wait for draining to finish
+	<-s.done
+
+	return nil
+}
+
+// serve 在所有监听器上提供服务，返回第一个监听器停止服务时的错误。
+// 所有监听器开始服务后向 systemd 发送就绪通知并启动看门狗。
+// 服务器关闭后返回 [http.ErrServerClosed]。
+func (s *Server) serve(httpServer *http.Server, listeners []*boundListener) error {
+	errCh := make(chan error, len(listeners))
+
+	for _, l := range listeners {
+		useTLS := s.certs != nil && l.network == networkTCP
+
+		slog.Info("服务器启动", "network", l.network, "addr", l.Addr().String(), "tls", useTLS)
+
+		go func() {
+			if useTLS {
+				errCh <- httpServer.ServeTLS(l, "", "")
+			} else {
+				errCh <- httpServer.Serve(l)
+			}
+		}()
+	}
+
+	notifySystemd(systemd.Ready)
+	s.startWatchdog()
+
+	return <-errCh
+}
+
+// startWatchdog 在 systemd 启用看门狗时，以超时时间一半的间隔发送心跳，直到服务器关闭。
+func (s *Server) startWatchdog() {
+	interval := systemd.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Shutdown has already taken its snapshot and would never stop it
+	if s.draining.Load() {
+		cancel()
+
+		return
+	}
+
+	s.stopWatchdog = cancel
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				notifySystemd(systemd.Watchdog)
+			}
+		}
+	}()
+}
+
+// notifySystemd 向 systemd 发送状态通知，不在 systemd 下运行时什么也不做。
+func notifySystemd(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		slog.Warn("systemd 通知失败", "state", state, "error", err)
+	}
+}
+
+// routes 注册所有端点并返回路由器。
+func (s *Server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleRoot)
 	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/proxy", s.handleProxy)
+	mux.HandleFunc("/livez", s.handleLivez)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/proxy", s.rateLimitMiddleware(s.handleProxy))
+	mux.HandleFunc("/u/{name}/{path...}", s.rateLimitMiddleware(s.handleUpstream))
+
+	// Internal state is only for authenticated clients on the proxy port
+	mux.HandleFunc("GET /admin/breakers", s.requireIdentity(s.handleBreakers))
+	mux.HandleFunc("GET /admin/queues", s.requireIdentity(s.handleQueues))
 
-	var handler http.Handler = mux
-	if !s.config.NoAccessLog {
-		handler = s.accessLogMiddleware(mux)
+	if s.metrics != nil && s.config.Metrics.Listen == "" {
+		mux.HandleFunc(s.metricsPath(), s.requireIdentity(s.metrics.Handler().ServeHTTP))
 	}
 
-	addr := fmt.Sprintf("%s:%d", s.config.Host, s.actualPort)
-	s.httpServer = &http.Server{
-		Addr:         addr,
-		Handler:      handler,
+	return mux
+}
+
+// metricsPath 返回指标端点路径，未配置时默认为 /metrics。
+func (s *Server) metricsPath() string {
+	if s.config.Metrics.Path == "" {
+		return "/metrics"
+	}
+
+	return s.config.Metrics.Path
+}
+
+// startMetricsServer 在配置了独立监听地址时启动指标服务，同时提供 /admin 端点。
+// 独立地址只应对运维网络开放，这里的端点不要求认证。
+// 监听在返回前完成，地址不可用时直接返回错误。
+func (s *Server) startMetricsServer() error {
+	if s.metrics == nil || s.config.Metrics.Listen == "" {
+		return nil
+	}
+
+	lc := net.ListenConfig{}
+
+	listener, err := lc.Listen(context.Background(), "tcp", s.config.Metrics.Listen)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(s.metricsPath(), s.metrics.Handler())
+	mux.HandleFunc("GET /admin/breakers", s.handleBreakers)
+	mux.HandleFunc("GET /admin/queues", s.handleQueues)
+
+	s.metricsServer = &http.Server{
+		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	// Print startup info
-	slog.Info("PORT", "port", s.actualPort)
-	slog.Info("服务器启动", "addr", addr)
+	slog.Info("指标服务启动", "addr", listener.Addr().String())
 
-	return s.httpServer.ListenAndServe()
+	go func() {
+		if err := s.metricsServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("指标服务异常退出", "error", err)
+		}
+	}()
+
+	return nil
 }
 
-// Shutdown 优雅地关闭服务器。
-// 它会等待正在处理的请求完成（最多 5 秒），关闭所有客户端连接，
-// 并清理端口文件（如果配置了的话）。
+// startWatchers 按当前配置快照开始监听令牌文件和套接字目录，并启动健康检查。
+// 持有 reloadMu，避免与 [Server.Reload] 替换令牌存储或调整健康检查交错。
+func (s *Server) startWatchers() {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.watching = true
+
+	st := s.settings.Load()
+
+	if st.tokens != nil {
+		if err := st.tokens.Watch(); err != nil {
+			slog.Warn("监听令牌文件失败，令牌文件变更需重启生效", "error", err)
+		}
+	}
+
+	if s.config.WatchSockets {
+		if err := s.pool.WatchSockets(); err != nil {
+			slog.Warn("监听套接字目录失败，改为每次请求检查", "error", err)
+		}
+	}
+
+	s.health.Configure(time.Duration(st.config.HealthCheck.Interval) * time.Millisecond)
+}
+
+// stopWatchers 停止健康检查和令牌文件监听，之后的 [Server.Reload] 不会再启动它们。
+func (s *Server) stopWatchers() {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.watching = false
+	s.health.Close()
+
+	if tokens := s.settings.Load().tokens; tokens != nil {
+		_ = tokens.Close()
+	}
+}
+
+// Shutdown 优雅地关闭服务器，可以多次调用，后续调用跳过剩余的 shutdown_delay 并等待第一次调用完成。
+// 可以与 [Server.Run] 并发调用：Run 仍在启动时等待其启动完成后再关闭。
+//
+// 关闭分为以下阶段：
+//  1. 向 systemd 发送 STOPPING=1，/health 开始返回 503，并关闭 HTTP keep-alive
+//  2. 等待 shutdown_delay，期间继续处理新请求，便于负载均衡器摘除流量
+//  3. 停止接受新连接，结束流式响应和升级连接，在 shutdown_timeout 内等待其余请求完成，
+//     超时后强制关闭剩余连接
+//  4. 关闭客户端连接池，清理创建的套接字文件和端口文件（如果配置了的话）
 func (s *Server) Shutdown() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if s.draining.Load() {
+		s.skipShutdownDelay()
+	}
+
+	s.shutdownOnce.Do(s.shutdown)
+}
+
+// skipShutdownDelay 结束或跳过关闭流程中对 shutdown_delay 的等待。
+func (s *Server) skipShutdownDelay() {
+	s.skipDelayOnce.Do(func() { close(s.skipDelay) })
+}
+
+// shutdown 执行 [Server.Shutdown] 的关闭流程。
+func (s *Server) shutdown() {
+	defer close(s.done)
+
+	cfg := s.settings.Load().config
+
+	s.mu.Lock()
+	s.draining.Store(true)
+	running := s.running
+	s.mu.Unlock()
+
+	notifySystemd(systemd.Stopping)
+
+	// Run is still setting up; wait for the server it is about to start
+	if running {
+		<-s.started
+	}
+
+	s.mu.Lock()
+	httpServer, listeners, stopWatchdog := s.httpServer, s.listeners, s.stopWatchdog
+	s.mu.Unlock()
+
+	if stopWatchdog != nil {
+		stopWatchdog()
+	}
+
+	if httpServer != nil {
+		httpServer.SetKeepAlivesEnabled(false)
+
+		if delay := time.Duration(cfg.ShutdownDelay) * time.Millisecond; delay > 0 {
+			slog.Info("开始排空连接", "delay", delay)
+
+			timer := time.NewTimer(delay)
+
+			select {
+			case <-timer.C:
+			case <-s.skipDelay:
+				timer.Stop()
+				slog.Info("跳过剩余的排空等待")
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Millisecond)
 	defer cancel()
 
-	if s.httpServer != nil {
-		if err := s.httpServer.Shutdown(ctx); err != nil {
-			slog.Warn("服务器关闭时出错", "error", err)
+	// Streams and tunnels never finish on their own; end them now
+	close(s.stopping)
+
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("等待请求完成超时，强制关闭连接", "error", err)
+
+			_ = httpServer.Close()
+		}
+	}
+
+	s.waitTunnels(ctx)
+
+	removeSocketFiles(listeners)
+
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Shutdown(ctx); err != nil {
+			slog.Warn("指标服务关闭时出错", "error", err)
+
+			_ = s.metricsServer.Close()
 		}
 	}
 
+	if err := s.tracing.Shutdown(ctx); err != nil {
+		slog.Warn("导出追踪数据时出错", "error", err)
+	}
+
+	s.stopWatchers()
 	s.pool.CloseAll()
 
+	_ = s.settings.Load().access.Close()
+
+	if s.audit != nil {
+		if err := s.audit.Close(); err != nil {
+			slog.Warn("关闭审计日志时出错", "error", err)
+		}
+	}
+
 	// Clean up port file
 	if s.config.PortFile != "" {
 		_ = os.Remove(s.config.PortFile)
@@ -99,6 +490,54 @@ func (s *Server) Shutdown() {
 	slog.Info("服务器关闭完成")
 }
 
+// untilShutdown 在服务器开始结束连接时调用 f，返回的函数用于取消。
+// 取消后 f 不会再被调用，但可能已经在执行。
+func (s *Server) untilShutdown(f func()) func() {
+	cancel := make(chan struct{})
+
+	go func() {
+		select {
+		case <-s.stopping:
+			f()
+		case <-cancel:
+		}
+	}()
+
+	return func() { close(cancel) }
+}
+
+// waitTunnels 等待所有升级连接结束，直到 ctx 结束。
+func (s *Server) waitTunnels(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.activeTunnels.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			slog.Warn("等待升级连接结束超时", "active", s.activeTunnels.Load())
+
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// validateUpstreams 校验上游别名配置。
+// 别名不能为空或包含 "/"，套接字路径不能为空。
+func validateUpstreams(upstreams map[string]string) error {
+	for name, socketPath := range upstreams {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid upstream name %q", name)
+		}
+
+		if socketPath == "" {
+			return fmt.Errorf("upstream %q has empty socket path", name)
+		}
+	}
+
+	return nil
+}
+
 // getAvailablePort 返回可用的端口号。
 // 如果配置中指定了端口，则返回该端口；否则自动查找可用端口。
 func (s *Server) getAvailablePort() (int, error) {
@@ -134,30 +573,119 @@ func (s *Server) writePortInfo() error {
 }
 
 // accessLogMiddleware 返回一个访问日志中间件。
-// 它记录每个请求的方法、路径、状态码和处理时间。
+// 它按 access_log 配置的格式和过滤条件记录每个请求（见 [accessEntry]），并将请求计入 Prometheus 指标。
+// 处理函数通过请求上下文中的 requestInfo 补充目标套接字、后端路径和失败原因等信息。
+// 它还为请求确定请求 ID（见 [requestID]），在响应头 X-Request-ID 中返回，并附加到请求处理期间的日志。
+// 启用追踪时，它还为请求开始和结束 server span。禁用访问日志时仍会记录指标和追踪。
 func (s *Server) accessLogMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+
+		// Pin the current settings so a reload does not affect this request
+		st := s.pinSettings()
+		defer st.access.release()
+
+		ctx := s.tracing.startRequest(withSettings(r.Context(), st), r)
+		ctx, info := withRequestInfo(ctx, requestID(ctx, r))
+		r = r.WithContext(ctx)
+
+		w.Header().Set(requestIDHeader, info.id)
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		next.ServeHTTP(wrapped, r)
-		slog.Info("access",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", wrapped.statusCode,
-			"duration", time.Since(start),
-		)
+
+		duration := time.Since(start)
+
+		method := info.method
+		if method == "" {
+			method = r.Method
+		}
+
+		s.metrics.observeRequest(info.socket, method, wrapped.statusCode, duration,
+			body.n+info.upgradedIn, wrapped.bytes+info.upgradedOut)
+		s.tracing.endRequest(ctx, wrapped.statusCode, info)
+
+		if st.config.NoAccessLog || st.access.skip(r.URL.Path, wrapped.statusCode) {
+			return
+		}
+
+		st.access.log(&accessEntry{
+			Time:          start,
+			RequestID:     info.id,
+			RemoteAddr:    r.RemoteAddr,
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Query:         r.URL.RawQuery,
+			Proto:         r.Proto,
+			Status:        wrapped.statusCode,
+			Duration:      duration,
+			BytesIn:       body.n + info.upgradedIn,
+			BytesOut:      wrapped.bytes + info.upgradedOut,
+			Socket:        info.socket,
+			SocketPath:    info.socketPath,
+			URL:           info.url,
+			Error:         info.errorCode,
+			UpstreamError: info.upstreamErr,
+			Identity:      info.identity,
+			Attempts:      info.attempts,
+			Referer:       r.Referer(),
+			UserAgent:     r.UserAgent(),
+		})
 	})
 }
 
+// countingReader 统计从请求体读取的字节数。
+type countingReader struct {
+	io.ReadCloser
+
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
 // responseWriter 是一个包装 http.ResponseWriter 的结构体，
-// 用于捕获响应状态码以便记录日志。
+// 用于捕获响应状态码和响应字节数以便记录日志和指标。
 type responseWriter struct {
 	http.ResponseWriter
 
 	statusCode int
+	bytes      int64
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+
+	return n, err
+}
+
+// Unwrap 返回底层的 http.ResponseWriter，
+// 使 [http.ResponseController] 能够访问 Flush 等扩展能力。
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack 接管底层连接，并将状态码记录为 101 以便访问日志反映协议升级。
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+
+	return conn, buf, err
+}
@@ -1,14 +1,20 @@
 package proxy
 
 import (
+	"bufio"
+	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/lwmacct/251124-uds-proxy/internal/systemd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -63,6 +69,26 @@ func TestNewServer(t *testing.T) {
 	}
 }
 
+// TestNewServer_InvalidUpstreams 测试无效的上游别名配置
+func TestNewServer_InvalidUpstreams(t *testing.T) {
+	tests := []struct {
+		name      string
+		upstreams map[string]string
+	}{
+		{"空别名", map[string]string{"": "/var/run/docker.sock"}},
+		{"别名包含斜杠", map[string]string{"a/b": "/var/run/docker.sock"}},
+		{"空套接字路径", map[string]string{"docker": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(&config.Config{Upstreams: tt.upstreams})
+
+			assert.Error(t, err)
+		})
+	}
+}
+
 // TestServer_getAvailablePort 测试获取可用端口
 func TestServer_getAvailablePort(t *testing.T) {
 	t.Run("返回配置的端口", func(t *testing.T) {
@@ -199,6 +225,13 @@ func TestResponseWriter(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, rw.statusCode)
 		assert.Equal(t, http.StatusNotFound, rec.Code)
 	})
+
+	t.Run("Unwrap 返回底层 ResponseWriter", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rw := &responseWriter{ResponseWriter: rec, statusCode: http.StatusOK}
+
+		assert.Same(t, rec, rw.Unwrap())
+	})
 }
 
 // TestServer_Shutdown 测试服务器关闭
@@ -255,3 +288,275 @@ func TestServer_PoolIntegration(t *testing.T) {
 	assert.Equal(t, 5, server.pool.maxIdleConns)
 	assert.Equal(t, time.Duration(1000)*time.Millisecond, server.pool.timeout)
 }
+
+// TestServer_Run_SystemdNotify 测试向 systemd 发送就绪、看门狗和关闭通知
+func TestServer_Run_SystemdNotify(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sd")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	notifyPath := filepath.Join(dir, "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifyPath, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", notifyPath)
+	t.Setenv("WATCHDOG_USEC", "100000")
+
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		Listeners:   []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+	})
+	require.NoError(t, err)
+
+	go func() { _ = server.Run() }()
+
+	next := func() string {
+		buf := make([]byte, 64)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+
+		return string(buf[:n])
+	}
+
+	assert.Equal(t, systemd.Ready, next())
+	assert.Equal(t, systemd.Watchdog, next())
+
+	server.Shutdown()
+
+	// 关闭前可能还有看门狗心跳
+	for {
+		state := next()
+		if state != systemd.Watchdog {
+			assert.Equal(t, systemd.Stopping, state)
+
+			break
+		}
+	}
+}
+
+// runServer 在 127.0.0.1 的随机端口上运行服务器，返回其 URL 和 Run 的返回值通道。
+func runServer(t *testing.T, server *Server) (string, <-chan error) {
+	t.Helper()
+
+	done := make(chan error, 1)
+
+	go func() { done <- server.Run() }()
+
+	var port int
+
+	require.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		// actualPort is written before httpServer is published under mu
+		if server.httpServer == nil {
+			return false
+		}
+
+		port = server.actualPort
+
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), done
+}
+
+// streamingBackend 模拟 Docker events：先输出一行，然后保持连接直到客户端断开。
+func streamingBackend() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("event\n"))
+		http.NewResponseController(w).Flush()
+
+		<-r.Context().Done()
+	})
+}
+
+// TestServer_Shutdown_Drain 测试排空期间健康检查失败，流式响应被正常结束
+func TestServer_Shutdown_Drain(t *testing.T) {
+	socketPath := newUnixBackend(t, streamingBackend())
+
+	server, err := NewServer(&config.Config{
+		Timeout:         1000,
+		NoAccessLog:     true,
+		ShutdownDelay:   300,
+		ShutdownTimeout: 5000,
+		Upstreams:       map[string]string{"events": socketPath},
+		Listeners:       []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+	})
+	require.NoError(t, err)
+
+	baseURL, done := runServer(t, server)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, baseURL+"/u/events/events", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer func() { _ = resp.Body.Close() }()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event\n", line)
+
+	start := time.Now()
+
+	go server.Shutdown()
+
+	// 排空期间继续服务，但健康检查返回 503
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, baseURL+"/health", nil)
+		require.NoError(t, err)
+
+		health, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+
+		_ = health.Body.Close()
+
+		return health.StatusCode == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+
+	// 流式响应以正常的分块结束，而不是等到超时被强制断开
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.Less(t, elapsed, 3*time.Second)
+}
+
+// TestServer_Shutdown_Tunnel 测试关闭时结束升级连接
+func TestServer_Shutdown_Tunnel(t *testing.T) {
+	socketPath := newUnixBackend(t, upgradeBackend(t, dockerRawStream))
+
+	server, err := NewServer(&config.Config{
+		Timeout:         1000,
+		NoAccessLog:     true,
+		ShutdownTimeout: 5000,
+		Upstreams:       map[string]string{"docker": socketPath},
+		Listeners:       []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+	})
+	require.NoError(t, err)
+
+	baseURL, done := runServer(t, server)
+
+	_, reader, resp := dialUpgrade(t, baseURL, "/u/docker/containers/abc/attach", "tcp")
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	require.Eventually(t, func() bool { return server.activeTunnels.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	go server.Shutdown()
+
+	// 客户端读到连接关闭
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+
+	assert.Zero(t, server.activeTunnels.Load())
+}
+
+// TestServer_Shutdown_Twice 测试重复调用 Shutdown
+func TestServer_Shutdown_Twice(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		Listeners:   []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+	})
+	require.NoError(t, err)
+
+	_, done := runServer(t, server)
+
+	server.Shutdown()
+	server.Shutdown()
+
+	require.NoError(t, <-done)
+}
+
+// TestServer_Shutdown_BeforeRun 测试 Run 之前调用 Shutdown
+func TestServer_Shutdown_BeforeRun(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		Listeners:   []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+	})
+	require.NoError(t, err)
+
+	server.Shutdown()
+
+	require.NoError(t, server.Run())
+	assert.Nil(t, server.httpServer)
+}
+
+// TestServer_Shutdown_SkipDelay 测试再次调用 Shutdown 跳过剩余的排空等待
+func TestServer_Shutdown_SkipDelay(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		NoAccessLog:   true,
+		ShutdownDelay: 60000,
+		Listeners:     []config.ListenerConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+	})
+	require.NoError(t, err)
+
+	_, done := runServer(t, server)
+
+	go server.Shutdown()
+
+	require.Eventually(t, server.draining.Load, time.Second, time.Millisecond)
+	server.Shutdown()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the second Shutdown")
+	}
+}
+
+// TestServer_Run_ServeError 测试任一监听器异常停止时关闭整个服务器
+func TestServer_Run_ServeError(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		Listeners: []config.ListenerConfig{
+			{Network: "tcp", Address: "127.0.0.1:0"},
+			{Network: "unix", Address: filepath.Join(t.TempDir(), "proxy.sock")},
+		},
+	})
+	require.NoError(t, err)
+
+	_, done := runServer(t, server)
+
+	server.mu.Lock()
+	tcp, unix := server.listeners[0], server.listeners[1]
+	server.mu.Unlock()
+
+	require.NoError(t, unix.Close())
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after a listener failed")
+	}
+
+	var d net.Dialer
+
+	_, err = d.DialContext(t.Context(), "tcp", tcp.Addr().String())
+	assert.Error(t, err)
+}
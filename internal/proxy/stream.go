@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// streamBufferSize 是流式复制时单次读取的缓冲区大小。
+const streamBufferSize = 32 * 1024
+
+// isStreamingResponse 报告后端响应是否应按流式方式转发。
+//
+// 长度未知（分块传输或以关闭连接结束）的响应，以及 Server-Sent Events，
+// 都可能是 Docker /events、/containers/{id}/logs?follow=1、/stats 这类无限流，
+// 需要在每次读取后立即刷新，且不能受服务器写超时限制。
+func isStreamingResponse(resp *http.Response) bool {
+	if resp.ContentLength == -1 {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	return mediaType == "text/event-stream"
+}
+
+// streamBody 将流式响应体复制到客户端，每次读取后立即刷新。
+//
+// 它会先刷新响应头，使客户端在第一块数据到达前即可看到响应，
+// 并清除服务器设置的写超时，避免长连接流被截断。
+// 客户端断开或服务器关闭时后端请求被取消，后端读取随之返回错误，复制结束。
+// 返回写入客户端的字节数。
+func streamBody(w http.ResponseWriter, r *http.Request, body io.Reader) int64 {
+	rc := http.NewResponseController(w)
+	log := loggerFrom(r.Context())
+
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Debug("清除写超时失败", "error", err)
+	}
+
+	if err := flush(rc); err != nil {
+		return 0
+	}
+
+	var written int64
+
+	buf := make([]byte, streamBufferSize)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			wn, werr := w.Write(buf[:n])
+			written += int64(wn)
+
+			if werr != nil {
+				log.Debug("客户端写入失败，停止流式传输", "error", werr)
+
+				return written
+			}
+
+			if ferr := flush(rc); ferr != nil {
+				return written
+			}
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) && r.Context().Err() == nil {
+				log.Warn("读取流式响应失败", "error", err)
+			}
+
+			return written
+		}
+	}
+}
+
+// flush 刷新缓冲的响应数据，不支持刷新的 ResponseWriter 视为成功。
+func flush(rc *http.ResponseController) error {
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
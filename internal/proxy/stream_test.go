@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIsStreamingResponse 测试流式响应识别
+func TestIsStreamingResponse(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+		contentType   string
+		want          bool
+	}{
+		{"长度未知", -1, "application/json", true},
+		{"Server-Sent Events", 100, "text/event-stream; charset=utf-8", true},
+		{"长度已知", 100, "application/json", false},
+		{"空响应", 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{ContentLength: tt.contentLength, Header: http.Header{}}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+
+			assert.Equal(t, tt.want, isStreamingResponse(resp))
+		})
+	}
+}
+
+// newStreamingProxy 启动一个写超时很短的代理服务器，用于验证流式响应不受写超时限制。
+func newStreamingProxy(t *testing.T, socketPath string) *httptest.Server {
+	t.Helper()
+
+	server, err := NewServer(&config.Config{
+		Timeout:   2000,
+		Upstreams: map[string]string{"docker": socketPath},
+	})
+	require.NoError(t, err)
+
+	proxy := httptest.NewUnstartedServer(server.accessLogMiddleware(server.routes()))
+	proxy.Config.WriteTimeout = 200 * time.Millisecond
+	proxy.Start()
+	t.Cleanup(proxy.Close)
+
+	return proxy
+}
+
+// TestServer_forward_Streaming 测试流式响应立即刷新且不受写超时限制
+func TestServer_forward_Streaming(t *testing.T) {
+	next := make(chan struct{})
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"start"}` + "\n"))
+		w.(http.Flusher).Flush()
+
+		// 等待客户端确认已收到第一条事件，并超过代理的写超时
+		select {
+		case <-next:
+		case <-r.Context().Done():
+			return
+		}
+
+		time.Sleep(400 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"status":"die"}` + "\n"))
+	}))
+
+	proxy := newStreamingProxy(t, socketPath)
+
+	resp, err := http.Get(proxy.URL + "/u/docker/events")
+	require.NoError(t, err)
+
+	defer func() { _ = resp.Body.Close() }()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	reader := bufio.NewReader(resp.Body)
+
+	// 第一条事件应在后端继续写入之前到达
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"start"}`, line)
+
+	close(next)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"die"}`, line)
+}
+
+// TestServer_forward_StreamingClientDisconnect 测试客户端断开后停止读取后端流
+func TestServer_forward_StreamingClientDisconnect(t *testing.T) {
+	backendDone := make(chan struct{})
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(backendDone)
+
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err := w.Write([]byte("{}\n")); err != nil {
+					return
+				}
+
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+
+	proxy := newStreamingProxy(t, socketPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+"/u/docker/events", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	_, err = bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+
+	cancel()
+
+	_ = resp.Body.Close()
+
+	select {
+	case <-backendDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端断开后后端流未结束")
+	}
+}
+
+// TestServer_forward_BodyTimeout 测试非流式响应体停滞时在超时后中断
+func TestServer_forward_BodyTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write([]byte("abc"))
+		w.(http.Flusher).Flush()
+		<-unblock
+	}))
+
+	server, err := NewServer(&config.Config{
+		Timeout:     100,
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/u/app/containers/json", nil))
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "abc", rec.Body.String())
+}
+
+// TestServer_forward_NoTimeout 测试超时时间为 0 时完整传输非流式响应体
+func TestServer_forward_NoTimeout(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 1<<20)
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	}))
+
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		Upstreams:   map[string]string{"app": socketPath},
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/u/app/containers/json", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, len(body), rec.Body.Len())
+}
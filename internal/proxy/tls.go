@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader 提供监听器使用的 TLS 配置，并在证书文件轮换后自动重新加载。
+//
+// TLS 握手时检查证书、私钥和客户端 CA 文件的修改时间，有变化时重新加载，
+// 两次检查至少间隔 [certCheckInterval]，避免高频握手时反复读取文件；
+// 加载失败（如证书和私钥只更新了其中一个）时继续使用原有证书，下次检查时重试。
+// 失败日志只在错误变化时记录一次，轮换期间不会按握手频率重复输出。
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	mu      sync.Mutex
+	config  *tls.Config
+	modTime [3]time.Time
+	checked time.Time
+	lastErr string
+}
+
+// certCheckInterval 是检查证书文件是否变化的最小间隔。
+const certCheckInterval = time.Second
+
+// newCertReloader 加载证书、私钥和可选的客户端 CA，返回证书重载器。
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: certCheckInterval}
+
+	modTime, err := cr.modTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cr.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// TLSConfig 返回交给 [http.Server] 的 TLS 配置，实际配置在每次握手时动态选择。
+func (cr *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return cr.current(), nil
+		},
+	}
+}
+
+// current 返回当前生效的 TLS 配置，距上次检查超过检查间隔且文件有变化时先尝试重新加载。
+func (cr *certReloader) current() *tls.Config {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cr.checked) < cr.interval {
+		return cr.config
+	}
+
+	cr.checked = now
+
+	modTime, err := cr.modTimes()
+	if err == nil && modTime != cr.modTime {
+		err = cr.load(modTime)
+		if err == nil {
+			slog.Info("TLS 证书已重新加载", "cert", cr.certFile)
+		}
+	}
+
+	switch {
+	case err == nil:
+		cr.lastErr = ""
+	case err.Error() != cr.lastErr:
+		cr.lastErr = err.Error()
+		slog.Warn("TLS 证书重新加载失败，继续使用原有证书", "cert", cr.certFile, "error", err)
+	}
+
+	return cr.config
+}
+
+// load 读取证书文件并替换当前配置，成功后记录文件修改时间。
+func (cr *certReloader) load(modTime [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// GetConfigForClient 返回的配置会完整替换服务器配置，需要显式声明 ALPN 协议
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if cr.caFile != "" {
+		pem, err := os.ReadFile(cr.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in TLS client CA %s", cr.caFile)
+		}
+
+		// 客户端证书可选：未提供证书的请求仍可访问公开路径或使用令牌认证，
+		// 由认证中间件决定是否拒绝
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	cr.config = config
+	cr.modTime = modTime
+
+	return nil
+}
+
+// modTimes 返回证书、私钥和客户端 CA 文件的修改时间。
+func (cr *certReloader) modTimes() ([3]time.Time, error) {
+	var modTime [3]time.Time
+
+	for i, file := range []string{cr.certFile, cr.keyFile, cr.caFile} {
+		if file == "" {
+			continue
+		}
+
+		fi, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+
+		modTime[i] = fi.ModTime()
+	}
+
+	return modTime, nil
+}
+
+// validateTLS 校验 TLS 相关配置的组合是否有效。
+func validateTLS(certFile, keyFile, caFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("tls_cert and tls_key must be set together")
+	}
+
+	if caFile != "" && certFile == "" {
+		return errors.New("tls_client_ca requires tls_cert and tls_key")
+	}
+
+	return nil
+}
+
+// clientCertIdentity 返回请求中已验证客户端证书的主题，未提供证书时返回空字符串。
+func clientCertIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA 是测试用的证书颁发机构。
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA 创建自签名 CA。
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发证书，返回 PEM 编码的证书和私钥。
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeServerCert 签发服务器证书并写入文件，修改时间设置为 modTime。
+func writeServerCert(t *testing.T, ca *testCA, certFile, keyFile, name string, modTime time.Time) {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, pkix.Name{CommonName: name}, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+// newTLSTestServer 使用服务器的 TLS 配置启动测试服务器。
+func newTLSTestServer(t *testing.T, server *Server, handler http.Handler) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(handler)
+	ts.TLS = server.certs.TLSConfig()
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// newTLSClient 创建信任 ca 的 HTTPS 客户端，clientCert 非空时提供客户端证书。
+func newTLSClient(ca *testCA, clientCert ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				RootCAs:      roots,
+				Certificates: clientCert,
+			},
+		},
+	}
+}
+
+// TestValidateTLS 测试 TLS 配置组合校验
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		ca      string
+		wantErr bool
+	}{
+		{"未启用", "", "", "", false},
+		{"证书和私钥", "cert.pem", "key.pem", "", false},
+		{"mTLS", "cert.pem", "key.pem", "ca.pem", false},
+		{"缺少私钥", "cert.pem", "", "", true},
+		{"缺少证书", "", "key.pem", "", true},
+		{"只有客户端 CA", "", "", "ca.pem", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTLS(tt.cert, tt.key, tt.ca)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestNewServer_InvalidTLS 测试证书无法加载时创建服务器失败
+func TestNewServer_InvalidTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewServer(&config.Config{
+		TLSCert: filepath.Join(dir, "missing.pem"),
+		TLSKey:  filepath.Join(dir, "missing.key"),
+	})
+	assert.Error(t, err)
+}
+
+// TestCertReloader_Rotation 测试证书轮换后自动重新加载
+func TestCertReloader_Rotation(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeServerCert(t, ca, certFile, keyFile, "server-v1", time.Now().Add(-time.Minute))
+
+	server, err := NewServer(&config.Config{TLSCert: certFile, TLSKey: keyFile})
+	require.NoError(t, err)
+
+	setInterval := func(d time.Duration) {
+		server.certs.mu.Lock()
+		defer server.certs.mu.Unlock()
+
+		server.certs.interval = d
+	}
+	setInterval(time.Hour)
+
+	ts := newTLSTestServer(t, server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	client := newTLSClient(ca)
+
+	serverName := func() string {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+
+		defer func() { _ = resp.Body.Close() }()
+
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	assert.Equal(t, "server-v1", serverName())
+
+	// 检查间隔内不重新读取文件
+	writeServerCert(t, ca, certFile, keyFile, "server-v2", time.Now())
+	assert.Equal(t, "server-v1", serverName())
+
+	setInterval(0)
+
+	assert.Equal(t, "server-v2", serverName())
+
+	// 私钥与证书不匹配时继续使用原有证书
+	otherCert, _ := ca.issue(t, pkix.Name{CommonName: "server-v3"}, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(certFile, otherCert, 0600))
+	require.NoError(t, os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	assert.Equal(t, "server-v2", serverName())
+}
+
+// TestServer_mTLS 测试客户端证书认证
+func TestServer_mTLS(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	untrusted := newTestCA(t, "untrusted-ca")
+	dir := t.TempDir()
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	writeServerCert(t, ca, certFile, keyFile, "server", time.Now())
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0600))
+
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		TLSCert:     certFile,
+		TLSKey:      keyFile,
+		TLSClientCA: caFile,
+		Auth: config.AuthConfig{
+			Tokens:      map[string]string{"admin": "secret"},
+			PublicPaths: []string{"/health"},
+		},
+	})
+	require.NoError(t, err)
+
+	handler := server.accessLogMiddleware(server.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"identity": requestInfoFrom(r.Context()).identity})
+	})))
+
+	ts := newTLSTestServer(t, server, handler)
+
+	clientCert := func(ca *testCA) tls.Certificate {
+		certPEM, keyPEM := ca.issue(t, pkix.Name{CommonName: "ci", Organization: []string{"acme"}}, x509.ExtKeyUsageClientAuth)
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+
+		return cert
+	}
+
+	get := func(client *http.Client, path string, header ...string) (int, string) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, ""
+		}
+
+		defer func() { _ = resp.Body.Close() }()
+
+		var body map[string]string
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+
+		return resp.StatusCode, body["identity"]
+	}
+
+	t.Run("客户端证书主题作为身份标识", func(t *testing.T) {
+		status, identity := get(newTLSClient(ca, clientCert(ca)), "/proxy")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "CN=ci,O=acme", identity)
+	})
+
+	t.Run("无证书时可使用令牌", func(t *testing.T) {
+		status, identity := get(newTLSClient(ca), "/proxy", "Authorization", "Bearer secret")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "admin", identity)
+	})
+
+	t.Run("无证书无令牌", func(t *testing.T) {
+		status, _ := get(newTLSClient(ca), "/proxy")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("公开路径无需证书", func(t *testing.T) {
+		status, _ := get(newTLSClient(ca), "/health")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("不受信任的客户端证书在握手时被拒绝", func(t *testing.T) {
+		cert := clientCert(untrusted)
+		client := newTLSClient(ca)
+
+		// 客户端默认不发送不在服务器 CA 列表中的证书，这里强制发送
+		client.Transport.(*http.Transport).TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+
+		status, _ := get(client, "/health")
+		assert.Zero(t, status)
+	})
+}
+
+// TestServer_Run_TLS 测试 TCP 监听器以 HTTPS 提供服务，Unix 监听器不启用 TLS
+func TestServer_Run_TLS(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+
+	dir, err := os.MkdirTemp("", "uds")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	socketPath := filepath.Join(dir, "proxy.sock")
+
+	writeServerCert(t, ca, certFile, keyFile, "server", time.Now())
+
+	server, err := NewServer(&config.Config{
+		NoAccessLog: true,
+		TLSCert:     certFile,
+		TLSKey:      keyFile,
+		Listeners: []config.ListenerConfig{
+			{Network: "tcp", Address: "127.0.0.1:0"},
+			{Network: "unix", Address: socketPath},
+		},
+	})
+	require.NoError(t, err)
+
+	go func() { _ = server.Run() }()
+
+	t.Cleanup(server.Shutdown)
+
+	var port int
+
+	// actualPort is written before httpServer is published under mu
+	require.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		if server.httpServer == nil {
+			return false
+		}
+
+		port = server.actualPort
+
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+		fmt.Sprintf("https://127.0.0.1:%d/health", port), nil)
+	require.NoError(t, err)
+
+	resp, err := newTLSClient(ca).Do(req)
+	require.NoError(t, err)
+
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, resp.TLS)
+
+	// Unix 监听器使用明文 HTTP
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+
+	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/health", nil)
+	require.NoError(t, err)
+
+	resp, err = unixClient.Do(req)
+	require.NoError(t, err)
+
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 追踪导出方式。
+const (
+	exporterOTLPHTTP = "otlp-http"
+	exporterOTLPGRPC = "otlp-grpc"
+	exporterStdout   = "stdout"
+	exporterFile     = "file"
+)
+
+// tracerName 是 span 的 instrumentation scope 名称。
+const tracerName = "github.com/lwmacct/251124-uds-proxy/internal/proxy"
+
+// Tracing 为代理请求生成 OpenTelemetry span。
+//
+// 每个入站请求对应一个 server span；每次后端请求尝试对应一个 client span，
+// 其下的 dial span 记录建立套接字连接的耗时（复用连接时没有），first_byte 事件记录收到首字节的时间；
+// 回写响应体对应一个 copy span。W3C Trace Context 从客户端请求头中提取，并注入到发往后端的请求头。
+//
+// 所有方法在接收者为 nil 时什么也不做，未启用追踪时无需判空，也不会创建任何 span。
+type Tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	file       *os.File // file 导出方式写入的文件
+}
+
+// NewTracing 按 cfg 创建追踪。
+// 导出方式未知、采样比例超出范围或 file 导出方式缺少文件路径时返回错误。
+func NewTracing(cfg config.TracingConfig) (*Tracing, error) {
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing sample ratio %v must be between 0 and 1", cfg.SampleRatio)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tracing{propagator: propagation.TraceContext{}}
+
+	exporter, err := t.newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	t.tracer = t.provider.Tracer(tracerName)
+
+	return t, nil
+}
+
+// newExporter 创建 cfg 指定的导出器。
+func (t *Tracing) newExporter(cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	ctx := context.Background()
+	hasScheme := strings.Contains(cfg.Endpoint, "://")
+
+	switch cfg.Exporter {
+	case exporterOTLPHTTP:
+		var opts []otlptracehttp.Option
+
+		switch {
+		case hasScheme:
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(ctx, opts...)
+	case exporterOTLPGRPC:
+		var opts []otlptracegrpc.Option
+
+		switch {
+		case hasScheme:
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		return otlptracegrpc.New(ctx, opts...)
+	case exporterStdout:
+		return stdouttrace.New()
+	case exporterFile:
+		if cfg.File == "" {
+			return nil, errors.New("tracing file exporter requires a file path")
+		}
+
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tracing file: %w", err)
+		}
+
+		t.file = f
+
+		return stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+}
+
+// Shutdown 导出尚未发送的 span 并关闭导出器。
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	err := t.provider.Shutdown(ctx)
+
+	if t.file != nil {
+		err = errors.Join(err, t.file.Close())
+	}
+
+	return err
+}
+
+// startRequest 从请求头提取 trace context，开始入站请求的 server span。
+func (t *Tracing) startRequest(ctx context.Context, r *http.Request) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	ctx = t.propagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, _ = t.tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+
+	return ctx
+}
+
+// endRequest 记录响应状态码、目标套接字和网关错误码，结束 server span。
+func (t *Tracing) endRequest(ctx context.Context, status int, info *requestInfo) {
+	if t == nil {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		semconv.HTTPResponseStatusCode(status),
+		attribute.String("uds_proxy.request_id", info.id),
+	)
+
+	if info.socket != "" {
+		span.SetAttributes(attribute.String("uds_proxy.socket", info.socket))
+	}
+
+	if info.errorCode != "" {
+		span.SetAttributes(semconv.ErrorTypeKey.String(info.errorCode))
+	}
+
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, info.errorCode)
+	}
+
+	span.End()
+}
+
+// startUpstream 开始第 attempt 次后端请求尝试的 client span，
+// 并通过 [httptrace] 记录建立连接和收到首字节的时间。
+func (t *Tracing) startUpstream(ctx context.Context, target proxyTarget, attempt int) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	ctx, span := t.tracer.Start(ctx, target.method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(target.method),
+			semconv.URLFull(target.url),
+			semconv.NetworkTransportUnix,
+			semconv.ServerAddress(target.socketPath),
+			semconv.HTTPRequestResendCount(attempt-1),
+			attribute.String("uds_proxy.socket", target.name),
+		),
+	)
+
+	var dial trace.Span
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) {
+			_, dial = t.tracer.Start(ctx, "dial", trace.WithSpanKind(trace.SpanKindClient))
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if dial == nil {
+				return
+			}
+
+			if err != nil {
+				dial.RecordError(err)
+				dial.SetStatus(codes.Error, err.Error())
+			}
+
+			dial.End()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.SetAttributes(attribute.Bool("uds_proxy.conn_reused", info.Reused))
+		},
+		GotFirstResponseByte: func() {
+			span.AddEvent("first_byte")
+		},
+	})
+}
+
+// endUpstream 记录后端响应或错误，结束 client span。
+func (t *Tracing) endUpstream(ctx context.Context, resp *http.Response, err error) {
+	if t == nil {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, upstreamErrorCode(err))
+	case resp != nil:
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+
+	span.End()
+}
+
+// inject 将 ctx 中当前 span 的 trace context 写入后端请求头，替换客户端传入的 traceparent。
+func (t *Tracing) inject(ctx context.Context, header http.Header) {
+	if t == nil {
+		return
+	}
+
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// startCopy 开始回写后端响应体的 span。
+func (t *Tracing) startCopy(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	ctx, _ = t.tracer.Start(ctx, "copy body")
+
+	return ctx
+}
+
+// endCopy 记录回写的字节数和错误，结束 copy span。
+func (t *Tracing) endCopy(ctx context.Context, n int64, err error) {
+	if t == nil {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("uds_proxy.body_bytes", n))
+
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportedSpan 是 file 导出方式写入的 span 中测试关心的字段。
+type exportedSpan struct {
+	Name        string
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	Parent struct {
+		TraceID string
+		SpanID  string
+	}
+	Events []struct {
+		Name string
+	}
+	Attributes []struct {
+		Key   string
+		Value struct {
+			Value any
+		}
+	}
+}
+
+// attr 返回 span 属性 key 的值。
+func (s exportedSpan) attr(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+
+	return nil
+}
+
+// readSpans 读取 file 导出方式写入的全部 span，按名称索引。
+func readSpans(t *testing.T, path string) map[string][]exportedSpan {
+	t.Helper()
+
+	f, err := os.Open(path) //nolint:gosec // test file with controlled path
+	require.NoError(t, err)
+
+	defer func() { _ = f.Close() }()
+
+	spans := make(map[string][]exportedSpan)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var span exportedSpan
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &span))
+
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+
+	require.NoError(t, scanner.Err())
+
+	return spans
+}
+
+// TestNewTracing 测试追踪配置的校验
+func TestNewTracing(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TracingConfig
+	}{
+		{"未知导出方式", config.TracingConfig{Exporter: "zipkin", SampleRatio: 1}},
+		{"采样比例超出范围", config.TracingConfig{Exporter: exporterStdout, SampleRatio: 1.5}},
+		{"file 缺少路径", config.TracingConfig{Exporter: exporterFile, SampleRatio: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTracing(tt.cfg)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("未启用时不创建", func(t *testing.T) {
+		server, err := NewServer(&config.Config{})
+		require.NoError(t, err)
+		assert.Nil(t, server.tracing)
+	})
+}
+
+// TestServer_Tracing 测试代理请求的 span 和 trace context 的传递
+func TestServer_Tracing(t *testing.T) {
+	const (
+		clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		clientSpanID  = "00f067aa0ba902b7"
+	)
+
+	var backendTraceparent string
+
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendTraceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	newTracedServer := func(t *testing.T, sampleRatio float64) (http.Handler, func() map[string][]exportedSpan) {
+		t.Helper()
+
+		file := filepath.Join(t.TempDir(), "spans.jsonl")
+
+		server, err := NewServer(&config.Config{
+			Timeout:     1000,
+			NoAccessLog: true,
+			Upstreams:   map[string]string{"app": socketPath},
+			Tracing: config.TracingConfig{
+				Exporter:    exporterFile,
+				File:        file,
+				SampleRatio: sampleRatio,
+				ServiceName: "uds-proxy",
+			},
+		})
+		require.NoError(t, err)
+
+		flush := func() map[string][]exportedSpan {
+			require.NoError(t, server.tracing.Shutdown(t.Context()))
+
+			return readSpans(t, file)
+		}
+
+		return server.accessLogMiddleware(server.routes()), flush
+	}
+
+	t.Run("继续客户端的 trace", func(t *testing.T) {
+		handler, flush := newTracedServer(t, 1)
+
+		req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+		req.Header.Set("traceparent", "00-"+clientTraceID+"-"+clientSpanID+"-01")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, clientTraceID, rec.Header().Get(requestIDHeader))
+
+		spans := flush()
+
+		require.Len(t, spans["GET"], 2)
+
+		var inbound, upstream exportedSpan
+
+		for _, span := range spans["GET"] {
+			if span.Parent.SpanID == clientSpanID {
+				inbound = span
+			} else {
+				upstream = span
+			}
+		}
+
+		assert.Equal(t, clientTraceID, inbound.SpanContext.TraceID)
+		assert.InDelta(t, 200, inbound.attr("http.response.status_code"), 0)
+		assert.Equal(t, "app", inbound.attr("uds_proxy.socket"))
+
+		assert.Equal(t, inbound.SpanContext.SpanID, upstream.Parent.SpanID)
+		assert.Equal(t, socketPath, upstream.attr("server.address"))
+		require.Len(t, upstream.Events, 1)
+		assert.Equal(t, "first_byte", upstream.Events[0].Name)
+
+		require.Len(t, spans["dial"], 1)
+		assert.Equal(t, upstream.SpanContext.SpanID, spans["dial"][0].Parent.SpanID)
+
+		require.Len(t, spans["copy body"], 1)
+		assert.Equal(t, inbound.SpanContext.SpanID, spans["copy body"][0].Parent.SpanID)
+		assert.InDelta(t, 2, spans["copy body"][0].attr("uds_proxy.body_bytes"), 0)
+
+		// The backend sees the upstream span as its parent
+		assert.Equal(t, "00-"+clientTraceID+"-"+upstream.SpanContext.SpanID+"-01", backendTraceparent)
+	})
+
+	t.Run("新 trace 按比例采样", func(t *testing.T) {
+		handler, flush := newTracedServer(t, 0)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/u/app/info", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		// Unsampled traces are still propagated so the backend can correlate
+		id := rec.Header().Get(requestIDHeader)
+		assert.Regexp(t, "^00-"+id+"-[0-9a-f]{16}-00$", backendTraceparent)
+		assert.Empty(t, flush())
+	})
+
+	t.Run("客户端已采样时沿用其决定", func(t *testing.T) {
+		handler, flush := newTracedServer(t, 0)
+
+		req := httptest.NewRequest(http.MethodGet, "/u/app/info", nil)
+		req.Header.Set("traceparent", "00-"+clientTraceID+"-"+clientSpanID+"-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Len(t, flush()["GET"], 2)
+	})
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Docker 在 attach、exec 等接口升级连接后使用的原始流内容类型。
+const (
+	dockerRawStream         = "application/vnd.docker.raw-stream"
+	dockerMultiplexedStream = "application/vnd.docker.multiplexed-stream"
+)
+
+// isUpgradeRequest 报告请求是否要求协议升级（Connection: Upgrade），
+// 例如 WebSocket 或 Docker attach/exec 的 Upgrade: tcp。
+func isUpgradeRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+// headerContainsToken 报告逗号分隔的请求头中是否包含指定令牌（不区分大小写）。
+func headerContainsToken(h http.Header, key, token string) bool {
+	for _, value := range h.Values(key) {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isRawStream 报告后端响应是否已切换为双向原始字节流。
+// 除 101 Switching Protocols 外，Docker 在升级请求上返回的原始流内容类型也视为已切换。
+func isRawStream(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+
+	return mediaType == dockerRawStream || mediaType == dockerMultiplexedStream
+}
+
+// tunnel 处理协议升级请求。
+//
+// 由于 [http.Client] 无法接管升级后的连接，tunnel 直接拨号 Unix 套接字并写入请求。
+// 后端同意升级后，接管（hijack）客户端连接，在两端之间双向复制字节，
+// 直到后端关闭连接或任意一端出错；客户端半关闭写方向时会同步半关闭后端写方向。
+// 后端拒绝升级时，其响应按普通响应透传。
+//
+// done 在握手结束后以握手结果调用且只调用一次，用于熔断器统计和释放并发名额；
+// 升级后的连接持续时间不计入。请求未到达后端时以 [context.Canceled] 调用，不计入统计。
+func (s *Server) tunnel(w http.ResponseWriter, r *http.Request, target proxyTarget, done func(error)) {
+	backendConn, backendBuf, resp, err := s.upgradeHandshake(w, r, target)
+	done(err)
+
+	if err != nil {
+		return
+	}
+
+	defer func() { _ = backendConn.Close() }()
+
+	log := loggerFrom(r.Context())
+
+	// Backend declined the upgrade: relay it as an ordinary response
+	if !isRawStream(resp) {
+		defer func() { _ = resp.Body.Close() }()
+
+		copyHeader(w.Header(), resp.Header)
+		w.Header().Set(requestIDHeader, RequestIDFromContext(r.Context()))
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+
+		return
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Error("接管客户端连接失败", "error", err)
+		s.writeError(w, r, codeProxyError, target)
+
+		return
+	}
+
+	defer func() { _ = clientConn.Close() }()
+
+	// http.Server does not track hijacked connections; close them on shutdown
+	s.activeTunnels.Add(1)
+	defer s.activeTunnels.Add(-1)
+	defer s.untilShutdown(func() { _ = backendConn.Close() })()
+
+	// The server may have set deadlines on the connection before hijacking
+	_ = clientConn.SetDeadline(time.Time{})
+
+	// Relay the status line and headers as sent by the backend, plus the request ID
+	resp.Header.Set(requestIDHeader, RequestIDFromContext(r.Context()))
+
+	if _, err := clientBuf.WriteString("HTTP/1.1 " + resp.Status + "\r\n"); err != nil {
+		return
+	}
+
+	if err := resp.Header.Write(clientBuf); err != nil {
+		return
+	}
+
+	if _, err := clientBuf.WriteString("\r\n"); err != nil {
+		return
+	}
+
+	if err := clientBuf.Flush(); err != nil {
+		return
+	}
+
+	log.Debug("连接已升级", "socket", target.socketPath, "status", resp.StatusCode)
+
+	info := requestInfoFrom(r.Context())
+	info.upgradedIn, info.upgradedOut = pipe(clientConn, clientBuf.Reader, backendConn, backendBuf)
+}
+
+// upgradeHandshake 拨号 target 并发送升级请求，返回后端连接、连接上的缓冲读取器和后端响应。
+//
+// 失败时已写入错误响应并关闭后端连接，返回的响应为 nil，错误作为握手结果；
+// 请求未到达后端时返回 [context.Canceled]。
+func (s *Server) upgradeHandshake(w http.ResponseWriter, r *http.Request, target proxyTarget) (net.Conn, *bufio.Reader, *http.Response, error) {
+	log := loggerFrom(r.Context())
+	timeout := s.settingsFor(r).timeout()
+	dialer := net.Dialer{Timeout: timeout}
+
+	// The handshake is traced like an ordinary round trip; the tunnel itself is not
+	ctx := s.tracing.startUpstream(r.Context(), target, 1)
+
+	backendConn, err := dialer.DialContext(ctx, "unix", target.socketPath)
+	if err != nil {
+		s.tracing.endUpstream(ctx, nil, err)
+		requestInfoFrom(ctx).upstreamErr = err.Error()
+		s.metrics.upstreamError(target.name, err)
+		log.Warn("连接失败", "socket", target.socketPath, "error", err)
+		s.writeError(w, r, upstreamErrorCode(err), target)
+
+		return nil, nil, nil, err
+	}
+
+	// Connection and Upgrade headers are kept so the backend sees the upgrade
+	backendReq, err := newBackendRequest(ctx, r, target)
+	if err != nil {
+		_ = backendConn.Close()
+		s.tracing.endUpstream(ctx, nil, err)
+		log.Error("创建请求失败", "error", err)
+		s.writeError(w, r, codeProxyError, target)
+
+		// Never reached the backend: release a half-open probe without a verdict
+		return nil, nil, nil, context.Canceled
+	}
+
+	// Bound the handshake by the request timeout
+	if timeout > 0 {
+		_ = backendConn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	s.tracing.inject(ctx, backendReq.Header)
+
+	if err := backendReq.Write(backendConn); err != nil {
+		_ = backendConn.Close()
+		s.tracing.endUpstream(ctx, nil, err)
+		requestInfoFrom(ctx).upstreamErr = err.Error()
+		log.Warn("写入升级请求失败", "socket", target.socketPath, "error", err)
+		s.writeError(w, r, upstreamErrorCode(err), target)
+
+		return nil, nil, nil, err
+	}
+
+	backendBuf := bufio.NewReader(backendConn)
+
+	resp, err := http.ReadResponse(backendBuf, backendReq)
+	s.tracing.endUpstream(ctx, resp, err)
+
+	if err != nil {
+		_ = backendConn.Close()
+		requestInfoFrom(ctx).upstreamErr = err.Error()
+		s.metrics.upstreamError(target.name, err)
+
+		if os.IsTimeout(err) {
+			log.Warn("请求超时", "socket", target.socketPath, "error", err)
+		} else {
+			log.Warn("读取升级响应失败", "socket", target.socketPath, "error", err)
+		}
+
+		s.writeError(w, r, upstreamErrorCode(err), target)
+
+		return nil, nil, nil, err
+	}
+
+	_ = backendConn.SetDeadline(time.Time{})
+
+	return backendConn, backendBuf, resp, nil
+}
+
+// pipe 在客户端连接和后端连接之间双向复制字节。
+//
+// clientReader 和 backendReader 分别包含两端已缓冲但尚未转发的数据。
+// 客户端方向结束时半关闭后端写方向，让后端感知输入结束并继续输出；
+// 后端方向结束时关闭两端连接。函数在两个方向都结束后返回，
+// 返回值分别为发往后端和发往客户端的字节数。
+func pipe(clientConn net.Conn, clientReader io.Reader, backendConn net.Conn, backendReader io.Reader) (int64, int64) {
+	done := make(chan struct{})
+
+	var toBackend int64
+
+	go func() {
+		defer close(done)
+
+		toBackend, _ = io.Copy(backendConn, clientReader)
+
+		if cw, ok := backendConn.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		}
+	}()
+
+	toClient, _ := io.Copy(clientConn, backendReader)
+
+	_ = clientConn.Close()
+	_ = backendConn.Close()
+
+	<-done
+
+	return toBackend, toClient
+}
+
+// copyHeader 将 src 中的所有响应头追加到 dst。
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		for _, v := range values {
+			dst.Add(key, v)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lwmacct/251124-uds-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upgradeBackend 模拟支持协议升级的后端：
+// 返回 101 后回显客户端发送的数据，读到 EOF 时写入 "bye" 并关闭连接。
+// contentType 非空时在 101 响应中附带该内容类型（模拟 Docker 原始流）。
+func upgradeBackend(t *testing.T, contentType string) http.Handler {
+	t.Helper()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer func() { _ = conn.Close() }()
+
+		_, _ = buf.WriteString("HTTP/1.1 101 UPGRADED\r\n")
+		if contentType != "" {
+			_, _ = buf.WriteString("Content-Type: " + contentType + "\r\n")
+		}
+
+		_, _ = buf.WriteString("Connection: Upgrade\r\nUpgrade: " + r.Header.Get("Upgrade") + "\r\n\r\n")
+		_ = buf.Flush()
+
+		_, _ = io.Copy(conn, buf.Reader)
+		_, _ = conn.Write([]byte("bye"))
+	})
+}
+
+// dialUpgrade 连接代理并发送升级请求，返回连接和已读取的升级响应。
+func dialUpgrade(t *testing.T, proxyURL, path, upgrade string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", proxyURL[len("http://"):])
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, err := http.NewRequest(http.MethodPost, proxyURL+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", upgrade)
+	require.NoError(t, req.Write(conn))
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, req)
+	require.NoError(t, err)
+
+	return conn, reader, resp
+}
+
+// TestIsUpgradeRequest 测试升级请求识别
+func TestIsUpgradeRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection string
+		want       bool
+	}{
+		{"Upgrade", "Upgrade", true},
+		{"小写", "upgrade", true},
+		{"多个令牌", "keep-alive, Upgrade", true},
+		{"普通请求", "keep-alive", false},
+		{"无 Connection 头", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.connection != "" {
+				req.Header.Set("Connection", tt.connection)
+			}
+
+			assert.Equal(t, tt.want, isUpgradeRequest(req))
+		})
+	}
+}
+
+// TestServer_tunnel 测试协议升级透传
+func TestServer_tunnel(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"WebSocket 风格升级", ""},
+		{"Docker 原始流", dockerRawStream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socketPath := newUnixBackend(t, upgradeBackend(t, tt.contentType))
+
+			server, err := NewServer(&config.Config{
+				Timeout:   2000,
+				Upstreams: map[string]string{"docker": socketPath},
+			})
+			require.NoError(t, err)
+
+			proxy := httptest.NewServer(server.accessLogMiddleware(server.routes()))
+			t.Cleanup(proxy.Close)
+
+			conn, reader, resp := dialUpgrade(t, proxy.URL, "/u/docker/containers/abc/attach?stream=1", "tcp")
+
+			require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			assert.Equal(t, "tcp", resp.Header.Get("Upgrade"))
+
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			}
+
+			// 双向传输
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(reader, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(buf))
+
+			// 客户端半关闭后，后端仍能继续输出直到关闭
+			tcpConn, ok := conn.(*net.TCPConn)
+			require.True(t, ok)
+			require.NoError(t, tcpConn.CloseWrite())
+
+			rest, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "bye", string(rest))
+		})
+	}
+}
+
+// TestServer_tunnel_Result 测试握手结果如何报告给熔断器：只有后端作出响应才算成功
+func TestServer_tunnel_Result(t *testing.T) {
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	server, err := NewServer(&config.Config{Timeout: 2000, NoAccessLog: true})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		target  proxyTarget
+		wantErr func(t *testing.T, err error)
+	}{
+		{
+			"后端作出响应",
+			proxyTarget{socketPath: socketPath, method: http.MethodGet, url: "http://localhost/attach"},
+			func(t *testing.T, err error) { assert.NoError(t, err) },
+		},
+		{
+			"无法构建请求",
+			proxyTarget{socketPath: socketPath, method: http.MethodGet, url: "http://localhost/%zz"},
+			func(t *testing.T, err error) { assert.ErrorIs(t, err, context.Canceled) },
+		},
+		{
+			"连接失败",
+			proxyTarget{socketPath: newDeadSocket(t), method: http.MethodGet, url: "http://localhost/attach"},
+			func(t *testing.T, err error) {
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, context.Canceled)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/u/docker/attach", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "tcp")
+
+			var (
+				calls int
+				got   error
+			)
+
+			server.tunnel(httptest.NewRecorder(), req, tt.target, func(err error) {
+				got = err
+				calls++
+			})
+
+			require.Equal(t, 1, calls)
+			tt.wantErr(t, got)
+		})
+	}
+}
+
+// TestServer_tunnel_Declined 测试后端拒绝升级时按普通响应透传
+func TestServer_tunnel_Declined(t *testing.T) {
+	socketPath := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("no such container"))
+	}))
+
+	server, err := NewServer(&config.Config{
+		Timeout:   2000,
+		Upstreams: map[string]string{"docker": socketPath},
+	})
+	require.NoError(t, err)
+
+	proxy := httptest.NewServer(server.routes())
+	t.Cleanup(proxy.Close)
+
+	_, _, resp := dialUpgrade(t, proxy.URL, "/u/docker/containers/missing/attach", "tcp")
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "no such container", string(body))
+}
@@ -0,0 +1,93 @@
+// Package systemd 实现 systemd 的套接字激活和 sd_notify 协议。
+//
+// 两者都直接基于环境变量和原始协议实现，不依赖 libsystemd：
+//   - 套接字激活：读取 LISTEN_PID、LISTEN_FDS 和 LISTEN_FDNAMES，
+//     将从文件描述符 3 开始传入的套接字包装为 [net.Listener]
+//   - 状态通知：向 NOTIFY_SOCKET 指定的 Unix 数据报套接字发送 READY=1 等状态
+//
+// 不在 systemd 下运行时，这些函数什么也不做。
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart 是 systemd 传入的第一个文件描述符编号（SD_LISTEN_FDS_START）。
+const listenFDsStart = 3
+
+// Listener 是通过套接字激活传入的监听器。
+type Listener struct {
+	net.Listener
+
+	// Name 是套接字在 LISTEN_FDNAMES 中的名称（对应 unit 中的 FileDescriptorName=），
+	// 未设置时由 systemd 默认为 socket unit 名称
+	Name string
+}
+
+// Listeners 返回 systemd 通过套接字激活传入的监听器。
+//
+// 未使用套接字激活（未设置 LISTEN_FDS，或 LISTEN_PID 不是当前进程）时返回空切片。
+// 读取后会清除相关环境变量，避免子进程误用这些文件描述符。
+func Listeners() ([]Listener, error) {
+	defer unsetListenEnv()
+
+	return listeners(os.Getenv, os.Getpid(), listenFDsStart)
+}
+
+// listeners 从 getenv 读取套接字激活参数，将 fdStart 开始的文件描述符包装为监听器。
+func listeners(getenv func(string) string, pid, fdStart int) ([]Listener, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	var names []string
+	if v := getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	result := make([]Listener, 0, count)
+
+	for i := range count {
+		fd := fdStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+
+		l, err := net.FileListener(file)
+		_ = file.Close()
+
+		if err != nil {
+			for _, prev := range result {
+				_ = prev.Close()
+			}
+
+			return nil, fmt.Errorf("file descriptor %d (%s) is not a listening socket: %w", fd, name, err)
+		}
+
+		result = append(result, Listener{Listener: l, Name: name})
+	}
+
+	return result, nil
+}
+
+// unsetListenEnv 清除套接字激活相关的环境变量。
+func unsetListenEnv() {
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(key)
+	}
+}
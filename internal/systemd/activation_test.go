@@ -0,0 +1,156 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFDStart 是测试中模拟 systemd 传入套接字使用的起始文件描述符，避免与进程已有的描述符冲突。
+const testFDStart = 200
+
+// passFiles 将文件复制到从 testFDStart 开始的连续文件描述符，模拟 systemd 传入套接字。
+func passFiles(t *testing.T, files ...*os.File) {
+	t.Helper()
+
+	for i, f := range files {
+		fd := testFDStart + i
+		require.NoError(t, syscall.Dup3(int(f.Fd()), fd, 0))
+		_ = f.Close()
+
+		t.Cleanup(func() { _ = syscall.Close(fd) })
+	}
+}
+
+// listenerFile 返回监听器底层套接字的文件副本，并关闭原监听器。
+func listenerFile(t *testing.T, network, address string) *os.File {
+	t.Helper()
+
+	l, err := net.Listen(network, address)
+	require.NoError(t, err)
+
+	var file *os.File
+
+	switch l := l.(type) {
+	case *net.TCPListener:
+		file, err = l.File()
+	case *net.UnixListener:
+		l.SetUnlinkOnClose(false)
+		file, err = l.File()
+	}
+
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	return file
+}
+
+// env 返回从 map 读取环境变量的函数。
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+// TestListeners 测试套接字激活传入的监听器
+func TestListeners(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sd")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "proxy.sock")
+
+	passFiles(t, listenerFile(t, "tcp", "127.0.0.1:0"), listenerFile(t, "unix", socketPath))
+
+	pid := os.Getpid()
+
+	result, err := listeners(env(map[string]string{
+		"LISTEN_PID":     strconv.Itoa(pid),
+		"LISTEN_FDS":     "2",
+		"LISTEN_FDNAMES": "http:docker",
+	}), pid, testFDStart)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+
+	t.Cleanup(func() {
+		for _, l := range result {
+			_ = l.Close()
+		}
+	})
+
+	assert.Equal(t, "http", result[0].Name)
+	assert.Equal(t, "tcp", result[0].Addr().Network())
+	assert.Equal(t, "docker", result[1].Name)
+	assert.Equal(t, socketPath, result[1].Addr().String())
+
+	// 监听器可以正常接受连接
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+
+	_ = conn.Close()
+
+	accepted, err := result[1].Accept()
+	require.NoError(t, err)
+
+	_ = accepted.Close()
+}
+
+// TestListeners_NotActivated 测试未使用套接字激活的情况
+func TestListeners_NotActivated(t *testing.T) {
+	pid := os.Getpid()
+
+	tests := []struct {
+		name string
+		vars map[string]string
+	}{
+		{"未设置环境变量", map[string]string{}},
+		{"LISTEN_PID 不是当前进程", map[string]string{"LISTEN_PID": strconv.Itoa(pid + 1), "LISTEN_FDS": "1"}},
+		{"LISTEN_FDS 无效", map[string]string{"LISTEN_PID": strconv.Itoa(pid), "LISTEN_FDS": "x"}},
+		{"LISTEN_FDS 为 0", map[string]string{"LISTEN_PID": strconv.Itoa(pid), "LISTEN_FDS": "0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := listeners(env(tt.vars), pid, testFDStart)
+			require.NoError(t, err)
+			assert.Empty(t, result)
+		})
+	}
+}
+
+// TestListeners_NotSocket 测试传入的文件描述符不是监听套接字
+func TestListeners_NotSocket(t *testing.T) {
+	file, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+
+	passFiles(t, listenerFile(t, "tcp", "127.0.0.1:0"), file)
+
+	pid := os.Getpid()
+
+	_, err = listeners(env(map[string]string{
+		"LISTEN_PID": strconv.Itoa(pid),
+		"LISTEN_FDS": "2",
+	}), pid, testFDStart)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "LISTEN_FD_201")
+}
+
+// TestListeners_UnsetEnv 测试读取后清除环境变量
+func TestListeners_UnsetEnv(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "http")
+
+	result, err := Listeners()
+	require.NoError(t, err)
+	assert.Empty(t, result)
+
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_, ok := os.LookupEnv(key)
+		assert.False(t, ok, key)
+	}
+}
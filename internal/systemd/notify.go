@@ -0,0 +1,67 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sd_notify 状态。
+const (
+	// Ready 表示服务已完成启动，Type=notify 的 unit 收到后才视为启动成功
+	Ready = "READY=1"
+	// Stopping 表示服务开始关闭
+	Stopping = "STOPPING=1"
+	// Watchdog 是看门狗心跳，需要在 WatchdogSec= 内周期性发送
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify 向 NOTIFY_SOCKET 发送状态通知。
+//
+// 未设置 NOTIFY_SOCKET（不在 systemd 下运行或 unit 不是 Type=notify）时返回 false 和 nil。
+// 以 "@" 开头的地址表示 Linux 抽象命名空间。
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	dialer := net.Dialer{}
+
+	conn, err := dialer.DialContext(context.Background(), "unixgram", socket)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval 返回 systemd 要求的看门狗超时时间。
+//
+// 未启用看门狗（未设置 WATCHDOG_USEC，或 WATCHDOG_PID 不是当前进程）时返回 0。
+// 调用方应以不超过该时间一半的间隔发送 [Watchdog]。
+func WatchdogInterval() time.Duration {
+	return watchdogInterval(os.Getenv, os.Getpid())
+}
+
+// watchdogInterval 从 getenv 读取看门狗参数。
+func watchdogInterval(getenv func(string) string, pid int) time.Duration {
+	if p := getenv("WATCHDOG_PID"); p != "" && p != strconv.Itoa(pid) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,92 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newNotifySocket 创建模拟 systemd 的通知套接字，设置 NOTIFY_SOCKET 并返回用于读取通知的连接。
+func newNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "sd")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	return conn
+}
+
+// TestNotify 测试发送状态通知
+func TestNotify(t *testing.T) {
+	conn := newNotifySocket(t)
+
+	for _, state := range []string{Ready, Watchdog, Stopping} {
+		sent, err := Notify(state)
+		require.NoError(t, err)
+		assert.True(t, sent)
+
+		buf := make([]byte, 64)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, state, string(buf[:n]))
+	}
+}
+
+// TestNotify_NoSocket 测试未设置 NOTIFY_SOCKET 时不发送
+func TestNotify_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	sent, err := Notify(Ready)
+	require.NoError(t, err)
+	assert.False(t, sent)
+}
+
+// TestNotify_Unreachable 测试通知套接字不可达
+func TestNotify_Unreachable(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+
+	sent, err := Notify(Ready)
+	require.Error(t, err)
+	assert.False(t, sent)
+}
+
+// TestWatchdogInterval 测试看门狗超时解析
+func TestWatchdogInterval(t *testing.T) {
+	pid := os.Getpid()
+
+	tests := []struct {
+		name string
+		vars map[string]string
+		want time.Duration
+	}{
+		{"未启用", map[string]string{}, 0},
+		{"启用", map[string]string{"WATCHDOG_USEC": "30000000"}, 30 * time.Second},
+		{"指定当前进程", map[string]string{"WATCHDOG_USEC": "1000", "WATCHDOG_PID": strconv.Itoa(pid)}, time.Millisecond},
+		{"指定其他进程", map[string]string{"WATCHDOG_USEC": "1000", "WATCHDOG_PID": strconv.Itoa(pid + 1)}, 0},
+		{"无效值", map[string]string{"WATCHDOG_USEC": "abc"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, watchdogInterval(env(tt.vars), pid))
+		})
+	}
+}